@@ -12,12 +12,16 @@ import (
 
 	"github.com/joho/godotenv"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+
 	"github.com/3box/pipeline-tools/cd/manager"
 	"github.com/3box/pipeline-tools/cd/manager/common"
 	"github.com/3box/pipeline-tools/cd/manager/common/aws/apigw"
 	"github.com/3box/pipeline-tools/cd/manager/common/aws/config"
 	"github.com/3box/pipeline-tools/cd/manager/common/aws/ddb"
 	"github.com/3box/pipeline-tools/cd/manager/common/aws/ecs"
+	"github.com/3box/pipeline-tools/cd/manager/common/mem"
+	"github.com/3box/pipeline-tools/cd/manager/common/sqldb"
 	"github.com/3box/pipeline-tools/cd/manager/jobmanager"
 	"github.com/3box/pipeline-tools/cd/manager/notifs"
 	"github.com/3box/pipeline-tools/cd/manager/repository"
@@ -33,11 +37,14 @@ func main() {
 	waitGroup := new(sync.WaitGroup)
 	shutdownChan := make(chan bool)
 
+	// Live job updates are published to API clients as well as through the regular notification channels
+	events := notifs.NewJobEvents()
+
 	// Create and initialize the job queue before the API handling
-	m := createJobQueue(waitGroup, shutdownChan)
+	m := createJobQueue(waitGroup, shutdownChan, events)
 
 	// Setup API handling
-	serverInstance := startServer(waitGroup, m)
+	serverInstance := startServer(waitGroup, m, events)
 
 	// Shutdown processing
 	waitGroup.Add(1)
@@ -51,7 +58,7 @@ func main() {
 	waitGroup.Wait()
 }
 
-func startServer(waitGroup *sync.WaitGroup, m manager.Manager) *http.Server {
+func startServer(waitGroup *sync.WaitGroup, m manager.Manager, events manager.EventStream) *http.Server {
 	serverAddress := os.Getenv("SERVER_ADDR")
 	if len(serverAddress) == 0 {
 		serverAddress = "0.0.0.0"
@@ -60,7 +67,7 @@ func startServer(waitGroup *sync.WaitGroup, m manager.Manager) *http.Server {
 	if len(serverPort) == 0 {
 		serverPort = "8080"
 	}
-	serverInstance := server.Setup(serverAddress+":"+serverPort, m)
+	serverInstance := server.Setup(serverAddress+":"+serverPort, m, events)
 	waitGroup.Add(1)
 	go func() {
 		defer waitGroup.Done()
@@ -71,13 +78,13 @@ func startServer(waitGroup *sync.WaitGroup, m manager.Manager) *http.Server {
 	return &serverInstance
 }
 
-func createJobQueue(waitGroup *sync.WaitGroup, shutdownCh chan bool) manager.Manager {
+func createJobQueue(waitGroup *sync.WaitGroup, shutdownCh chan bool, events manager.EventStream) manager.Manager {
 	cfg, err := config.Config()
 	if err != nil {
 		log.Fatalf("Failed to create AWS cfg: %q", err)
 	}
 	cache := common.NewJobCache()
-	db := ddb.NewDynamoDb(cfg, cache)
+	db := createDatabase(cfg, cache)
 	if err = db.InitializeJobs(); err != nil {
 		log.Fatalf("failed to populate jobs from database: %q", err)
 	}
@@ -88,7 +95,8 @@ func createJobQueue(waitGroup *sync.WaitGroup, shutdownCh chan bool) manager.Man
 	if err != nil {
 		log.Fatalf("failed to initialize notifications: %q", err)
 	}
-	jobManager, err := jobmanager.NewJobManager(cache, db, deployment, apiGw, repo, n)
+	// Publish to the event stream first since Discord notifications are paced and can take a while to go out
+	jobManager, err := jobmanager.NewJobManager(cache, db, deployment, apiGw, repo, notifs.NewMultiNotifs(events, n))
 	if err != nil {
 		log.Fatalf("failed to create job queue: %q", err)
 	}
@@ -102,6 +110,26 @@ func createJobQueue(waitGroup *sync.WaitGroup, shutdownCh chan bool) manager.Man
 	return jobManager
 }
 
+// createDatabase uses DynamoDB unless another database is selected, e.g. SQLite or the in-memory database for local runs
+// without any AWS resources for storing jobs. SQL databases are located by `DB_URL`, e.g. a Postgres connection string
+// or an SQLite file name.
+func createDatabase(cfg aws.Config, cache manager.Cache) manager.Database {
+	switch dbType := os.Getenv("DB_TYPE"); dbType {
+	case "", "dynamodb":
+		return ddb.NewDynamoDb(cfg, cache)
+	case "postgres":
+		return sqldb.NewSqlDb(sqldb.Driver_Postgres, os.Getenv("DB_URL"), cache)
+	case "sqlite":
+		return sqldb.NewSqlDb(sqldb.Driver_Sqlite, os.Getenv("DB_URL"), cache)
+	case "memory":
+		log.Println("using in-memory database, jobs will not be persisted")
+		return mem.NewMemoryDb(cache)
+	default:
+		log.Fatalf("unknown database type: %s", dbType)
+		return nil
+	}
+}
+
 func shutdown(waitGroup *sync.WaitGroup, cleanup func() bool) {
 	interruptCh := make(chan os.Signal, 1)
 	signal.Notify(interruptCh, os.Interrupt)
@@ -2,15 +2,19 @@ package ddb
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
-	"github.com/mitchellh/mapstructure"
-
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -25,15 +29,49 @@ import (
 var _ manager.Database = &DynamoDb{}
 
 type DynamoDb struct {
-	client     *dynamodb.Client
-	jobTable   string
-	buildTable string
-	cache      manager.Cache
-	cursor     time.Time
+	client            *dynamodb.Client
+	jobTable          string
+	buildTable        string
+	scheduleTable     string
+	pipelineTable     string
+	leaseTable        string
+	cache             manager.Cache
+	cursor            time.Time
+	idempotencyWindow time.Duration
+	fence             *atomic.Value
 }
 
 const defaultJobStateTtl = 2 * 7 * 24 * time.Hour // Two weeks
 
+// Idempotency records are stored in the job table with IDs that can't collide with job update UUIDs. They don't have
+// any of the attributes used by the job table's indices, and so never show up in job queries.
+const idempotencyKeyPrefix = "idempotency#"
+
+// Current state records are stored in the job table the same way as idempotency records
+const currentStatePrefix = "current#"
+
+// The paused state is stored in the lease table, with a name that can't collide with lease names
+const pausedKey = "paused#"
+
+// currentStateRecord holds the latest state of a job that has been updated since it was queued, along with the job's
+// version. Stage records are kept alongside as the job's history.
+type currentStateRecord struct {
+	Id       string    `dynamodbav:"id"`
+	JobState string    `dynamodbav:"jobState"` // JSON-encoded state of the job
+	Version  int64     `dynamodbav:"version"`
+	Ttl      time.Time `dynamodbav:"ttl,unixtime"`
+}
+
+// idempotencyRecord maps an idempotency key to the job that was queued with it
+type idempotencyRecord struct {
+	Id        string    `dynamodbav:"id"`
+	JobState  string    `dynamodbav:"jobState"` // JSON-encoded state of the job when it was queued
+	ExpiresAt int64     `dynamodbav:"expiresAt"`
+	Ttl       time.Time `dynamodbav:"ttl,unixtime"`
+}
+
+var jobTypes = []job.JobType{job.JobType_Deploy, job.JobType_Anchor, job.JobType_TestE2E, job.JobType_TestSmoke, job.JobType_Workflow}
+
 // buildState represents build/deploy tag information. This information is maintained in a legacy DynamoDB table used by
 // our utility AWS Lambdas.
 type buildState struct {
@@ -61,13 +99,25 @@ func NewDynamoDb(cfg aws.Config, cache manager.Cache) manager.Database {
 	}
 	jobTable := "ceramic-" + env + "-ops"
 	buildTable := "ceramic-utils-" + env
+	scheduleTable := "ceramic-" + env + "-schedules"
+	pipelineTable := "ceramic-" + env + "-pipelines"
+	leaseTable := "ceramic-" + env + "-leases"
+	idempotencyWindow, err := manager.IdempotencyWindow()
+	if err != nil {
+		log.Fatalf("dynamodb: %v", err)
+	}
 	dynamoDbClient := dynamodb.NewFromConfig(cfg)
 	db := &DynamoDb{
 		dynamoDbClient,
 		jobTable,
 		buildTable,
+		scheduleTable,
+		pipelineTable,
+		leaseTable,
 		cache,
 		time.Unix(0, 0),
+		idempotencyWindow,
+		new(atomic.Value),
 	}
 	if err = db.createJobTable(); err != nil {
 		log.Fatalf("dynamodb: job table creation failed: %v", err)
@@ -75,6 +125,15 @@ func NewDynamoDb(cfg aws.Config, cache manager.Cache) manager.Database {
 	if err = db.createBuildTable(); err != nil {
 		log.Fatalf("dynamodb: build table creation failed: %v", err)
 	}
+	if err = db.createScheduleTable(); err != nil {
+		log.Fatalf("dynamodb: schedule table creation failed: %v", err)
+	}
+	if err = db.createPipelineTable(); err != nil {
+		log.Fatalf("dynamodb: pipeline table creation failed: %v", err)
+	}
+	if err = db.createLeaseTable(); err != nil {
+		log.Fatalf("dynamodb: lease table creation failed: %v", err)
+	}
 	return db
 }
 
@@ -106,6 +165,78 @@ func (db DynamoDb) createBuildTable() error {
 	return utils.CreateTable(context.Background(), db.client, &createTableInput)
 }
 
+func (db DynamoDb) createScheduleTable() error {
+	// Create the table if it doesn't already exist
+	createTableInput := dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: "S",
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       "HASH",
+			},
+		},
+		TableName: aws.String(db.scheduleTable),
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	return utils.CreateTable(context.Background(), db.client, &createTableInput)
+}
+
+func (db DynamoDb) createPipelineTable() error {
+	// Create the table if it doesn't already exist
+	createTableInput := dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: "S",
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       "HASH",
+			},
+		},
+		TableName: aws.String(db.pipelineTable),
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	return utils.CreateTable(context.Background(), db.client, &createTableInput)
+}
+
+func (db DynamoDb) createLeaseTable() error {
+	// Create the table if it doesn't already exist
+	createTableInput := dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("name"),
+				AttributeType: "S",
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("name"),
+				KeyType:       "HASH",
+			},
+		},
+		TableName: aws.String(db.leaseTable),
+		ProvisionedThroughput: &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	return utils.CreateTable(context.Background(), db.client, &createTableInput)
+}
+
 func (db DynamoDb) InitializeJobs() error {
 	ttlCursor := time.Now().AddDate(0, 0, -manager.DefaultTtlDays)
 	// Load all jobs in an advanced stage of processing (completed, failed, delayed, waiting, started, skipped), so that
@@ -136,14 +267,118 @@ func (db DynamoDb) loadJobs(stage job.JobStage, cursor time.Time) error {
 	})
 }
 
-func (db DynamoDb) QueueJob(jobState job.JobState) error {
+func (db DynamoDb) QueueJob(jobState job.JobState) (job.JobState, error) {
 	// Only write this job to the database since that's where our de/queueing is expected to happen from. The cache is
 	// just a hash-map from job IDs to job state for ACTIVE jobs (jobs are not added to the cache until they are in
 	// progress). This also means that we don't need to write jobs to the database if they're already in the cache.
-	if _, found := db.cache.JobById(jobState.JobId); !found {
-		return db.WriteJob(jobState)
+	if cachedJob, found := db.cache.JobById(jobState.JobId); found {
+		return cachedJob, nil
+	}
+	// Jobs with an idempotency key are only queued if no other job was queued with the same key within the idempotency
+	// window. Since the key is claimed in the database, this works across restarts and not just for jobs in the cache.
+	if idempotencyKey, _ := jobState.Params[job.JobParam_IdempotencyKey].(string); len(idempotencyKey) > 0 {
+		if originalJob, claimed, err := db.claimIdempotencyKey(idempotencyKey, jobState); err != nil {
+			return job.JobState{}, err
+		} else if !claimed {
+			log.Printf("queueJob: found job for idempotency key: %s, %s", idempotencyKey, manager.PrintJob(originalJob))
+			return originalJob, nil
+		} else if err = db.WriteJob(jobState); err != nil {
+			// Release the key so that the caller can retry
+			db.releaseIdempotencyKey(idempotencyKey)
+			return job.JobState{}, err
+		}
+		return jobState, nil
+	}
+	return jobState, db.WriteJob(jobState)
+}
+
+// claimIdempotencyKey attempts to record the job as the one queued with the idempotency key. If another job was already
+// queued with the key within the idempotency window, the latest state of that job is returned.
+func (db DynamoDb) claimIdempotencyKey(idempotencyKey string, jobState job.JobState) (job.JobState, bool, error) {
+	now := time.Now()
+	if encodedJobState, err := json.Marshal(jobState); err != nil {
+		return job.JobState{}, false, err
+	} else if attributeValues, err := attributevalue.MarshalMap(idempotencyRecord{
+		Id:        idempotencyKeyPrefix + idempotencyKey,
+		JobState:  string(encodedJobState),
+		ExpiresAt: now.Add(db.idempotencyWindow).Unix(),
+		Ttl:       now.Add(db.idempotencyWindow),
+	}); err != nil {
+		return job.JobState{}, false, err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		// DynamoDB deletes expired items lazily, so also allow overwriting records that have expired but still exist.
+		_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(db.jobTable),
+			Item:                attributeValues,
+			ConditionExpression: aws.String("attribute_not_exists(#id) or #expiresAt < :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#id":        "id",
+				"#expiresAt": "expiresAt",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+		})
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if err == nil {
+			return jobState, true, nil
+		} else if !errors.As(err, &conditionFailedErr) {
+			return job.JobState{}, false, err
+		}
+	}
+	// The key was already claimed, so look up the job that claimed it.
+	if originalJob, err := db.getIdempotencyRecordJob(idempotencyKey); err != nil {
+		return job.JobState{}, false, err
+	} else if cachedJob, found := db.cache.JobById(originalJob.JobId); found {
+		return cachedJob, false, nil
+	} else if latestJob, found, err := db.GetJob(originalJob.JobId); err != nil {
+		return job.JobState{}, false, err
+	} else if found {
+		return latestJob, false, nil
+	} else {
+		// The job index is eventually consistent and might not have the job yet, so use its state at the time it was
+		// queued.
+		return originalJob, false, nil
+	}
+}
+
+func (db DynamoDb) getIdempotencyRecordJob(idempotencyKey string) (job.JobState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	record := idempotencyRecord{}
+	jobState := job.JobState{}
+	if getItemOutput, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.jobTable),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: idempotencyKeyPrefix + idempotencyKey}},
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return job.JobState{}, err
+	} else if getItemOutput.Item == nil {
+		return job.JobState{}, fmt.Errorf("getIdempotencyRecordJob: missing record for key: %s", idempotencyKey)
+	} else if err = attributevalue.UnmarshalMap(getItemOutput.Item, &record); err != nil {
+		return job.JobState{}, err
+	} else if err = json.Unmarshal([]byte(record.JobState), &jobState); err != nil {
+		return job.JobState{}, err
+	} else if err = manager.DecodeJobLayout(jobState); err != nil {
+		return job.JobState{}, err
+	}
+	return jobState, nil
+}
+
+func (db DynamoDb) releaseIdempotencyKey(idempotencyKey string) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	if _, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.jobTable),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: idempotencyKeyPrefix + idempotencyKey}},
+	}); err != nil {
+		log.Printf("releaseIdempotencyKey: failed to release key: %s, %v", idempotencyKey, err)
 	}
-	return nil
 }
 
 // QueuedJobs returns jobs in order of their DB timestamps that have not yet been picked up from the database and are
@@ -161,28 +396,38 @@ func (db DynamoDb) QueuedJobs() []job.JobState {
 	} else {
 		cursor = ttlCursor
 	}
-	jobs := make([]job.JobState, 0, 0)
-	cursorSet := false
+	queuedJobs := make([]job.JobState, 0, 0)
 	if err := db.iterateByStage(job.JobStage_Queued, cursor, true, func(jobState job.JobState) bool {
 		// If a job is not already in the cache, append it since it hasn't been dequeued yet.
 		if _, found := db.cache.JobById(jobState.JobId); !found {
-			jobs = append(jobs, jobState)
-			// Set the cursor to the timestamp of the first job that is not already in processing (see `iterateByStage`
-			// for explanation why).
-			if !cursorSet {
-				db.cursor = jobState.Ts
-				cursorSet = true
-			}
+			queuedJobs = append(queuedJobs, jobState)
 		}
 		// Return true so that we keep on iterating.
 		return true
 	}); err != nil {
 		log.Printf("queuedJobs: failed iteration through jobs: %v", err)
 	}
-	// If the cursor is still unset, then we found no jobs that weren't already in processing or done. In that case, set
-	// the cursor to "now" so we know to search from this point in time onwards. There's no point looking up jobs from
-	// the past that we know no longer need any processing.
-	if !cursorSet {
+	jobs := make([]job.JobState, 0, 0)
+	if len(queuedJobs) > 0 {
+		// Jobs updated while queued have a record for each version, so skip records that have been replaced by a later
+		// version, including ones that were rescheduled for later.
+		scheduledJobs := make([]job.JobState, 0, 0)
+		if err := db.queryByStage(job.JobStage_Scheduled, cursor, time.Unix(0, math.MaxInt64), true, func(jobState job.JobState) bool {
+			scheduledJobs = append(scheduledJobs, jobState)
+			return true
+		}); err != nil {
+			log.Printf("queuedJobs: failed iteration through scheduled jobs: %v", err)
+		}
+		jobs = manager.LatestQueuedJobs(queuedJobs, scheduledJobs, db.GetJob)
+	}
+	if len(jobs) > 0 {
+		// Set the cursor to the timestamp of the first job that is not already in processing (see `iterateByStage` for
+		// explanation why).
+		db.cursor = jobs[0].Ts
+	} else {
+		// We found no jobs that weren't already in processing or done. In that case, set the cursor to "now" so we know
+		// to search from this point in time onwards. There's no point looking up jobs from the past that we know no
+		// longer need any processing.
 		db.cursor = time.Now()
 	}
 	return jobs
@@ -192,7 +437,8 @@ func (db DynamoDb) QueuedJobs() []job.JobState {
 func (db DynamoDb) OrderedJobs(jobStage job.JobStage) []job.JobState {
 	jobs := make([]job.JobState, 0, 0)
 	if err := db.iterateByStage(jobStage, db.cursor, true, func(jobState job.JobState) bool {
-		if cachedJob, found := db.cache.JobById(jobState.JobId); found && cachedJob.Stage == jobStage {
+		// Skip records for earlier versions of jobs updated while waiting to be processed
+		if cachedJob, found := db.cache.JobById(jobState.JobId); found && (cachedJob.Stage == jobStage) && (job.JobVersion(cachedJob) == job.JobVersion(jobState)) {
 			jobs = append(jobs, jobState)
 		}
 		// Return true so that we keep on iterating.
@@ -207,17 +453,131 @@ func (db DynamoDb) IterateByType(jobType job.JobType, asc bool, iter func(job.Jo
 	return db.iterateByType(jobType, time.Now().AddDate(0, 0, -manager.DefaultTtlDays), asc, iter)
 }
 
+// GetJob returns the most recent state of a job from the database, regardless of whether the job is in the cache.
+func (db DynamoDb) GetJob(jobId string) (job.JobState, bool, error) {
+	// Use the job's current state record if the job has been updated since it was queued
+	if currentJob, found, err := db.getCurrentJob(jobId); err != nil {
+		return job.JobState{}, false, err
+	} else if found {
+		return currentJob, true, nil
+	}
+	var jobState job.JobState
+	found := false
+	// Jobs updated while waiting to be processed can have records with timestamps later than their latest state, so we
+	// need to look at all the records for the job.
+	if err := db.iterateByJob(jobId, false, func(js job.JobState) bool {
+		if !found || job.IsLaterJobState(js, jobState) {
+			jobState = js
+			found = true
+		}
+		// Return true so that we keep on iterating.
+		return true
+	}); err != nil {
+		return job.JobState{}, false, err
+	}
+	return jobState, found, nil
+}
+
+// GetJobHistory returns all the stage records for a job in order of their timestamps
+func (db DynamoDb) GetJobHistory(jobId string) ([]job.JobState, error) {
+	jobs := make([]job.JobState, 0, 0)
+	if err := db.iterateByJob(jobId, true, func(jobState job.JobState) bool {
+		jobs = append(jobs, jobState)
+		// Return true so that we keep on iterating.
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ListJobs returns a page of job records matching the filter. The stage and type indices are used to narrow down the
+// search, and the remaining criteria are applied to the records found in the requested time range.
+func (db DynamoDb) ListJobs(filter manager.JobFilter) (manager.JobPage, error) {
+	start := filter.Start
+	end := filter.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	var cursorTs time.Time
+	cursorId := ""
+	if len(filter.Cursor) > 0 {
+		var err error
+		if cursorTs, cursorId, err = manager.DecodeJobCursor(filter.Cursor); err != nil {
+			return manager.JobPage{}, err
+		}
+		// Narrow down the time range to exclude records that were already returned with previous pages
+		if filter.Asc && cursorTs.After(start) {
+			start = cursorTs
+		} else if !filter.Asc && cursorTs.Before(end) {
+			end = cursorTs
+		}
+	}
+	jobs := make([]job.JobState, 0, 0)
+	// DynamoDB rejects time ranges with a lower bound greater than the upper bound
+	if start.After(end) {
+		return manager.JobPage{Jobs: jobs}, nil
+	}
+	collect := func(iterFn func(func(job.JobState) bool) error) error {
+		numJobs := 0
+		return iterFn(func(jobState job.JobState) bool {
+			if ((len(cursorId) == 0) || manager.IsAfterCursor(jobState, cursorTs, cursorId, filter.Asc)) && filter.Matches(jobState) {
+				jobs = append(jobs, jobState)
+				numJobs++
+			}
+			// Records are iterated in order of timestamp, so we can stop once we have one more job than the page size.
+			// The extra job tells us whether there are more results.
+			return numJobs <= filter.Limit
+		})
+	}
+	// Use the stage index if a stage was specified, otherwise use the type index for one or all job types.
+	if len(filter.Stage) > 0 {
+		if err := collect(func(iter func(job.JobState) bool) error {
+			return db.queryByStage(filter.Stage, start, end, filter.Asc, iter)
+		}); err != nil {
+			return manager.JobPage{}, err
+		}
+	} else {
+		searchTypes := jobTypes
+		if len(filter.Type) > 0 {
+			searchTypes = []job.JobType{filter.Type}
+		}
+		for _, jobType := range searchTypes {
+			jobType := jobType
+			if err := collect(func(iter func(job.JobState) bool) error {
+				return db.queryByType(jobType, start, end, filter.Asc, iter)
+			}); err != nil {
+				return manager.JobPage{}, err
+			}
+		}
+	}
+	// Merge the results from all searches
+	sort.Slice(jobs, func(i, j int) bool {
+		return manager.IsAfterCursor(jobs[j], jobs[i].Ts, jobs[i].Id, filter.Asc)
+	})
+	page := manager.JobPage{Jobs: jobs}
+	if len(jobs) > filter.Limit {
+		page.Jobs = jobs[:filter.Limit]
+		page.Cursor = manager.EncodeJobCursor(page.Jobs[filter.Limit-1])
+	}
+	return page, nil
+}
+
 func (db DynamoDb) iterateByStage(jobStage job.JobStage, cursor time.Time, asc bool, iter func(job.JobState) bool) error {
 	// Only look for jobs up till the current time. This allows us to schedule jobs in the future (e.g. smoke tests to
 	// start a few minutes after a deployment is complete).
+	return db.queryByStage(jobStage, cursor, time.Now(), asc, iter)
+}
+
+func (db DynamoDb) queryByStage(jobStage job.JobStage, start, end time.Time, asc bool, iter func(job.JobState) bool) error {
 	return db.iterateEvents(&dynamodb.QueryInput{
 		TableName:              aws.String(db.jobTable),
 		IndexName:              aws.String(job.StageTsIndex),
 		KeyConditionExpression: aws.String("#stage = :stage and #ts between :ts and :now"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":stage": &types.AttributeValueMemberS{Value: string(jobStage)},
-			":ts":    &types.AttributeValueMemberN{Value: strconv.FormatInt(cursor.UnixNano(), 10)},
-			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().UnixNano(), 10)},
+			":ts":    &types.AttributeValueMemberN{Value: strconv.FormatInt(start.UnixNano(), 10)},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(end.UnixNano(), 10)},
 		},
 		ExpressionAttributeNames: map[string]string{
 			"#stage": "stage",
@@ -230,14 +590,18 @@ func (db DynamoDb) iterateByStage(jobStage job.JobStage, cursor time.Time, asc b
 func (db DynamoDb) iterateByType(jobType job.JobType, cursor time.Time, asc bool, iter func(job.JobState) bool) error {
 	// Only look for jobs up till the current time. This allows us to schedule jobs in the future (e.g. smoke tests to
 	// start a few minutes after a deployment is complete).
+	return db.queryByType(jobType, cursor, time.Now(), asc, iter)
+}
+
+func (db DynamoDb) queryByType(jobType job.JobType, start, end time.Time, asc bool, iter func(job.JobState) bool) error {
 	return db.iterateEvents(&dynamodb.QueryInput{
 		TableName:              aws.String(db.jobTable),
 		IndexName:              aws.String(job.TypeTsIndex),
 		KeyConditionExpression: aws.String("#type = :type and #ts between :ts and :now"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":type": &types.AttributeValueMemberS{Value: string(jobType)},
-			":ts":   &types.AttributeValueMemberN{Value: strconv.FormatInt(cursor.UnixNano(), 10)},
-			":now":  &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().UnixNano(), 10)},
+			":ts":   &types.AttributeValueMemberN{Value: strconv.FormatInt(start.UnixNano(), 10)},
+			":now":  &types.AttributeValueMemberN{Value: strconv.FormatInt(end.UnixNano(), 10)},
 		},
 		ExpressionAttributeNames: map[string]string{
 			"#type": "type",
@@ -247,9 +611,26 @@ func (db DynamoDb) iterateByType(jobType job.JobType, cursor time.Time, asc bool
 	}, iter)
 }
 
+func (db DynamoDb) iterateByJob(jobId string, asc bool, iter func(job.JobState) bool) error {
+	return db.iterateEvents(&dynamodb.QueryInput{
+		TableName:              aws.String(db.jobTable),
+		IndexName:              aws.String(job.JobTsIndex),
+		KeyConditionExpression: aws.String("#job = :job"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":job": &types.AttributeValueMemberS{Value: jobId},
+		},
+		ExpressionAttributeNames: map[string]string{
+			"#job": "job",
+		},
+		ScanIndexForward: aws.Bool(asc),
+	}, iter)
+}
+
 func (db DynamoDb) iterateEvents(queryInput *dynamodb.QueryInput, iter func(job.JobState) bool) error {
 	p := dynamodb.NewQueryPaginator(db.client, queryInput)
-	for p.HasMorePages() {
+	// Stop paginating as soon as the iterator signals that it doesn't need any more results
+	done := false
+	for !done && p.HasMorePages() {
 		err := func() error {
 			ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
 			defer cancel()
@@ -270,17 +651,11 @@ func (db DynamoDb) iterateEvents(queryInput *dynamodb.QueryInput, iter func(job.
 				return err
 			}
 			for _, jobState := range jobsPage {
-				if jobState.Type == job.JobType_Deploy {
-					// Marshal layout back into `Layout` structure
-					if layout, found := jobState.Params[job.DeployJobParam_Layout].(map[string]interface{}); found {
-						var marshaledLayout manager.Layout
-						if err = mapstructure.Decode(layout, &marshaledLayout); err != nil {
-							return err
-						}
-						jobState.Params[job.DeployJobParam_Layout] = marshaledLayout
-					}
+				if err = manager.DecodeJobLayout(jobState); err != nil {
+					return err
 				}
 				if !iter(jobState) {
+					done = true
 					return nil
 				}
 			}
@@ -293,24 +668,147 @@ func (db DynamoDb) iterateEvents(queryInput *dynamodb.QueryInput, iter func(job.
 	return nil
 }
 
+// AdvanceJob records a new state for a job, as long as the job hasn't been updated since the state it was based on, i.e.
+// the version of the job's current state record is still the version of the given state. The new state gets the next
+// version.
+//
+// If the job was updated concurrently, e.g. canceled while it was being advanced, the update is rejected and the
+// latest state of the job is loaded into the cache so that the caller can decide what to do with it.
 func (db DynamoDb) AdvanceJob(jobState job.JobState) error {
-	if err := db.WriteJob(jobState); err != nil {
+	// Copy the params so that the caller's state isn't modified if the update is rejected
+	params := make(map[string]interface{}, len(jobState.Params)+1)
+	for k, v := range jobState.Params {
+		params[k] = v
+	}
+	version := job.JobVersion(jobState)
+	params[job.JobParam_Version] = version + 1
+	jobState.Params = params
+	if err := db.writeJobVersion(jobState, version); err != nil {
+		if errors.Is(err, manager.Error_JobConflict) {
+			if latestJob, found, getErr := db.getCurrentJob(jobState.JobId); getErr != nil {
+				log.Printf("advanceJob: failed to load latest job state: %v, %s", getErr, manager.PrintJob(jobState))
+			} else if found && (latestJob.Stage != job.JobStage_Scheduled) && (latestJob.Stage != job.JobStage_Queued) {
+				db.cache.WriteJob(latestJob)
+			}
+		}
 		return err
 	}
-	db.cache.WriteJob(jobState)
+	// Scheduled and queued jobs are only present in the database until they are dequeued (see `QueueJob`), so only jobs
+	// that have been updated while still scheduled or queued should be kept out of the cache.
+	if (jobState.Stage != job.JobStage_Scheduled) && (jobState.Stage != job.JobStage_Queued) {
+		db.cache.WriteJob(jobState)
+	}
 	return nil
 }
 
-func (db DynamoDb) WriteJob(jobState job.JobState) error {
-	// Generate a new UUID for every job update
-	jobState.Id = uuid.New().String()
-	// Set entry expiration
-	jobState.Ttl = time.Now().Add(defaultJobStateTtl)
-	if attributeValues, err := attributevalue.MarshalMapWithOptions(jobState, func(options *attributevalue.EncoderOptions) {
-		options.EncodeTime = func(time time.Time) (types.AttributeValue, error) {
-			return &types.AttributeValueMemberN{Value: strconv.FormatInt(time.UnixNano(), 10)}, nil
+// writeJobVersion writes the job's current state record along with a stage record for the job's history in a single
+// transaction. Job updates are also fenced by the lease held by the job manager, if any, so that they're rejected if
+// another replica has taken over processing in the meantime.
+func (db DynamoDb) writeJobVersion(jobState job.JobState, prevVersion int64) error {
+	encodedJobState, err := json.Marshal(jobState)
+	if err != nil {
+		return err
+	}
+	jobAttributeValues, err := db.marshalJob(jobState)
+	if err != nil {
+		return err
+	}
+	currentAttributeValues, err := attributevalue.MarshalMap(currentStateRecord{
+		Id:       currentStatePrefix + jobState.JobId,
+		JobState: string(encodedJobState),
+		Version:  job.JobVersion(jobState),
+		Ttl:      time.Now().Add(defaultJobStateTtl),
+	})
+	if err != nil {
+		return err
+	}
+	currentStatePut := types.Put{
+		TableName:           aws.String(db.jobTable),
+		Item:                currentAttributeValues,
+		ConditionExpression: aws.String("#version = :version"),
+		ExpressionAttributeNames: map[string]string{
+			"#version": "version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(prevVersion, 10)},
+		},
+	}
+	// Jobs that haven't been updated since they were queued don't have a current state record yet
+	if prevVersion == 0 {
+		currentStatePut.ConditionExpression = aws.String("attribute_not_exists(#id)")
+		currentStatePut.ExpressionAttributeNames = map[string]string{"#id": "id"}
+		currentStatePut.ExpressionAttributeValues = nil
+	}
+	transactItems := []types.TransactWriteItem{
+		{Put: &currentStatePut},
+		{Put: &types.Put{TableName: aws.String(db.jobTable), Item: jobAttributeValues}},
+	}
+	lease, _ := db.fence.Load().(manager.Lease)
+	if len(lease.Name) > 0 {
+		// Only write the update if the lease is still held by the same owner
+		transactItems = append(transactItems, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName:           aws.String(db.leaseTable),
+				Key:                 map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: lease.Name}},
+				ConditionExpression: aws.String("#owner = :owner AND #token = :token"),
+				ExpressionAttributeNames: map[string]string{
+					"#owner": "owner",
+					"#token": "token",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":owner": &types.AttributeValueMemberS{Value: lease.Owner},
+					":token": &types.AttributeValueMemberN{Value: strconv.FormatInt(lease.Token, 10)},
+				},
+			},
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err = db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+	var txCanceledErr *types.TransactionCanceledException
+	if errors.As(err, &txCanceledErr) {
+		// Cancellation reasons are listed in the same order as the items in the transaction
+		for idx, reason := range txCanceledErr.CancellationReasons {
+			if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			} else if idx == 0 {
+				return fmt.Errorf("%w: version %d is no longer current: %s", manager.Error_JobConflict, prevVersion, jobState.JobId)
+			} else if idx == 2 {
+				return fmt.Errorf("%w: lease %s lost by %s (token %d)", manager.Error_NotLeader, lease.Name, lease.Owner, lease.Token)
+			}
 		}
+	}
+	return err
+}
+
+func (db DynamoDb) getCurrentJob(jobId string) (job.JobState, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	record := currentStateRecord{}
+	jobState := job.JobState{}
+	if getItemOutput, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.jobTable),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: currentStatePrefix + jobId}},
+		ConsistentRead: aws.Bool(true),
 	}); err != nil {
+		return job.JobState{}, false, err
+	} else if getItemOutput.Item == nil {
+		return job.JobState{}, false, nil
+	} else if err = attributevalue.UnmarshalMap(getItemOutput.Item, &record); err != nil {
+		return job.JobState{}, false, err
+	} else if err = json.Unmarshal([]byte(record.JobState), &jobState); err != nil {
+		return job.JobState{}, false, err
+	} else if err = manager.DecodeJobLayout(jobState); err != nil {
+		return job.JobState{}, false, err
+	}
+	return jobState, true, nil
+}
+
+// WriteJob writes a stage record for a job without checking or updating the job's version, e.g. when the job is queued.
+func (db DynamoDb) WriteJob(jobState job.JobState) error {
+	if attributeValues, err := db.marshalJob(jobState); err != nil {
 		return err
 	} else {
 		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
@@ -324,6 +822,22 @@ func (db DynamoDb) WriteJob(jobState job.JobState) error {
 	}
 }
 
+func (db DynamoDb) marshalJob(jobState job.JobState) (map[string]types.AttributeValue, error) {
+	// Generate a new UUID for every job update
+	jobState.Id = uuid.New().String()
+	// Set entry expiration, counting from when the job comes due for jobs scheduled in the future.
+	ttlStart := time.Now()
+	if jobState.Ts.After(ttlStart) {
+		ttlStart = jobState.Ts
+	}
+	jobState.Ttl = ttlStart.Add(defaultJobStateTtl)
+	return attributevalue.MarshalMapWithOptions(jobState, func(options *attributevalue.EncoderOptions) {
+		options.EncodeTime = func(time time.Time) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberN{Value: strconv.FormatInt(time.UnixNano(), 10)}, nil
+		}
+	})
+}
+
 func (db DynamoDb) UpdateBuildTag(component manager.DeployComponent, buildTag string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
 	defer cancel()
@@ -333,7 +847,8 @@ func (db DynamoDb) UpdateBuildTag(component manager.DeployComponent, buildTag st
 		Key: map[string]types.AttributeValue{
 			"key": &types.AttributeValueMemberS{Value: string(component)},
 		},
-		UpdateExpression: aws.String("set #buildInfo.#shaTag = :sha"),
+		UpdateExpression:    aws.String("set #buildInfo.#shaTag = :sha"),
+		ConditionExpression: aws.String("attribute_exists(#buildInfo)"),
 		ExpressionAttributeNames: map[string]string{
 			"#buildInfo": "buildInfo",
 			"#shaTag":    "sha_tag",
@@ -342,6 +857,26 @@ func (db DynamoDb) UpdateBuildTag(component manager.DeployComponent, buildTag st
 			":sha": &types.AttributeValueMemberS{Value: buildTag},
 		},
 	})
+	var conditionFailedErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailedErr) {
+		// Nested attributes can't be set before their parent exists, e.g. for a component that hasn't been built before.
+		_, err = db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(db.buildTable),
+			Key: map[string]types.AttributeValue{
+				"key": &types.AttributeValueMemberS{Value: string(component)},
+			},
+			UpdateExpression:    aws.String("set #buildInfo = :buildInfo"),
+			ConditionExpression: aws.String("attribute_not_exists(#buildInfo)"),
+			ExpressionAttributeNames: map[string]string{
+				"#buildInfo": "buildInfo",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":buildInfo": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+					"sha_tag": &types.AttributeValueMemberS{Value: buildTag},
+				}},
+			},
+		})
+	}
 	return err
 }
 
@@ -406,3 +941,333 @@ func (db DynamoDb) getBuildStates() ([]buildState, error) {
 		return buildStates, nil
 	}
 }
+
+// AddSchedule writes a new schedule, failing if a schedule with the same ID already exists
+func (db DynamoDb) AddSchedule(schedule manager.JobSchedule) error {
+	if attributeValues, err := attributevalue.MarshalMap(schedule); err != nil {
+		return err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(db.scheduleTable),
+			Item:                attributeValues,
+			ConditionExpression: aws.String("attribute_not_exists(#id)"),
+			ExpressionAttributeNames: map[string]string{
+				"#id": "id",
+			},
+		})
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedErr) {
+			return manager.Error_ScheduleExists
+		}
+		return err
+	}
+}
+
+func (db DynamoDb) GetSchedule(id string) (manager.JobSchedule, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	schedule := manager.JobSchedule{}
+	if getItemOutput, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.scheduleTable),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return manager.JobSchedule{}, false, err
+	} else if getItemOutput.Item == nil {
+		return manager.JobSchedule{}, false, nil
+	} else if err = attributevalue.UnmarshalMap(getItemOutput.Item, &schedule); err != nil {
+		return manager.JobSchedule{}, false, err
+	}
+	return schedule, true, nil
+}
+
+// GetSchedules returns all schedules, enabled or not, in order of their IDs
+func (db DynamoDb) GetSchedules() ([]manager.JobSchedule, error) {
+	schedules := make([]manager.JobSchedule, 0, 0)
+	p := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:      aws.String(db.scheduleTable),
+		ConsistentRead: aws.Bool(true),
+	})
+	for p.HasMorePages() {
+		err := func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+			defer cancel()
+
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			var schedulesPage []manager.JobSchedule
+			if err = attributevalue.UnmarshalListOfMaps(page.Items, &schedulesPage); err != nil {
+				return err
+			}
+			schedules = append(schedules, schedulesPage...)
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Id < schedules[j].Id
+	})
+	return schedules, nil
+}
+
+// UpdateSchedule replaces a schedule as long as its next run is still at the specified time. This allows multiple
+// writers, e.g. CD manager instances firing the same run, or a run firing while the schedule is being disabled, to
+// detect that the schedule was changed by someone else.
+func (db DynamoDb) UpdateSchedule(schedule manager.JobSchedule, nextRun time.Time) (bool, error) {
+	if attributeValues, err := attributevalue.MarshalMap(schedule); err != nil {
+		return false, err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(db.scheduleTable),
+			Item:                attributeValues,
+			ConditionExpression: aws.String("#nextRun = :nextRun"),
+			ExpressionAttributeNames: map[string]string{
+				"#nextRun": "nextRun",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":nextRun": &types.AttributeValueMemberN{Value: strconv.FormatInt(nextRun.Unix(), 10)},
+			},
+		})
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if err == nil {
+			return true, nil
+		} else if errors.As(err, &conditionFailedErr) {
+			return false, nil
+		}
+		return false, err
+	}
+}
+
+func (db DynamoDb) WritePipeline(pipeline manager.Pipeline) error {
+	// Set entry expiration
+	pipeline.Ttl = time.Now().Add(defaultJobStateTtl)
+	if attributeValues, err := attributevalue.MarshalMap(pipeline); err != nil {
+		return err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(db.pipelineTable),
+			Item:      attributeValues,
+		})
+		return err
+	}
+}
+
+func (db DynamoDb) GetPipeline(id string) (manager.Pipeline, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	pipeline := manager.Pipeline{}
+	if getItemOutput, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.pipelineTable),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return manager.Pipeline{}, false, err
+	} else if getItemOutput.Item == nil {
+		return manager.Pipeline{}, false, nil
+	} else if err = attributevalue.UnmarshalMap(getItemOutput.Item, &pipeline); err != nil {
+		return manager.Pipeline{}, false, err
+	}
+	return pipeline, true, nil
+}
+
+// ActivePipelines returns all running pipelines in order of when they were submitted
+func (db DynamoDb) ActivePipelines() ([]manager.Pipeline, error) {
+	pipelines := make([]manager.Pipeline, 0, 0)
+	p := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:        aws.String(db.pipelineTable),
+		ConsistentRead:   aws.Bool(true),
+		FilterExpression: aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: string(manager.PipelineStatus_Running)},
+		},
+	})
+	for p.HasMorePages() {
+		err := func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+			defer cancel()
+
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			var pipelinesPage []manager.Pipeline
+			if err = attributevalue.UnmarshalListOfMaps(page.Items, &pipelinesPage); err != nil {
+				return err
+			}
+			pipelines = append(pipelines, pipelinesPage...)
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(pipelines, func(i, j int) bool {
+		return pipelines[i].Ts.Before(pipelines[j].Ts)
+	})
+	return pipelines, nil
+}
+
+// AcquireLease acquires a lease that is free or has expired, or renews a lease already held by the same owner. If the
+// lease is held by someone else, the current lease is returned instead.
+//
+// Leases are only replaced if they haven't changed since they were looked up, so that at most one of multiple owners
+// racing for the same lease can acquire it.
+func (db DynamoDb) AcquireLease(name, owner, address string, ttl time.Duration) (manager.Lease, bool, error) {
+	now := time.Now()
+	currentLease, found, err := db.getLease(name)
+	if err != nil {
+		return manager.Lease{}, false, err
+	}
+	// Leases are stored with second granularity
+	lease := manager.Lease{Name: name, Owner: owner, Address: address, Token: 1, Expires: now.Add(ttl).Truncate(time.Second)}
+	putItemInput := dynamodb.PutItemInput{
+		TableName:           aws.String(db.leaseTable),
+		ConditionExpression: aws.String("attribute_not_exists(#name)"),
+		ExpressionAttributeNames: map[string]string{
+			"#name": "name",
+		},
+	}
+	if found {
+		if (currentLease.Owner != owner) && currentLease.Expires.After(now) {
+			return currentLease, false, nil
+		}
+		lease.Token = currentLease.Token
+		// Increment the token when taking over someone else's lease
+		if currentLease.Owner != owner {
+			lease.Token++
+		}
+		putItemInput.ConditionExpression = aws.String("#owner = :owner AND #token = :token AND #expires = :expires")
+		putItemInput.ExpressionAttributeNames = map[string]string{
+			"#owner":   "owner",
+			"#token":   "token",
+			"#expires": "expires",
+		}
+		putItemInput.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":owner":   &types.AttributeValueMemberS{Value: currentLease.Owner},
+			":token":   &types.AttributeValueMemberN{Value: strconv.FormatInt(currentLease.Token, 10)},
+			":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(currentLease.Expires.Unix(), 10)},
+		}
+	}
+	if putItemInput.Item, err = attributevalue.MarshalMap(lease); err != nil {
+		return manager.Lease{}, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err = db.client.PutItem(ctx, &putItemInput)
+	var conditionFailedErr *types.ConditionalCheckFailedException
+	if err == nil {
+		return lease, true, nil
+	} else if errors.As(err, &conditionFailedErr) {
+		// Someone else got to the lease first
+		if currentLease, _, err = db.getLease(name); err != nil {
+			return manager.Lease{}, false, err
+		}
+		return currentLease, false, nil
+	}
+	return manager.Lease{}, false, err
+}
+
+func (db DynamoDb) getLease(name string) (manager.Lease, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	lease := manager.Lease{}
+	if getItemOutput, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.leaseTable),
+		Key:            map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: name}},
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return manager.Lease{}, false, err
+	} else if getItemOutput.Item == nil {
+		return manager.Lease{}, false, nil
+	} else if err = attributevalue.UnmarshalMap(getItemOutput.Item, &lease); err != nil {
+		return manager.Lease{}, false, err
+	}
+	return lease, true, nil
+}
+
+// ReleaseLease gives up a lease so that someone else can acquire it right away, unless it has already changed owners.
+// The lease is expired rather than deleted so that its token keeps on increasing.
+func (db DynamoDb) ReleaseLease(lease manager.Lease) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.leaseTable),
+		Key:                 map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: lease.Name}},
+		UpdateExpression:    aws.String("SET #expires = :expires"),
+		ConditionExpression: aws.String("#owner = :owner AND #token = :token"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner":   "owner",
+			"#token":   "token",
+			"#expires": "expires",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner":   &types.AttributeValueMemberS{Value: lease.Owner},
+			":token":   &types.AttributeValueMemberN{Value: strconv.FormatInt(lease.Token, 10)},
+			":expires": &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	var conditionFailedErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailedErr) {
+		return nil
+	}
+	return err
+}
+
+// Fence makes job updates conditional on the lease still being held by the same owner. An empty lease disables fencing.
+func (db DynamoDb) Fence(lease manager.Lease) {
+	db.fence.Store(lease)
+}
+
+// IsPaused returns whether job processing has been paused. The paused state is stored in the lease table so that all
+// replicas see the same state.
+func (db DynamoDb) IsPaused() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	if getItemOutput, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.leaseTable),
+		Key:            map[string]types.AttributeValue{"name": &types.AttributeValueMemberS{Value: pausedKey}},
+		ConsistentRead: aws.Bool(true),
+	}); err != nil {
+		return false, err
+	} else if paused, found := getItemOutput.Item["paused"].(*types.AttributeValueMemberBOOL); found {
+		return paused.Value, nil
+	}
+	return false, nil
+}
+
+// SetPaused pauses or unpauses job processing
+func (db DynamoDb) SetPaused(paused bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err := db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.leaseTable),
+		Item: map[string]types.AttributeValue{
+			"name":   &types.AttributeValueMemberS{Value: pausedKey},
+			"paused": &types.AttributeValueMemberBOOL{Value: paused},
+		},
+	})
+	return err
+}
@@ -0,0 +1,28 @@
+package ddb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/aws/config"
+	"github.com/3box/pipeline-tools/cd/manager/common/dbtest"
+)
+
+// TestConformance runs against DynamoDB at DB_AWS_ENDPOINT, if set, e.g. DynamoDB Local. Tables are shared by all the
+// tests, which only look at the records they created themselves.
+func TestConformance(t *testing.T) {
+	if len(os.Getenv("DB_AWS_ENDPOINT")) == 0 {
+		t.Skip("DB_AWS_ENDPOINT not set")
+	}
+	if len(os.Getenv(manager.EnvVar_Env)) == 0 {
+		t.Setenv(manager.EnvVar_Env, "test")
+	}
+	cfg, err := config.Config()
+	if err != nil {
+		t.Fatalf("failed to create AWS cfg: %v", err)
+	}
+	dbtest.Run(t, func(cache manager.Cache) manager.Database {
+		return NewDynamoDb(cfg, cache)
+	})
+}
@@ -122,6 +122,22 @@ func (e Ecs) CheckTask(cluster, taskDefId string, running, stable bool, taskIds
 	return tasksFound && tasksInState, exitCode, nil
 }
 
+func (e Ecs) StopTask(cluster, taskId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	input := &ecs.StopTaskInput{
+		Cluster: aws.String(cluster),
+		Task:    aws.String(taskId),
+		Reason:  aws.String("Stopped by " + manager.ServiceName),
+	}
+	if _, err := e.ecsClient.StopTask(ctx, input); err != nil {
+		log.Printf("stopTask: stop task error: %s, %s, %v", cluster, taskId, err)
+		return err
+	}
+	return nil
+}
+
 func (e Ecs) GetLayout(clusters []string) (*manager.Layout, error) {
 	// First validate and filter the list of clusters since not all clusters might be present in all envs.
 	if descClusterOutput, err := e.describeEcsClusters(clusters); err != nil {
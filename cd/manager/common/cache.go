@@ -19,7 +19,7 @@ func NewJobCache() manager.Cache {
 
 func (c JobCache) WriteJob(jobState job.JobState) {
 	// Don't overwrite a newer state with an earlier one.
-	if cachedJobState, found := c.JobById(jobState.JobId); found && cachedJobState.Ts.After(jobState.Ts) {
+	if cachedJobState, found := c.JobById(jobState.JobId); found && job.IsLaterJobState(cachedJobState, jobState) {
 		return
 	}
 	// Store a copy of the state, not a pointer to it.
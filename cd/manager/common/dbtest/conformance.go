@@ -0,0 +1,554 @@
+// Package dbtest contains a conformance suite for `manager.Database` implementations. The job manager relies on subtle
+// database semantics, e.g. which jobs `QueuedJobs` and `OrderedJobs` return, and every backend must behave the same way.
+package dbtest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+// NewDatabase returns a database backed by the given cache. Tests only look at the records they created themselves, so
+// databases can be shared between tests, e.g. DynamoDB tables on a local endpoint.
+type NewDatabase func(cache manager.Cache) manager.Database
+
+type conformanceTest struct {
+	name string
+	run  func(t *testing.T, db manager.Database, cache manager.Cache)
+}
+
+var conformanceTests = []conformanceTest{
+	{"QueuedJobsSkipsCachedJobs", testQueuedJobsSkipsCachedJobs},
+	{"QueuedJobsSkipsReplacedVersions", testQueuedJobsSkipsReplacedVersions},
+	{"QueuedJobsSkipsRescheduledJobs", testQueuedJobsSkipsRescheduledJobs},
+	{"QueuedJobsHidesFutureJobs", testQueuedJobsHidesFutureJobs},
+	{"QueueJobReturnsCachedJob", testQueueJobReturnsCachedJob},
+	{"QueueJobIdempotency", testQueueJobIdempotency},
+	{"OrderedJobsMatchesCache", testOrderedJobsMatchesCache},
+	{"AdvanceJobUpdatesDatabaseAndCache", testAdvanceJobUpdatesDatabaseAndCache},
+	{"AdvanceJobRejectsStaleVersion", testAdvanceJobRejectsStaleVersion},
+	{"DeployLayoutRoundTrip", testDeployLayoutRoundTrip},
+	{"AdvanceJobFencing", testAdvanceJobFencing},
+	{"InitializeJobs", testInitializeJobs},
+	{"JobHistory", testJobHistory},
+	{"IterateByType", testIterateByType},
+	{"ListJobs", testListJobs},
+	{"Tags", testTags},
+	{"Schedules", testSchedules},
+	{"Pipelines", testPipelines},
+	{"Leases", testLeases},
+	{"Paused", testPaused},
+}
+
+// Run runs the conformance suite against databases returned by the constructor, with a new cache for each test.
+func Run(t *testing.T, newDb NewDatabase) {
+	for _, test := range conformanceTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			cache := common.NewJobCache()
+			test.run(t, newDb(cache), cache)
+		})
+	}
+}
+
+func testQueuedJobsSkipsCachedJobs(t *testing.T, db manager.Database, cache manager.Cache) {
+	now := time.Now()
+	later := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-time.Minute)))
+	earlier := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-2*time.Minute)))
+	dequeued := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-3*time.Minute)))
+	dequeued.Stage = job.JobStage_Dequeued
+	cache.WriteJob(dequeued)
+
+	expectJobs(t, "queued jobs", filterJobs(db.QueuedJobs(), later, earlier, dequeued), earlier, later)
+}
+
+func testQueuedJobsSkipsReplacedVersions(t *testing.T, db manager.Database, cache manager.Cache) {
+	now := time.Now()
+	original := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-2*time.Minute)))
+	updated := original
+	updated.Ts = now.Add(-time.Minute)
+	advanceJob(t, db, updated, job.JobStage_Queued)
+	if _, found := cache.JobById(original.JobId); found {
+		t.Fatalf("queued job added to cache: %s", original.JobId)
+	}
+
+	queuedJobs := filterJobs(db.QueuedJobs(), original)
+	if (len(queuedJobs) != 1) || (job.JobVersion(queuedJobs[0]) != 1) {
+		t.Fatalf("expected only the latest version to be queued: %v", queuedJobs)
+	}
+}
+
+func testQueuedJobsSkipsRescheduledJobs(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	original := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-time.Minute)))
+	rescheduled := original
+	rescheduled.Ts = now.Add(time.Hour)
+	advanceJob(t, db, rescheduled, job.JobStage_Scheduled)
+
+	expectJobs(t, "queued jobs", filterJobs(db.QueuedJobs(), original))
+}
+
+func testQueuedJobsHidesFutureJobs(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	due := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-time.Minute)))
+	future := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(time.Second)))
+
+	expectJobs(t, "queued jobs before due", filterJobs(db.QueuedJobs(), due, future), due)
+	time.Sleep(time.Until(future.Ts.Add(100 * time.Millisecond)))
+	expectJobs(t, "queued jobs once due", filterJobs(db.QueuedJobs(), due, future), due, future)
+}
+
+func testQueueJobReturnsCachedJob(t *testing.T, db manager.Database, cache manager.Cache) {
+	jobState := newJob(job.JobType_Anchor, time.Now())
+	cachedJob := jobState
+	cachedJob.Stage = job.JobStage_Started
+	cache.WriteJob(cachedJob)
+
+	if queuedJob, err := db.QueueJob(jobState); err != nil {
+		t.Fatalf("failed to queue job: %v", err)
+	} else if queuedJob.Stage != job.JobStage_Started {
+		t.Fatalf("expected cached job, found: %s", manager.PrintJob(queuedJob))
+	}
+	expectJobs(t, "queued jobs", filterJobs(db.QueuedJobs(), jobState))
+}
+
+func testQueueJobIdempotency(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	idempotencyKey := uuid.New().String()
+	first := newJob(job.JobType_Anchor, now.Add(-time.Minute))
+	first.Params[job.JobParam_IdempotencyKey] = idempotencyKey
+	second := newJob(job.JobType_Anchor, now)
+	second.Params[job.JobParam_IdempotencyKey] = idempotencyKey
+
+	queueJob(t, db, first)
+	if queuedJob, err := db.QueueJob(second); err != nil {
+		t.Fatalf("failed to queue job: %v", err)
+	} else if queuedJob.JobId != first.JobId {
+		t.Fatalf("expected job queued with the same key: %s, found: %s", first.JobId, queuedJob.JobId)
+	}
+	expectJobs(t, "queued jobs", filterJobs(db.QueuedJobs(), first, second), first)
+}
+
+func testOrderedJobsMatchesCache(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	dequeued := advanceJob(t, db, queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-2*time.Minute))), job.JobStage_Dequeued)
+	started := advanceJob(t, db, queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-time.Minute))), job.JobStage_Dequeued)
+	started = advanceJob(t, db, started, job.JobStage_Started)
+	// Records written directly to the database without going through the cache are ignored
+	uncached := newJob(job.JobType_Anchor, now.Add(-time.Minute))
+	uncached.Stage = job.JobStage_Dequeued
+	if err := db.WriteJob(uncached); err != nil {
+		t.Fatalf("failed to write job: %v", err)
+	}
+
+	expectJobs(t, "dequeued jobs", filterJobs(db.OrderedJobs(job.JobStage_Dequeued), dequeued, started, uncached), dequeued)
+	expectJobs(t, "started jobs", filterJobs(db.OrderedJobs(job.JobStage_Started), dequeued, started, uncached), started)
+}
+
+func testAdvanceJobUpdatesDatabaseAndCache(t *testing.T, db manager.Database, cache manager.Cache) {
+	jobState := queueJob(t, db, newJob(job.JobType_Anchor, time.Now().Add(-time.Minute)))
+	jobState = advanceJob(t, db, jobState, job.JobStage_Dequeued)
+
+	if latestJob, found, err := db.GetJob(jobState.JobId); err != nil {
+		t.Fatalf("failed to get job: %v", err)
+	} else if !found || (latestJob.Stage != job.JobStage_Dequeued) || (job.JobVersion(latestJob) != 1) {
+		t.Fatalf("expected dequeued job with version 1, found: %s", manager.PrintJob(latestJob))
+	}
+	if cachedJob, found := cache.JobById(jobState.JobId); !found || (cachedJob.Stage != job.JobStage_Dequeued) || (job.JobVersion(cachedJob) != 1) {
+		t.Fatalf("expected cached dequeued job with version 1, found: %s", manager.PrintJob(cachedJob))
+	}
+	// Scheduled jobs stay out of the cache
+	scheduled := queueJob(t, db, newJob(job.JobType_Anchor, time.Now().Add(-time.Minute)))
+	scheduled.Ts = time.Now().Add(time.Hour)
+	advanceJob(t, db, scheduled, job.JobStage_Scheduled)
+	if _, found := cache.JobById(scheduled.JobId); found {
+		t.Fatalf("scheduled job added to cache: %s", scheduled.JobId)
+	}
+}
+
+func testAdvanceJobRejectsStaleVersion(t *testing.T, db manager.Database, cache manager.Cache) {
+	dequeued := advanceJob(t, db, queueJob(t, db, newJob(job.JobType_Anchor, time.Now().Add(-time.Minute))), job.JobStage_Dequeued)
+	started := advanceJob(t, db, dequeued, job.JobStage_Started)
+
+	stale := dequeued
+	stale.Stage = job.JobStage_Failed
+	if err := db.AdvanceJob(stale); !errors.Is(err, manager.Error_JobConflict) {
+		t.Fatalf("expected job conflict, found: %v", err)
+	}
+	if cachedJob, _ := cache.JobById(started.JobId); cachedJob.Stage != job.JobStage_Started {
+		t.Fatalf("expected cached started job, found: %s", manager.PrintJob(cachedJob))
+	}
+	if latestJob, _, err := db.GetJob(started.JobId); err != nil {
+		t.Fatalf("failed to get job: %v", err)
+	} else if latestJob.Stage != job.JobStage_Started {
+		t.Fatalf("expected started job, found: %s", manager.PrintJob(latestJob))
+	}
+}
+
+func testDeployLayoutRoundTrip(t *testing.T, db manager.Database, cache manager.Cache) {
+	layout := manager.Layout{
+		Clusters: map[string]*manager.Cluster{
+			"cluster": {
+				ServiceTasks: &manager.TaskSet{Tasks: map[string]*manager.Task{"service": {Id: "task", Name: "container"}}},
+				Repo:         &manager.Repo{Name: "repo", Public: true},
+			},
+		},
+	}
+	expectLayout := func(desc string, jobState job.JobState) {
+		t.Helper()
+		if jobLayout, ok := jobState.Params[job.DeployJobParam_Layout].(manager.Layout); !ok {
+			t.Fatalf("expected %s layout, found: %T", desc, jobState.Params[job.DeployJobParam_Layout])
+		} else if !reflect.DeepEqual(jobLayout, layout) {
+			t.Fatalf("expected %s layout: %+v, found: %+v", desc, layout, jobLayout)
+		}
+	}
+	jobState := newJob(job.JobType_Deploy, time.Now().Add(-time.Minute))
+	jobState.Params[job.DeployJobParam_Component] = "ceramic"
+	jobState.Params[job.DeployJobParam_Layout] = layout
+	queued := queueJob(t, db, jobState)
+	queuedJobs := filterJobs(db.QueuedJobs(), queued)
+	expectJobs(t, "queued jobs", queuedJobs, queued)
+	expectLayout("queued", queuedJobs[0])
+
+	dequeued := advanceJob(t, db, queued, job.JobStage_Dequeued)
+	started := advanceJob(t, db, dequeued, job.JobStage_Started)
+	if latestJob, found, err := db.GetJob(started.JobId); err != nil || !found {
+		t.Fatalf("failed to get job: %v", err)
+	} else {
+		expectLayout("latest", latestJob)
+	}
+	// A rejected update loads the latest state of the job into the cache
+	cache.DeleteJob(started.JobId)
+	stale := dequeued
+	stale.Stage = job.JobStage_Failed
+	if err := db.AdvanceJob(stale); !errors.Is(err, manager.Error_JobConflict) {
+		t.Fatalf("expected job conflict, found: %v", err)
+	} else if cachedJob, found := cache.JobById(started.JobId); !found || (cachedJob.Stage != job.JobStage_Started) {
+		t.Fatalf("expected cached started job, found: %s", manager.PrintJob(cachedJob))
+	} else {
+		expectLayout("cached", cachedJob)
+	}
+}
+
+func testAdvanceJobFencing(t *testing.T, db manager.Database, _ manager.Cache) {
+	name := uuid.New().String()
+	lease, acquired, err := db.AcquireLease(name, "first", "http://first", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("failed to acquire lease: %v", err)
+	}
+	db.Fence(lease)
+	defer db.Fence(manager.Lease{})
+
+	jobState := advanceJob(t, db, queueJob(t, db, newJob(job.JobType_Anchor, time.Now().Add(-time.Minute))), job.JobStage_Dequeued)
+	if err = db.ReleaseLease(lease); err != nil {
+		t.Fatalf("failed to release lease: %v", err)
+	} else if _, acquired, err = db.AcquireLease(name, "second", "http://second", time.Minute); err != nil || !acquired {
+		t.Fatalf("failed to take over lease: %v", err)
+	}
+	jobState.Stage = job.JobStage_Started
+	if err = db.AdvanceJob(jobState); !errors.Is(err, manager.Error_NotLeader) {
+		t.Fatalf("expected fenced update to be rejected, found: %v", err)
+	}
+	db.Fence(manager.Lease{})
+	advanceJob(t, db, jobState, job.JobStage_Started)
+}
+
+func testInitializeJobs(t *testing.T, db manager.Database, cache manager.Cache) {
+	queued := queueJob(t, db, newJob(job.JobType_Anchor, time.Now().Add(-2*time.Minute)))
+	started := advanceJob(t, db, queueJob(t, db, newJob(job.JobType_Anchor, time.Now().Add(-time.Minute))), job.JobStage_Started)
+	cache.DeleteJob(started.JobId)
+
+	if err := db.InitializeJobs(); err != nil {
+		t.Fatalf("failed to initialize jobs: %v", err)
+	}
+	if cachedJob, found := cache.JobById(started.JobId); !found || (cachedJob.Stage != job.JobStage_Started) {
+		t.Fatalf("expected cached started job, found: %s", manager.PrintJob(cachedJob))
+	}
+	if _, found := cache.JobById(queued.JobId); found {
+		t.Fatalf("queued job added to cache: %s", queued.JobId)
+	}
+}
+
+func testJobHistory(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	queued := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-2*time.Minute)))
+	dequeued := queued
+	dequeued.Ts = now.Add(-time.Minute)
+	advanceJob(t, db, dequeued, job.JobStage_Dequeued)
+
+	if history, err := db.GetJobHistory(queued.JobId); err != nil {
+		t.Fatalf("failed to get job history: %v", err)
+	} else if (len(history) != 2) || (history[0].Stage != job.JobStage_Queued) || (history[1].Stage != job.JobStage_Dequeued) {
+		t.Fatalf("expected queued and dequeued records, found: %v", history)
+	}
+	if _, found, err := db.GetJob(uuid.New().String()); err != nil {
+		t.Fatalf("failed to get job: %v", err)
+	} else if found {
+		t.Fatalf("found unknown job")
+	}
+}
+
+func testIterateByType(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	earlier := queueJob(t, db, newJob(job.JobType_Workflow, now.Add(-2*time.Minute)))
+	later := queueJob(t, db, newJob(job.JobType_Workflow, now.Add(-time.Minute)))
+	future := queueJob(t, db, newJob(job.JobType_Workflow, now.Add(time.Hour)))
+	other := queueJob(t, db, newJob(job.JobType_Anchor, now.Add(-time.Minute)))
+
+	for _, asc := range []bool{true, false} {
+		jobs := make([]job.JobState, 0, 0)
+		if err := db.IterateByType(job.JobType_Workflow, asc, func(jobState job.JobState) bool {
+			jobs = append(jobs, jobState)
+			return true
+		}); err != nil {
+			t.Fatalf("failed to iterate jobs: %v", err)
+		}
+		if asc {
+			expectJobs(t, "ascending jobs", filterJobs(jobs, earlier, later, future, other), earlier, later)
+		} else {
+			expectJobs(t, "descending jobs", filterJobs(jobs, earlier, later, future, other), later, earlier)
+		}
+	}
+}
+
+func testListJobs(t *testing.T, db manager.Database, _ manager.Cache) {
+	now := time.Now()
+	source := uuid.New().String()
+	jobs := make([]job.JobState, 3)
+	for idx := range jobs {
+		jobState := newJob(job.JobType_Anchor, now.Add(time.Duration(idx-len(jobs))*time.Minute))
+		jobState.Params[job.JobParam_Source] = source
+		jobs[idx] = queueJob(t, db, jobState)
+	}
+	filter := manager.JobFilter{Source: source, Start: now.Add(-time.Hour), Asc: true, Limit: 2}
+	page, err := db.ListJobs(filter)
+	if err != nil {
+		t.Fatalf("failed to list jobs: %v", err)
+	} else if expectJobs(t, "first page", page.Jobs, jobs[0], jobs[1]); len(page.Cursor) == 0 {
+		t.Fatalf("missing cursor for first page")
+	}
+	filter.Cursor = page.Cursor
+	if page, err = db.ListJobs(filter); err != nil {
+		t.Fatalf("failed to list jobs: %v", err)
+	} else if expectJobs(t, "second page", page.Jobs, jobs[2]); len(page.Cursor) > 0 {
+		t.Fatalf("unexpected cursor for last page")
+	}
+	filter = manager.JobFilter{Source: source, Stage: job.JobStage_Queued, Start: now.Add(-time.Hour), Limit: 10}
+	if page, err := db.ListJobs(filter); err != nil {
+		t.Fatalf("failed to list jobs: %v", err)
+	} else {
+		expectJobs(t, "descending jobs", page.Jobs, jobs[2], jobs[1], jobs[0])
+	}
+}
+
+func testTags(t *testing.T, db manager.Database, _ manager.Cache) {
+	component := manager.DeployComponent(uuid.New().String())
+	if err := db.UpdateDeployTag(component, "deployTag"); err != nil {
+		t.Fatalf("failed to update deploy tag: %v", err)
+	} else if err = db.UpdateBuildTag(component, "buildTag"); err != nil {
+		t.Fatalf("failed to update build tag: %v", err)
+	}
+	if buildTags, err := db.GetBuildTags(); err != nil {
+		t.Fatalf("failed to get build tags: %v", err)
+	} else if buildTags[component] != "buildTag" {
+		t.Fatalf("expected build tag, found: %s", buildTags[component])
+	}
+	if deployTags, err := db.GetDeployTags(); err != nil {
+		t.Fatalf("failed to get deploy tags: %v", err)
+	} else if deployTags[component] != "deployTag" {
+		t.Fatalf("expected deploy tag, found: %s", deployTags[component])
+	}
+}
+
+func testSchedules(t *testing.T, db manager.Database, _ manager.Cache) {
+	// Schedule times are stored with second granularity
+	nextRun := time.Now().Add(time.Hour).Truncate(time.Second)
+	schedule := manager.JobSchedule{Id: uuid.New().String(), Spec: "@hourly", Type: job.JobType_Anchor, Enabled: true, NextRun: nextRun}
+	if err := db.AddSchedule(schedule); err != nil {
+		t.Fatalf("failed to add schedule: %v", err)
+	} else if err = db.AddSchedule(schedule); !errors.Is(err, manager.Error_ScheduleExists) {
+		t.Fatalf("expected existing schedule error, found: %v", err)
+	}
+	if storedSchedule, found, err := db.GetSchedule(schedule.Id); err != nil {
+		t.Fatalf("failed to get schedule: %v", err)
+	} else if !found || !storedSchedule.NextRun.Equal(nextRun) {
+		t.Fatalf("expected schedule, found: %v", storedSchedule)
+	}
+	updatedSchedule := schedule
+	updatedSchedule.NextRun = nextRun.Add(time.Hour)
+	if updated, err := db.UpdateSchedule(updatedSchedule, nextRun); err != nil || !updated {
+		t.Fatalf("failed to update schedule: %v", err)
+	} else if updated, err = db.UpdateSchedule(updatedSchedule, nextRun); err != nil || updated {
+		t.Fatalf("expected stale schedule update to be rejected: %v", err)
+	}
+	if schedules, err := db.GetSchedules(); err != nil {
+		t.Fatalf("failed to get schedules: %v", err)
+	} else {
+		for idx := 1; idx < len(schedules); idx++ {
+			if schedules[idx-1].Id > schedules[idx].Id {
+				t.Fatalf("schedules out of order: %s, %s", schedules[idx-1].Id, schedules[idx].Id)
+			}
+		}
+		found := false
+		for _, storedSchedule := range schedules {
+			found = found || ((storedSchedule.Id == schedule.Id) && storedSchedule.NextRun.Equal(updatedSchedule.NextRun))
+		}
+		if !found {
+			t.Fatalf("missing updated schedule: %s", schedule.Id)
+		}
+	}
+}
+
+func testPipelines(t *testing.T, db manager.Database, _ manager.Cache) {
+	// Pipeline timestamps are stored with second granularity
+	now := time.Now().Truncate(time.Second)
+	pipelines := []manager.Pipeline{
+		{Id: uuid.New().String(), Status: manager.PipelineStatus_Running, Ts: now.Add(-time.Minute)},
+		{Id: uuid.New().String(), Status: manager.PipelineStatus_Running, Ts: now.Add(-2 * time.Minute)},
+		{Id: uuid.New().String(), Status: manager.PipelineStatus_Completed, Ts: now.Add(-3 * time.Minute)},
+	}
+	for idx := range pipelines {
+		pipelines[idx].Stages = []manager.PipelineStage{{Name: "stage", Type: job.JobType_Anchor, Status: manager.PipelineStageStatus_Pending}}
+		if err := db.WritePipeline(pipelines[idx]); err != nil {
+			t.Fatalf("failed to write pipeline: %v", err)
+		}
+	}
+	if pipeline, found, err := db.GetPipeline(pipelines[0].Id); err != nil {
+		t.Fatalf("failed to get pipeline: %v", err)
+	} else if !found || (len(pipeline.Stages) != 1) || !pipeline.Ts.Equal(pipelines[0].Ts) {
+		t.Fatalf("expected pipeline, found: %v", pipeline)
+	}
+	if activePipelines, err := db.ActivePipelines(); err != nil {
+		t.Fatalf("failed to get active pipelines: %v", err)
+	} else {
+		ids := make([]string, 0, 0)
+		for _, pipeline := range activePipelines {
+			for _, p := range pipelines {
+				if pipeline.Id == p.Id {
+					ids = append(ids, pipeline.Id)
+				}
+			}
+		}
+		if (len(ids) != 2) || (ids[0] != pipelines[1].Id) || (ids[1] != pipelines[0].Id) {
+			t.Fatalf("expected running pipelines in order of submission, found: %v", ids)
+		}
+	}
+}
+
+func testLeases(t *testing.T, db manager.Database, _ manager.Cache) {
+	name := uuid.New().String()
+	lease, acquired, err := db.AcquireLease(name, "first", "http://first", time.Minute)
+	if err != nil || !acquired || (lease.Token != 1) {
+		t.Fatalf("failed to acquire lease: %v, %v", err, lease)
+	}
+	if renewedLease, acquired, err := db.AcquireLease(name, "first", "http://first", time.Minute); err != nil || !acquired || (renewedLease.Token != lease.Token) {
+		t.Fatalf("failed to renew lease: %v, %v", err, renewedLease)
+	}
+	// Others can find out where to reach the owner
+	if currentLease, acquired, err := db.AcquireLease(name, "second", "http://second", time.Minute); err != nil || acquired || (currentLease.Owner != "first") || (currentLease.Address != "http://first") {
+		t.Fatalf("expected lease held by first owner: %v, %v", err, currentLease)
+	}
+	if err = db.ReleaseLease(lease); err != nil {
+		t.Fatalf("failed to release lease: %v", err)
+	}
+	if takenOverLease, acquired, err := db.AcquireLease(name, "second", "http://second", time.Minute); err != nil || !acquired || (takenOverLease.Token != lease.Token+1) {
+		t.Fatalf("failed to take over lease: %v, %v", err, takenOverLease)
+	}
+	// Releasing a lease that has changed owners has no effect
+	if err = db.ReleaseLease(lease); err != nil {
+		t.Fatalf("failed to release lease: %v", err)
+	} else if currentLease, acquired, err := db.AcquireLease(name, "first", "http://first", time.Minute); err != nil || acquired || (currentLease.Owner != "second") || (currentLease.Address != "http://second") {
+		t.Fatalf("expected lease held by second owner: %v, %v", err, currentLease)
+	}
+}
+
+// The paused state is shared by all users of a database, so it's reset once the test is done
+func testPaused(t *testing.T, db manager.Database, _ manager.Cache) {
+	t.Cleanup(func() {
+		if err := db.SetPaused(false); err != nil {
+			t.Errorf("failed to unpause: %v", err)
+		}
+	})
+	for _, paused := range []bool{true, true, false, true} {
+		if err := db.SetPaused(paused); err != nil {
+			t.Fatalf("failed to set paused state: %v", err)
+		} else if isPaused, err := db.IsPaused(); err != nil {
+			t.Fatalf("failed to get paused state: %v", err)
+		} else if isPaused != paused {
+			t.Fatalf("expected paused: %t, found: %t", paused, isPaused)
+		}
+	}
+}
+
+func newJob(jobType job.JobType, ts time.Time) job.JobState {
+	return job.JobState{
+		JobId:  uuid.New().String(),
+		Stage:  job.JobStage_Queued,
+		Type:   jobType,
+		Ts:     ts,
+		Params: map[string]interface{}{},
+	}
+}
+
+func queueJob(t *testing.T, db manager.Database, jobState job.JobState) job.JobState {
+	t.Helper()
+	queuedJob, err := db.QueueJob(jobState)
+	if err != nil {
+		t.Fatalf("failed to queue job: %v", err)
+	}
+	return queuedJob
+}
+
+// advanceJob moves a job to a new stage the same way as `manager.AdvanceJob`, and returns the job's new state
+func advanceJob(t *testing.T, db manager.Database, jobState job.JobState, jobStage job.JobStage) job.JobState {
+	t.Helper()
+	jobState.Stage = jobStage
+	jobState.Params = copyParams(jobState.Params)
+	if err := db.AdvanceJob(jobState); err != nil {
+		t.Fatalf("failed to advance job: %v, %s", err, manager.PrintJob(jobState))
+	}
+	jobState.Params[job.JobParam_Version] = job.JobVersion(jobState) + 1
+	return jobState
+}
+
+func copyParams(params map[string]interface{}) map[string]interface{} {
+	paramsCopy := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		paramsCopy[k] = v
+	}
+	return paramsCopy
+}
+
+// filterJobs returns the records for the given jobs, ignoring records created by other tests
+func filterJobs(jobs []job.JobState, ownJobs ...job.JobState) []job.JobState {
+	jobIds := make(map[string]bool, len(ownJobs))
+	for _, jobState := range ownJobs {
+		jobIds[jobState.JobId] = true
+	}
+	filteredJobs := make([]job.JobState, 0, 0)
+	for _, jobState := range jobs {
+		if jobIds[jobState.JobId] {
+			filteredJobs = append(filteredJobs, jobState)
+		}
+	}
+	return filteredJobs
+}
+
+func expectJobs(t *testing.T, name string, jobs []job.JobState, expectedJobs ...job.JobState) {
+	t.Helper()
+	if len(jobs) != len(expectedJobs) {
+		t.Fatalf("%s: expected %d jobs, found %d: %v", name, len(expectedJobs), len(jobs), jobs)
+	}
+	for idx := range jobs {
+		if jobs[idx].JobId != expectedJobs[idx].JobId {
+			t.Fatalf("%s: expected job %d to be %s, found %s", name, idx, expectedJobs[idx].JobId, jobs[idx].JobId)
+		}
+	}
+}
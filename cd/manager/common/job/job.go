@@ -3,6 +3,7 @@ package job
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -20,6 +21,15 @@ func IsActiveJob(jobState JobState) bool {
 	return (jobState.Stage == JobStage_Started) || (jobState.Stage == JobStage_Waiting)
 }
 
+// IsWaitingJob returns true if the job has not started processing yet, i.e. it can still be rescheduled or edited.
+func IsWaitingJob(jobState JobState) bool {
+	return (jobState.Stage == JobStage_Scheduled) || (jobState.Stage == JobStage_Queued) || (jobState.Stage == JobStage_Dequeued)
+}
+
+func IsCancelableJob(jobState JobState) bool {
+	return IsWaitingJob(jobState) || IsActiveJob(jobState)
+}
+
 func IsTimedOut(jobState JobState, delay time.Duration) bool {
 	// If no timestamp was stored, use the timestamp from the last update.
 	startTime := jobState.Ts
@@ -29,6 +39,66 @@ func IsTimedOut(jobState JobState, delay time.Duration) bool {
 	return time.Now().Add(-delay).After(startTime)
 }
 
+func JobPriority(jobState JobState) int64 {
+	priority, _ := intParam(jobState.Params, JobParam_Priority, false)
+	return priority
+}
+
+// JobVersion returns the version of a job's state, which is 0 for jobs that haven't been updated since they were queued
+func JobVersion(jobState JobState) int64 {
+	version, _ := intParam(jobState.Params, JobParam_Version, false)
+	return version
+}
+
+// JobAttempts returns the number of times a job was retried after transient failures
+func JobAttempts(jobState JobState) int64 {
+	attempts, _ := intParam(jobState.Params, JobParam_Attempts, false)
+	return attempts
+}
+
+// RetryAt returns the time after which a job that failed with a transient error can be retried, if the job is waiting
+// to be retried.
+func RetryAt(jobState JobState) (time.Time, bool) {
+	if retryAt, found := jobState.Params[JobParam_RetryAt].(string); found {
+		if parsedRetryAt, err := time.Parse(time.RFC3339Nano, retryAt); err == nil {
+			return parsedRetryAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NotBefore returns the earliest time at which a job can be queued for processing, if one was set.
+func NotBefore(jobState JobState) (time.Time, bool) {
+	if notBefore, found := jobState.Params[JobParam_NotBefore].(string); found {
+		if parsedNotBefore, err := time.Parse(time.RFC3339Nano, notBefore); err == nil {
+			return parsedNotBefore, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseNotBefore parses an absolute time in RFC3339 format, or a duration relative to `now` with an optional "in "
+// prefix, e.g. "2024-01-02T15:04:05Z", "30m", or "in 1h30m".
+func ParseNotBefore(notBefore string, now time.Time) (time.Time, error) {
+	if parsedNotBefore, err := time.Parse(time.RFC3339Nano, notBefore); err == nil {
+		return parsedNotBefore, nil
+	} else if delay, err := time.ParseDuration(strings.TrimPrefix(strings.TrimSpace(notBefore), "in ")); err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", JobParam_NotBefore, notBefore)
+	} else {
+		return now.Add(delay), nil
+	}
+}
+
+// IsLaterJobState returns true if `a` is a later state of a job than `b`. The version of a job's state increases with
+// every update, whereas timestamps can go back and forth, e.g. when a job is rescheduled, so states are compared by
+// version. Only states with the same version, i.e. written without being versioned, are compared by timestamp.
+func IsLaterJobState(a, b JobState) bool {
+	if aVersion, bVersion := JobVersion(a), JobVersion(b); aVersion != bVersion {
+		return aVersion > bVersion
+	}
+	return a.Ts.After(b.Ts)
+}
+
 func CreateJobTable(ctx context.Context, client *dynamodb.Client, table string) error {
 	createTableInput := dynamodb.CreateTableInput{
 		BillingMode: types.BillingModePayPerRequest,
@@ -115,33 +185,7 @@ func CreateJobTable(ctx context.Context, client *dynamodb.Client, table string)
 	return utils.CreateTable(ctx, client, &createTableInput)
 }
 
+// CreateWorkflowJob returns the workflow described by a workflow job's params
 func CreateWorkflowJob(jobState JobState) (Workflow, error) {
-	if org, found := jobState.Params[WorkflowJobParam_Org].(string); !found {
-		return Workflow{}, fmt.Errorf("missing org")
-	} else if repo, found := jobState.Params[WorkflowJobParam_Repo].(string); !found {
-		return Workflow{}, fmt.Errorf("missing repo")
-	} else if ref, found := jobState.Params[WorkflowJobParam_Ref].(string); !found {
-		return Workflow{}, fmt.Errorf("missing ref")
-	} else if workflow, found := jobState.Params[WorkflowJobParam_Workflow].(string); !found {
-		return Workflow{}, fmt.Errorf("missing workflow")
-	} else {
-		workflowInputs := make(map[string]interface{})
-		if inputs, found := jobState.Params[WorkflowJobParam_Inputs].(map[string]interface{}); found {
-			workflowInputs = inputs
-		}
-		workflowRunUrl, _ := jobState.Params[WorkflowJobParam_Url].(string)
-		var workflowRunId int64 = 0
-		if id, found := jobState.Params[JobParam_Id].(float64); found {
-			workflowRunId = int64(id)
-		}
-		workflowLabels := make([]string, 0)
-		if labels, found := jobState.Params[WorkflowJobParam_Labels].([]interface{}); found {
-			for _, label := range labels {
-				if l, ok := label.(string); ok {
-					workflowLabels = append(workflowLabels, l)
-				}
-			}
-		}
-		return Workflow{org, repo, workflow, ref, workflowInputs, workflowRunUrl, workflowRunId, workflowLabels}, nil
-	}
+	return DecodeWorkflowParams(jobState.Params)
 }
@@ -0,0 +1,43 @@
+package job
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsLaterJobState(t *testing.T) {
+	now := time.Now()
+	jobState := func(stage JobStage, ts time.Time, version int64) JobState {
+		params := map[string]interface{}{}
+		if version > 0 {
+			params[JobParam_Version] = float64(version)
+		}
+		return JobState{JobId: "job", Stage: stage, Ts: ts, Params: params}
+	}
+	tests := []struct {
+		name  string
+		a     JobState
+		b     JobState
+		later bool
+	}{
+		{"later version", jobState(JobStage_Started, now, 2), jobState(JobStage_Dequeued, now, 1), true},
+		{"earlier version", jobState(JobStage_Dequeued, now, 1), jobState(JobStage_Started, now, 2), false},
+		{"updated since queued", jobState(JobStage_Queued, now, 1), jobState(JobStage_Queued, now, 0), true},
+		// Timestamps don't matter across versions, e.g. for jobs rescheduled to an earlier time
+		{"rescheduled earlier", jobState(JobStage_Scheduled, now, 2), jobState(JobStage_Scheduled, now.Add(time.Hour), 1), true},
+		{"queued before rescheduled", jobState(JobStage_Queued, now.Add(time.Hour), 1), jobState(JobStage_Scheduled, now, 2), false},
+		{"came due", jobState(JobStage_Queued, now, 1), jobState(JobStage_Scheduled, now, 0), true},
+		// Stages don't matter either, e.g. for jobs that failed while being prepared and were requeued
+		{"requeued", jobState(JobStage_Scheduled, now, 2), jobState(JobStage_Dequeued, now, 1), true},
+		{"later timestamp", jobState(JobStage_Queued, now.Add(time.Second), 0), jobState(JobStage_Queued, now, 0), true},
+		{"earlier timestamp", jobState(JobStage_Queued, now, 0), jobState(JobStage_Queued, now.Add(time.Second), 0), false},
+		{"same state", jobState(JobStage_Queued, now, 1), jobState(JobStage_Queued, now, 1), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if later := IsLaterJobState(test.a, test.b); later != test.later {
+				t.Fatalf("expected later: %t, found: %t", test.later, later)
+			}
+		})
+	}
+}
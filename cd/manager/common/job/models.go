@@ -21,6 +21,7 @@ const (
 type JobStage string
 
 const (
+	JobStage_Scheduled JobStage = "scheduled"
 	JobStage_Queued    JobStage = "queued"
 	JobStage_Dequeued  JobStage = "dequeued"
 	JobStage_Skipped   JobStage = "skipped"
@@ -37,6 +38,28 @@ const (
 	JobParam_WaitTime string = "waitTime"
 	JobParam_Start    string = "start"
 	JobParam_Source   string = "source"
+	// Version of the encoding used for the job params, see `ParamsVersion`
+	JobParam_ParamsVersion string = "paramsVersion"
+	// Client-supplied key used to de-duplicate job submissions
+	JobParam_IdempotencyKey string = "idempotencyKey"
+	// Jobs with higher priorities are processed first
+	JobParam_Priority string = "priority"
+	// ID of the job that a job was cloned from
+	JobParam_RerunOf string = "rerunOf"
+	// Earliest time at which a job can be queued for processing, absolute (RFC3339) or relative to submission (e.g. "in
+	// 30m"). Relative times are converted to absolute times when the job is submitted.
+	JobParam_NotBefore string = "notBefore"
+	// ID of the schedule that queued a job
+	JobParam_Schedule string = "schedule"
+	// ID of the pipeline, and name of the pipeline stage, that a job was submitted for
+	JobParam_Pipeline      string = "pipeline"
+	JobParam_PipelineStage string = "pipelineStage"
+	// Number of times a job was retried after transient failures, and when the job can next be retried
+	JobParam_Attempts string = "attempts"
+	JobParam_RetryAt  string = "retryAt"
+	// Number of times a job was updated, used to detect concurrent updates of the same job. Distinct from the anchor
+	// worker version param.
+	JobParam_Version string = "jobVersion"
 )
 
 const (
@@ -63,6 +86,11 @@ const (
 	AnchorJobParam_Overrides string = "overrides"
 )
 
+const (
+	E2eTestJobParam_PrivatePublic     string = "private-public"
+	E2eTestJobParam_LocalClientPublic string = "local_client-public"
+)
+
 const (
 	WorkflowJobParam_Name         string = "name"
 	WorkflowJobParam_Org          string = "org"
@@ -0,0 +1,389 @@
+package job
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParamsVersion is the version of the job parameter encoding written by NormalizeParams. Jobs written without a version,
+// e.g. by older versions of the CD manager or directly to the database by CI, are treated as version 0 and decoded
+// leniently, i.e. malformed optional values are ignored instead of being reported as errors.
+const ParamsVersion = 1
+
+// MutableParams are the params that can be edited while a job is queued. Other params either identify what the job does
+// (e.g. the component being deployed) or are filled in as the job is processed.
+var MutableParams = map[JobType][]string{
+	JobType_Deploy:   {DeployJobParam_Sha, DeployJobParam_ShaTag, DeployJobParam_Manual},
+	JobType_Anchor:   {AnchorJobParam_Overrides},
+	JobType_Workflow: {WorkflowJobParam_Ref, WorkflowJobParam_Inputs, WorkflowJobParam_Labels},
+}
+
+// VolatileParams are the params filled in as a job is processed, and which are not carried over when a job is cloned
+var VolatileParams = []string{
+	JobParam_Id,
+	JobParam_Error,
+	JobParam_WaitTime,
+	JobParam_Start,
+	JobParam_IdempotencyKey,
+	JobParam_NotBefore,
+	JobParam_Schedule,
+	JobParam_Pipeline,
+	JobParam_PipelineStage,
+	JobParam_Attempts,
+	JobParam_RetryAt,
+	JobParam_Version,
+	DeployJobParam_DeployTag,
+	DeployJobParam_Layout,
+	AnchorJobParam_Delayed,
+	AnchorJobParam_Stalled,
+	E2eTestJobParam_PrivatePublic,
+	E2eTestJobParam_LocalClientPublic,
+	WorkflowJobParam_Url,
+}
+
+// DeployParams are the parameters for deploy jobs
+type DeployParams struct {
+	Component string
+	Sha       string
+	ShaTag    string
+	DeployTag string
+	Manual    bool
+	Force     bool
+	Rollback  bool
+}
+
+// AnchorParams are the parameters for anchor jobs
+type AnchorParams struct {
+	Version   string
+	Overrides map[string]string
+	Delayed   bool
+	Stalled   bool
+}
+
+// TestParams are the parameters for smoke and E2E test jobs, which don't have any type-specific parameters
+type TestParams struct{}
+
+// DecodeParams decodes the parameters for a job into the typed parameters for its job type, i.e. `DeployParams`,
+// `AnchorParams`, `TestParams`, or `Workflow`.
+func DecodeParams(jobState JobState) (interface{}, error) {
+	switch jobState.Type {
+	case JobType_Deploy:
+		return DecodeDeployParams(jobState.Params)
+	case JobType_Anchor:
+		return DecodeAnchorParams(jobState.Params)
+	case JobType_TestE2E, JobType_TestSmoke:
+		return TestParams{}, checkParamsVersion(jobState.Params)
+	case JobType_Workflow:
+		return DecodeWorkflowParams(jobState.Params)
+	default:
+		return nil, fmt.Errorf("unknown job type: %s", jobState.Type)
+	}
+}
+
+// NormalizeParams validates the parameters for a job and re-encodes them using the current params version. Parameters
+// that aren't specific to the job type, e.g. the job source, are preserved as-is.
+func NormalizeParams(jobState JobState) (map[string]interface{}, error) {
+	params := make(map[string]interface{}, len(jobState.Params)+1)
+	for k, v := range jobState.Params {
+		params[k] = v
+	}
+	// Reject parameters encoded by a newer version of the CD manager, then decode strictly using the current version.
+	if err := checkParamsVersion(params); err != nil {
+		return nil, err
+	} else if _, err = stringParam(params, JobParam_Source, false, true); err != nil {
+		return nil, err
+	} else if _, err = stringParam(params, JobParam_IdempotencyKey, false, true); err != nil {
+		return nil, err
+	} else if _, err = intParam(params, JobParam_Priority, true); err != nil {
+		return nil, err
+	} else if notBefore, err := stringParam(params, JobParam_NotBefore, false, true); err != nil {
+		return nil, err
+	} else if len(notBefore) > 0 {
+		// Store relative times as absolute times so that they don't change meaning when the job is re-normalized
+		if parsedNotBefore, err := ParseNotBefore(notBefore, time.Now()); err != nil {
+			return nil, err
+		} else {
+			params[JobParam_NotBefore] = parsedNotBefore.Format(time.RFC3339Nano)
+		}
+	}
+	params[JobParam_ParamsVersion] = ParamsVersion
+	jobState.Params = params
+	if typedParams, err := DecodeParams(jobState); err != nil {
+		return nil, err
+	} else {
+		var encodedParams map[string]interface{}
+		switch p := typedParams.(type) {
+		case DeployParams:
+			encodedParams = p.Encode()
+		case AnchorParams:
+			encodedParams = p.Encode()
+		case Workflow:
+			encodedParams = p.Encode()
+		}
+		for k, v := range encodedParams {
+			params[k] = v
+		}
+		return params, nil
+	}
+}
+
+func DecodeDeployParams(params map[string]interface{}) (DeployParams, error) {
+	p := DeployParams{}
+	strict, err := isStrict(params)
+	if err != nil {
+		return DeployParams{}, err
+	} else if p.Component, err = stringParam(params, DeployJobParam_Component, true, true); err != nil {
+		return DeployParams{}, err
+	} else if p.Component == "" {
+		return DeployParams{}, fmt.Errorf("missing %s", DeployJobParam_Component)
+	} else if p.Sha, err = stringParam(params, DeployJobParam_Sha, true, true); err != nil {
+		return DeployParams{}, err
+	} else if p.ShaTag, err = stringParam(params, DeployJobParam_ShaTag, true, true); err != nil {
+		return DeployParams{}, err
+	} else if p.DeployTag, err = stringParam(params, DeployJobParam_DeployTag, false, strict); err != nil {
+		return DeployParams{}, err
+	} else if p.Manual, err = boolParam(params, DeployJobParam_Manual, strict); err != nil {
+		return DeployParams{}, err
+	} else if p.Force, err = boolParam(params, DeployJobParam_Force, strict); err != nil {
+		return DeployParams{}, err
+	} else if p.Rollback, err = boolParam(params, DeployJobParam_Rollback, strict); err != nil {
+		return DeployParams{}, err
+	}
+	return p, nil
+}
+
+func (p DeployParams) Encode() map[string]interface{} {
+	params := map[string]interface{}{
+		JobParam_ParamsVersion:   ParamsVersion,
+		DeployJobParam_Component: p.Component,
+		DeployJobParam_Sha:       p.Sha,
+		DeployJobParam_ShaTag:    p.ShaTag,
+	}
+	if len(p.DeployTag) > 0 {
+		params[DeployJobParam_DeployTag] = p.DeployTag
+	}
+	setFlag(params, DeployJobParam_Manual, p.Manual)
+	setFlag(params, DeployJobParam_Force, p.Force)
+	setFlag(params, DeployJobParam_Rollback, p.Rollback)
+	return params
+}
+
+func DecodeAnchorParams(params map[string]interface{}) (AnchorParams, error) {
+	p := AnchorParams{}
+	strict, err := isStrict(params)
+	if err != nil {
+		return AnchorParams{}, err
+	} else if p.Version, err = stringParam(params, AnchorJobParam_Version, false, strict); err != nil {
+		return AnchorParams{}, err
+	} else if p.Overrides, err = stringMapParam(params, AnchorJobParam_Overrides, strict); err != nil {
+		return AnchorParams{}, err
+	} else if p.Delayed, err = boolParam(params, AnchorJobParam_Delayed, strict); err != nil {
+		return AnchorParams{}, err
+	} else if p.Stalled, err = boolParam(params, AnchorJobParam_Stalled, strict); err != nil {
+		return AnchorParams{}, err
+	}
+	return p, nil
+}
+
+func (p AnchorParams) Encode() map[string]interface{} {
+	params := map[string]interface{}{
+		JobParam_ParamsVersion: ParamsVersion,
+	}
+	if len(p.Version) > 0 {
+		params[AnchorJobParam_Version] = p.Version
+	}
+	if p.Overrides != nil {
+		// Store overrides in the same form in which they're decoded from JSON and DynamoDB
+		overrides := make(map[string]interface{}, len(p.Overrides))
+		for k, v := range p.Overrides {
+			overrides[k] = v
+		}
+		params[AnchorJobParam_Overrides] = overrides
+	}
+	setFlag(params, AnchorJobParam_Delayed, p.Delayed)
+	setFlag(params, AnchorJobParam_Stalled, p.Stalled)
+	return params
+}
+
+func DecodeWorkflowParams(params map[string]interface{}) (Workflow, error) {
+	w := Workflow{}
+	strict, err := isStrict(params)
+	if err != nil {
+		return Workflow{}, err
+	} else if w.Org, err = stringParam(params, WorkflowJobParam_Org, true, true); err != nil {
+		return Workflow{}, err
+	} else if w.Repo, err = stringParam(params, WorkflowJobParam_Repo, true, true); err != nil {
+		return Workflow{}, err
+	} else if w.Ref, err = stringParam(params, WorkflowJobParam_Ref, true, true); err != nil {
+		return Workflow{}, err
+	} else if w.Workflow, err = stringParam(params, WorkflowJobParam_Workflow, true, true); err != nil {
+		return Workflow{}, err
+	} else if w.Inputs, err = mapParam(params, WorkflowJobParam_Inputs, strict); err != nil {
+		return Workflow{}, err
+	} else if w.Url, err = stringParam(params, WorkflowJobParam_Url, false, strict); err != nil {
+		return Workflow{}, err
+	} else if w.Id, err = intParam(params, JobParam_Id, strict); err != nil {
+		return Workflow{}, err
+	} else if w.Labels, err = stringListParam(params, WorkflowJobParam_Labels, strict); err != nil {
+		return Workflow{}, err
+	}
+	if w.Inputs == nil {
+		w.Inputs = make(map[string]interface{})
+	}
+	if w.Labels == nil {
+		w.Labels = make([]string, 0)
+	}
+	return w, nil
+}
+
+func (w Workflow) Encode() map[string]interface{} {
+	params := map[string]interface{}{
+		JobParam_ParamsVersion:    ParamsVersion,
+		WorkflowJobParam_Org:      w.Org,
+		WorkflowJobParam_Repo:     w.Repo,
+		WorkflowJobParam_Ref:      w.Ref,
+		WorkflowJobParam_Workflow: w.Workflow,
+	}
+	if len(w.Inputs) > 0 {
+		params[WorkflowJobParam_Inputs] = w.Inputs
+	}
+	if len(w.Url) > 0 {
+		params[WorkflowJobParam_Url] = w.Url
+	}
+	if w.Id > 0 {
+		// Numbers are decoded from JSON and DynamoDB as floats
+		params[JobParam_Id] = float64(w.Id)
+	}
+	if len(w.Labels) > 0 {
+		labels := make([]interface{}, len(w.Labels))
+		for i, label := range w.Labels {
+			labels[i] = label
+		}
+		params[WorkflowJobParam_Labels] = labels
+	}
+	return params
+}
+
+func checkParamsVersion(params map[string]interface{}) error {
+	_, err := isStrict(params)
+	return err
+}
+
+// isStrict returns true if the params were encoded by a version of the CD manager that validates params, and returns an
+// error if the params were encoded by a newer, unsupported version.
+func isStrict(params map[string]interface{}) (bool, error) {
+	if version, err := intParam(params, JobParam_ParamsVersion, true); err != nil {
+		return false, err
+	} else if version > ParamsVersion {
+		return false, fmt.Errorf("unsupported params version: %d", version)
+	} else {
+		return version > 0, nil
+	}
+}
+
+func setFlag(params map[string]interface{}, key string, value bool) {
+	// Only store flags that are set so that the encoded params look the same as those written before versioning
+	if value {
+		params[key] = true
+	}
+}
+
+func stringParam(params map[string]interface{}, key string, required, strict bool) (string, error) {
+	if value, found := params[key]; !found || (value == nil) {
+		if required {
+			return "", fmt.Errorf("missing %s", key)
+		}
+		return "", nil
+	} else if s, ok := value.(string); ok {
+		return s, nil
+	} else if strict || required {
+		return "", fmt.Errorf("invalid %s: expected string, found %T", key, value)
+	}
+	return "", nil
+}
+
+func boolParam(params map[string]interface{}, key string, strict bool) (bool, error) {
+	if value, found := params[key]; !found || (value == nil) {
+		return false, nil
+	} else if b, ok := value.(bool); ok {
+		return b, nil
+	} else if strict {
+		return false, fmt.Errorf("invalid %s: expected bool, found %T", key, value)
+	}
+	return false, nil
+}
+
+func intParam(params map[string]interface{}, key string, strict bool) (int64, error) {
+	if value, found := params[key]; !found || (value == nil) {
+		return 0, nil
+	} else {
+		// Numbers are decoded from JSON and DynamoDB as floats, but might be ints if set in code
+		switch n := value.(type) {
+		case float64:
+			return int64(n), nil
+		case int:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		}
+		if strict {
+			return 0, fmt.Errorf("invalid %s: expected number, found %T", key, value)
+		}
+		return 0, nil
+	}
+}
+
+func mapParam(params map[string]interface{}, key string, strict bool) (map[string]interface{}, error) {
+	if value, found := params[key]; !found || (value == nil) {
+		return nil, nil
+	} else if m, ok := value.(map[string]interface{}); ok {
+		return m, nil
+	} else if strict {
+		return nil, fmt.Errorf("invalid %s: expected object, found %T", key, value)
+	}
+	return nil, nil
+}
+
+func stringMapParam(params map[string]interface{}, key string, strict bool) (map[string]string, error) {
+	if value, found := params[key]; !found || (value == nil) {
+		return nil, nil
+	} else if m, ok := value.(map[string]string); ok {
+		return m, nil
+	} else if m, ok := value.(map[string]interface{}); ok {
+		stringMap := make(map[string]string, len(m))
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				stringMap[k] = s
+			} else if strict {
+				return nil, fmt.Errorf("invalid %s.%s: expected string, found %T", key, k, v)
+			} else {
+				stringMap[k] = fmt.Sprint(v)
+			}
+		}
+		return stringMap, nil
+	} else if strict {
+		return nil, fmt.Errorf("invalid %s: expected object, found %T", key, value)
+	}
+	return nil, nil
+}
+
+func stringListParam(params map[string]interface{}, key string, strict bool) ([]string, error) {
+	if value, found := params[key]; !found || (value == nil) {
+		return nil, nil
+	} else if l, ok := value.([]string); ok {
+		return l, nil
+	} else if l, ok := value.([]interface{}); ok {
+		stringList := make([]string, 0, len(l))
+		for _, v := range l {
+			if s, ok := v.(string); ok {
+				stringList = append(stringList, s)
+			} else if strict {
+				return nil, fmt.Errorf("invalid %s: expected list of strings, found %T", key, v)
+			}
+		}
+		return stringList, nil
+	} else if strict {
+		return nil, fmt.Errorf("invalid %s: expected list, found %T", key, value)
+	}
+	return nil, nil
+}
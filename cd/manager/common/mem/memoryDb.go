@@ -0,0 +1,684 @@
+package mem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+var _ manager.Database = &MemoryDb{}
+
+// MemoryDb keeps all records in memory for local runs and tests, so that the CD manager can run without any database.
+// Nothing is persisted across restarts.
+//
+// Records are stored and looked up the same way as with DynamoDB, i.e. each job update is a separate stage record, jobs
+// updated since they were queued have a versioned current state record, and times that DynamoDB stores with second
+// granularity are truncated to the second.
+type MemoryDb struct {
+	mu                sync.RWMutex
+	jobs              []job.JobState
+	currentJobs       map[string]currentStateRecord
+	idempotencyKeys   map[string]idempotencyRecord
+	buildStates       map[manager.DeployComponent]buildState
+	schedules         map[string]manager.JobSchedule
+	pipelines         map[string]manager.Pipeline
+	leases            map[string]manager.Lease
+	cache             manager.Cache
+	cursor            time.Time
+	idempotencyWindow time.Duration
+	fence             manager.Lease
+	paused            bool
+}
+
+// Jobs are timestamped before they're queued, so leave a margin for jobs being queued while the queue is being searched.
+const queueCursorMargin = time.Second
+
+type currentStateRecord struct {
+	jobState []byte // JSON-encoded state of the job
+	version  int64
+}
+
+type idempotencyRecord struct {
+	jobState  []byte // JSON-encoded state of the job when it was queued
+	expiresAt int64
+}
+
+type buildState struct {
+	deployTag string
+	buildTag  string
+}
+
+func NewMemoryDb(cache manager.Cache) manager.Database {
+	idempotencyWindow, err := manager.IdempotencyWindow()
+	if err != nil {
+		log.Fatalf("memorydb: %v", err)
+	}
+	return &MemoryDb{
+		jobs:              make([]job.JobState, 0, 0),
+		currentJobs:       make(map[string]currentStateRecord),
+		idempotencyKeys:   make(map[string]idempotencyRecord),
+		buildStates:       make(map[manager.DeployComponent]buildState),
+		schedules:         make(map[string]manager.JobSchedule),
+		pipelines:         make(map[string]manager.Pipeline),
+		leases:            make(map[string]manager.Lease),
+		cache:             cache,
+		cursor:            time.Unix(0, 0),
+		idempotencyWindow: idempotencyWindow,
+	}
+}
+
+func (db *MemoryDb) InitializeJobs() error {
+	ttlCursor := time.Now().AddDate(0, 0, -manager.DefaultTtlDays)
+	// Load all jobs in an advanced stage of processing (completed, failed, delayed, waiting, started, skipped), so that
+	// we know which jobs have already been dequeued.
+	for _, stage := range []job.JobStage{
+		job.JobStage_Completed,
+		job.JobStage_Failed,
+		job.JobStage_Canceled,
+		job.JobStage_Waiting,
+		job.JobStage_Started,
+		job.JobStage_Dequeued,
+		job.JobStage_Skipped,
+	} {
+		if err := db.iterateByStage(stage, ttlCursor, true, func(jobState job.JobState) bool {
+			db.cache.WriteJob(jobState)
+			return true
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueJob writes a job that isn't in the cache yet, honoring idempotency keys the same way as the DynamoDB database.
+func (db *MemoryDb) QueueJob(jobState job.JobState) (job.JobState, error) {
+	if cachedJob, found := db.cache.JobById(jobState.JobId); found {
+		return cachedJob, nil
+	}
+	if idempotencyKey, _ := jobState.Params[job.JobParam_IdempotencyKey].(string); len(idempotencyKey) > 0 {
+		if originalJob, claimed, err := db.claimIdempotencyKey(idempotencyKey, jobState); err != nil {
+			return job.JobState{}, err
+		} else if !claimed {
+			log.Printf("queueJob: found job for idempotency key: %s, %s", idempotencyKey, manager.PrintJob(originalJob))
+			return originalJob, nil
+		} else if err = db.WriteJob(jobState); err != nil {
+			db.mu.Lock()
+			delete(db.idempotencyKeys, idempotencyKey)
+			db.mu.Unlock()
+			return job.JobState{}, err
+		}
+		return jobState, nil
+	}
+	return jobState, db.WriteJob(jobState)
+}
+
+func (db *MemoryDb) claimIdempotencyKey(idempotencyKey string, jobState job.JobState) (job.JobState, bool, error) {
+	now := time.Now()
+	encodedJobState, err := json.Marshal(jobState)
+	if err != nil {
+		return job.JobState{}, false, err
+	}
+	db.mu.Lock()
+	record, found := db.idempotencyKeys[idempotencyKey]
+	if !found || (record.expiresAt < now.Unix()) {
+		db.idempotencyKeys[idempotencyKey] = idempotencyRecord{encodedJobState, now.Add(db.idempotencyWindow).Unix()}
+		db.mu.Unlock()
+		return jobState, true, nil
+	}
+	db.mu.Unlock()
+	// The key was already claimed, so look up the job that claimed it.
+	originalJob := job.JobState{}
+	if err = json.Unmarshal(record.jobState, &originalJob); err != nil {
+		return job.JobState{}, false, err
+	} else if err = manager.DecodeJobLayout(originalJob); err != nil {
+		return job.JobState{}, false, err
+	} else if cachedJob, found := db.cache.JobById(originalJob.JobId); found {
+		return cachedJob, false, nil
+	} else if latestJob, found, err := db.GetJob(originalJob.JobId); err != nil {
+		return job.JobState{}, false, err
+	} else if found {
+		return latestJob, false, nil
+	}
+	return originalJob, false, nil
+}
+
+// QueuedJobs returns jobs in order of their timestamps that have not yet been picked up and are thus not in the cache.
+// The search starts from the first job found during the previous search that wasn't already in processing, just like
+// with the DynamoDB database.
+func (db *MemoryDb) QueuedJobs() []job.JobState {
+	now := time.Now()
+	ttlCursor := now.AddDate(0, 0, -manager.DefaultTtlDays)
+	db.mu.RLock()
+	cursor := db.cursor
+	db.mu.RUnlock()
+	if cursor.Before(ttlCursor) {
+		cursor = ttlCursor
+	}
+	queuedJobs := make([]job.JobState, 0, 0)
+	if err := db.queryJobs(func(jobState job.JobState) bool {
+		return jobState.Stage == job.JobStage_Queued
+	}, cursor, now, true, func(jobState job.JobState) bool {
+		// Skip jobs already in processing
+		if _, found := db.cache.JobById(jobState.JobId); !found {
+			queuedJobs = append(queuedJobs, jobState)
+		}
+		return true
+	}); err != nil {
+		log.Printf("queuedJobs: failed iteration through jobs: %v", err)
+	}
+	jobs := make([]job.JobState, 0, 0)
+	if len(queuedJobs) > 0 {
+		// Skip records replaced by a later version of the same job, including ones that were rescheduled for later.
+		scheduledJobs := make([]job.JobState, 0, 0)
+		if err := db.queryJobs(func(jobState job.JobState) bool {
+			return jobState.Stage == job.JobStage_Scheduled
+		}, cursor, time.Time{}, true, func(jobState job.JobState) bool {
+			scheduledJobs = append(scheduledJobs, jobState)
+			return true
+		}); err != nil {
+			log.Printf("queuedJobs: failed iteration through scheduled jobs: %v", err)
+		}
+		jobs = manager.LatestQueuedJobs(queuedJobs, scheduledJobs, db.GetJob)
+	}
+	db.mu.Lock()
+	if len(jobs) > 0 {
+		db.cursor = jobs[0].Ts
+	} else {
+		db.cursor = now.Add(-queueCursorMargin)
+	}
+	db.mu.Unlock()
+	return jobs
+}
+
+// OrderedJobs returns jobs in order of their timestamps that are in the cache in a certain stage of processing. Jobs in
+// later stages can have been dequeued before the queue cursor, so the whole retention window is searched.
+func (db *MemoryDb) OrderedJobs(jobStage job.JobStage) []job.JobState {
+	jobs := make([]job.JobState, 0, 0)
+	if err := db.iterateByStage(jobStage, time.Now().AddDate(0, 0, -manager.DefaultTtlDays), true, func(jobState job.JobState) bool {
+		// Skip records for earlier versions of jobs updated while waiting to be processed
+		if cachedJob, found := db.cache.JobById(jobState.JobId); found && (cachedJob.Stage == jobStage) && (job.JobVersion(cachedJob) == job.JobVersion(jobState)) {
+			jobs = append(jobs, jobState)
+		}
+		return true
+	}); err != nil {
+		log.Printf("orderedJobs: failed iteration through jobs: %v", err)
+	}
+	return jobs
+}
+
+func (db *MemoryDb) IterateByType(jobType job.JobType, asc bool, iter func(job.JobState) bool) error {
+	return db.queryJobs(func(jobState job.JobState) bool {
+		return jobState.Type == jobType
+	}, time.Now().AddDate(0, 0, -manager.DefaultTtlDays), time.Now(), asc, iter)
+}
+
+// GetJob returns the most recent state of a job, regardless of whether the job is in the cache.
+func (db *MemoryDb) GetJob(jobId string) (job.JobState, bool, error) {
+	if currentJob, found, err := db.getCurrentJob(jobId); err != nil {
+		return job.JobState{}, false, err
+	} else if found {
+		return currentJob, true, nil
+	}
+	var jobState job.JobState
+	found := false
+	if err := db.iterateByJob(jobId, false, func(js job.JobState) bool {
+		if !found || job.IsLaterJobState(js, jobState) {
+			jobState = js
+			found = true
+		}
+		return true
+	}); err != nil {
+		return job.JobState{}, false, err
+	}
+	return jobState, found, nil
+}
+
+// GetJobHistory returns all the stage records for a job in order of their timestamps
+func (db *MemoryDb) GetJobHistory(jobId string) ([]job.JobState, error) {
+	jobs := make([]job.JobState, 0, 0)
+	if err := db.iterateByJob(jobId, true, func(jobState job.JobState) bool {
+		jobs = append(jobs, jobState)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ListJobs returns a page of job records matching the filter, in the same order and with the same cursors as the
+// DynamoDB database.
+func (db *MemoryDb) ListJobs(filter manager.JobFilter) (manager.JobPage, error) {
+	start := filter.Start
+	end := filter.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	var cursorTs time.Time
+	cursorId := ""
+	if len(filter.Cursor) > 0 {
+		var err error
+		if cursorTs, cursorId, err = manager.DecodeJobCursor(filter.Cursor); err != nil {
+			return manager.JobPage{}, err
+		}
+	}
+	jobs := make([]job.JobState, 0, 0)
+	if err := db.queryJobs(filter.Matches, start, end, filter.Asc, func(jobState job.JobState) bool {
+		if (len(cursorId) == 0) || manager.IsAfterCursor(jobState, cursorTs, cursorId, filter.Asc) {
+			jobs = append(jobs, jobState)
+		}
+		// Stop once we have one more job than the page size, which tells us whether there are more results.
+		return len(jobs) <= filter.Limit
+	}); err != nil {
+		return manager.JobPage{}, err
+	}
+	page := manager.JobPage{Jobs: jobs}
+	if len(jobs) > filter.Limit {
+		page.Jobs = jobs[:filter.Limit]
+		page.Cursor = manager.EncodeJobCursor(page.Jobs[filter.Limit-1])
+	}
+	return page, nil
+}
+
+func (db *MemoryDb) iterateByStage(jobStage job.JobStage, cursor time.Time, asc bool, iter func(job.JobState) bool) error {
+	// Only look for jobs up till the current time, so that jobs scheduled in the future stay hidden until they're due.
+	return db.queryJobs(func(jobState job.JobState) bool {
+		return jobState.Stage == jobStage
+	}, cursor, time.Now(), asc, iter)
+}
+
+func (db *MemoryDb) iterateByJob(jobId string, asc bool, iter func(job.JobState) bool) error {
+	return db.queryJobs(func(jobState job.JobState) bool {
+		return jobState.JobId == jobId
+	}, time.Time{}, time.Time{}, asc, iter)
+}
+
+// queryJobs iterates through copies of the matching stage records with timestamps in the (inclusive) time range, in
+// order of their timestamps. A zero end time means there's no upper bound.
+func (db *MemoryDb) queryJobs(match func(job.JobState) bool, start, end time.Time, asc bool, iter func(job.JobState) bool) error {
+	db.mu.RLock()
+	jobs := make([]job.JobState, 0, 0)
+	for _, jobState := range db.jobs {
+		if jobState.Ts.Before(start) || (!end.IsZero() && jobState.Ts.After(end)) || !match(jobState) {
+			continue
+		}
+		jobs = append(jobs, jobState)
+	}
+	db.mu.RUnlock()
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Ts.Equal(jobs[j].Ts) {
+			return (asc && (jobs[i].Id < jobs[j].Id)) || (!asc && (jobs[i].Id > jobs[j].Id))
+		}
+		return asc == jobs[i].Ts.Before(jobs[j].Ts)
+	})
+	for _, jobState := range jobs {
+		if jobCopy, err := copyJob(jobState); err != nil {
+			return err
+		} else if !iter(jobCopy) {
+			break
+		}
+	}
+	return nil
+}
+
+// AdvanceJob records a new state for a job as long as the job hasn't been updated since the state it was based on. The
+// new state gets the next version. If the job was updated concurrently, the update is rejected and the latest state of
+// the job is loaded into the cache.
+func (db *MemoryDb) AdvanceJob(jobState job.JobState) error {
+	params := make(map[string]interface{}, len(jobState.Params)+1)
+	for k, v := range jobState.Params {
+		params[k] = v
+	}
+	version := job.JobVersion(jobState)
+	params[job.JobParam_Version] = version + 1
+	jobState.Params = params
+	if err := db.writeJobVersion(jobState, version); err != nil {
+		if errors.Is(err, manager.Error_JobConflict) {
+			if latestJob, found, getErr := db.getCurrentJob(jobState.JobId); getErr != nil {
+				log.Printf("advanceJob: failed to load latest job state: %v, %s", getErr, manager.PrintJob(jobState))
+			} else if found && (latestJob.Stage != job.JobStage_Scheduled) && (latestJob.Stage != job.JobStage_Queued) {
+				db.cache.WriteJob(latestJob)
+			}
+		}
+		return err
+	}
+	// Jobs updated while still scheduled or queued are kept out of the cache, just like when they're first queued.
+	if (jobState.Stage != job.JobStage_Scheduled) && (jobState.Stage != job.JobStage_Queued) {
+		db.cache.WriteJob(jobState)
+	}
+	return nil
+}
+
+// writeJobVersion writes the job's current state record along with a stage record for the job's history, as long as
+// the version and the fencing lease, if any, are still current.
+func (db *MemoryDb) writeJobVersion(jobState job.JobState, prevVersion int64) error {
+	encodedJobState, err := json.Marshal(jobState)
+	if err != nil {
+		return err
+	}
+	record, err := newJobRecord(jobState)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if current, found := db.currentJobs[jobState.JobId]; (found && (current.version != prevVersion)) || (!found && (prevVersion != 0)) {
+		return fmt.Errorf("%w: version %d is no longer current: %s", manager.Error_JobConflict, prevVersion, jobState.JobId)
+	}
+	if len(db.fence.Name) > 0 {
+		if lease := db.leases[db.fence.Name]; (lease.Owner != db.fence.Owner) || (lease.Token != db.fence.Token) {
+			return fmt.Errorf("%w: lease %s lost by %s (token %d)", manager.Error_NotLeader, db.fence.Name, db.fence.Owner, db.fence.Token)
+		}
+	}
+	db.currentJobs[jobState.JobId] = currentStateRecord{encodedJobState, job.JobVersion(jobState)}
+	db.jobs = append(db.jobs, record)
+	return nil
+}
+
+func (db *MemoryDb) getCurrentJob(jobId string) (job.JobState, bool, error) {
+	db.mu.RLock()
+	record, found := db.currentJobs[jobId]
+	db.mu.RUnlock()
+	if !found {
+		return job.JobState{}, false, nil
+	}
+	jobState := job.JobState{}
+	if err := json.Unmarshal(record.jobState, &jobState); err != nil {
+		return job.JobState{}, false, err
+	} else if err = manager.DecodeJobLayout(jobState); err != nil {
+		return job.JobState{}, false, err
+	}
+	return jobState, true, nil
+}
+
+// WriteJob writes a stage record for a job without checking or updating the job's version, e.g. when the job is queued.
+func (db *MemoryDb) WriteJob(jobState job.JobState) error {
+	if record, err := newJobRecord(jobState); err != nil {
+		return err
+	} else {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		db.jobs = append(db.jobs, record)
+		return nil
+	}
+}
+
+// newJobRecord returns a copy of a job update to be stored as a separate stage record
+func newJobRecord(jobState job.JobState) (job.JobState, error) {
+	record, err := copyJob(jobState)
+	if err != nil {
+		return job.JobState{}, err
+	}
+	// Generate a new UUID for every job update
+	record.Id = uuid.New().String()
+	return record, nil
+}
+
+// copyJob deep copies a job so that stored records can't be modified by callers, and vice versa. Params come back with
+// the same types as when they're read from DynamoDB, i.e. numbers as `float64`, and deployment layouts as `Layout`.
+func copyJob(jobState job.JobState) (job.JobState, error) {
+	jobCopy := job.JobState{}
+	if encodedJobState, err := json.Marshal(jobState); err != nil {
+		return job.JobState{}, err
+	} else if err = json.Unmarshal(encodedJobState, &jobCopy); err != nil {
+		return job.JobState{}, err
+	}
+	jobCopy.Id = jobState.Id
+	jobCopy.Ttl = jobState.Ttl
+	if err := manager.DecodeJobLayout(jobCopy); err != nil {
+		return job.JobState{}, err
+	}
+	return jobCopy, nil
+}
+
+func (db *MemoryDb) UpdateBuildTag(component manager.DeployComponent, buildTag string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	state := db.buildStates[component]
+	state.buildTag = buildTag
+	db.buildStates[component] = state
+	return nil
+}
+
+func (db *MemoryDb) UpdateDeployTag(component manager.DeployComponent, deployTag string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	state := db.buildStates[component]
+	state.deployTag = deployTag
+	db.buildStates[component] = state
+	return nil
+}
+
+func (db *MemoryDb) GetBuildTags() (map[manager.DeployComponent]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	buildTags := make(map[manager.DeployComponent]string, len(db.buildStates))
+	for component, state := range db.buildStates {
+		buildTags[component] = state.buildTag
+	}
+	return buildTags, nil
+}
+
+func (db *MemoryDb) GetDeployTags() (map[manager.DeployComponent]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	deployTags := make(map[manager.DeployComponent]string, len(db.buildStates))
+	for component, state := range db.buildStates {
+		deployTags[component] = state.deployTag
+	}
+	return deployTags, nil
+}
+
+// AddSchedule writes a new schedule, failing if a schedule with the same ID already exists
+func (db *MemoryDb) AddSchedule(schedule manager.JobSchedule) error {
+	if scheduleCopy, err := copySchedule(schedule); err != nil {
+		return err
+	} else {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if _, found := db.schedules[schedule.Id]; found {
+			return manager.Error_ScheduleExists
+		}
+		db.schedules[schedule.Id] = scheduleCopy
+		return nil
+	}
+}
+
+func (db *MemoryDb) GetSchedule(id string) (manager.JobSchedule, bool, error) {
+	db.mu.RLock()
+	schedule, found := db.schedules[id]
+	db.mu.RUnlock()
+	if !found {
+		return manager.JobSchedule{}, false, nil
+	} else if scheduleCopy, err := copySchedule(schedule); err != nil {
+		return manager.JobSchedule{}, false, err
+	} else {
+		return scheduleCopy, true, nil
+	}
+}
+
+// GetSchedules returns all schedules, enabled or not, in order of their IDs
+func (db *MemoryDb) GetSchedules() ([]manager.JobSchedule, error) {
+	db.mu.RLock()
+	schedules := make([]manager.JobSchedule, 0, len(db.schedules))
+	for _, schedule := range db.schedules {
+		schedules = append(schedules, schedule)
+	}
+	db.mu.RUnlock()
+	for idx := range schedules {
+		if scheduleCopy, err := copySchedule(schedules[idx]); err != nil {
+			return nil, err
+		} else {
+			schedules[idx] = scheduleCopy
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Id < schedules[j].Id
+	})
+	return schedules, nil
+}
+
+// UpdateSchedule replaces a schedule as long as its next run is still at the specified time
+func (db *MemoryDb) UpdateSchedule(schedule manager.JobSchedule, nextRun time.Time) (bool, error) {
+	if scheduleCopy, err := copySchedule(schedule); err != nil {
+		return false, err
+	} else {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if currentSchedule, found := db.schedules[schedule.Id]; !found || (currentSchedule.NextRun.Unix() != nextRun.Unix()) {
+			return false, nil
+		}
+		db.schedules[schedule.Id] = scheduleCopy
+		return true, nil
+	}
+}
+
+// copySchedule deep copies a schedule, with run times truncated to the second as when stored in DynamoDB
+func copySchedule(schedule manager.JobSchedule) (manager.JobSchedule, error) {
+	scheduleCopy := manager.JobSchedule{}
+	if encodedSchedule, err := json.Marshal(schedule); err != nil {
+		return manager.JobSchedule{}, err
+	} else if err = json.Unmarshal(encodedSchedule, &scheduleCopy); err != nil {
+		return manager.JobSchedule{}, err
+	}
+	scheduleCopy.NextRun = time.Unix(schedule.NextRun.Unix(), 0)
+	scheduleCopy.LastRun = time.Unix(schedule.LastRun.Unix(), 0)
+	return scheduleCopy, nil
+}
+
+func (db *MemoryDb) WritePipeline(pipeline manager.Pipeline) error {
+	if pipelineCopy, err := copyPipeline(pipeline); err != nil {
+		return err
+	} else {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		db.pipelines[pipeline.Id] = pipelineCopy
+		return nil
+	}
+}
+
+func (db *MemoryDb) GetPipeline(id string) (manager.Pipeline, bool, error) {
+	db.mu.RLock()
+	pipeline, found := db.pipelines[id]
+	db.mu.RUnlock()
+	if !found {
+		return manager.Pipeline{}, false, nil
+	} else if pipelineCopy, err := copyPipeline(pipeline); err != nil {
+		return manager.Pipeline{}, false, err
+	} else {
+		return pipelineCopy, true, nil
+	}
+}
+
+// ActivePipelines returns all running pipelines in order of when they were submitted
+func (db *MemoryDb) ActivePipelines() ([]manager.Pipeline, error) {
+	db.mu.RLock()
+	pipelines := make([]manager.Pipeline, 0, 0)
+	for _, pipeline := range db.pipelines {
+		if pipeline.Status == manager.PipelineStatus_Running {
+			pipelines = append(pipelines, pipeline)
+		}
+	}
+	db.mu.RUnlock()
+	for idx := range pipelines {
+		if pipelineCopy, err := copyPipeline(pipelines[idx]); err != nil {
+			return nil, err
+		} else {
+			pipelines[idx] = pipelineCopy
+		}
+	}
+	sort.Slice(pipelines, func(i, j int) bool {
+		return pipelines[i].Ts.Before(pipelines[j].Ts)
+	})
+	return pipelines, nil
+}
+
+// copyPipeline deep copies a pipeline, with its submission time truncated to the second as when stored in DynamoDB
+func copyPipeline(pipeline manager.Pipeline) (manager.Pipeline, error) {
+	pipelineCopy := manager.Pipeline{}
+	if encodedPipeline, err := json.Marshal(pipeline); err != nil {
+		return manager.Pipeline{}, err
+	} else if err = json.Unmarshal(encodedPipeline, &pipelineCopy); err != nil {
+		return manager.Pipeline{}, err
+	}
+	pipelineCopy.Ts = time.Unix(pipeline.Ts.Unix(), 0)
+	return pipelineCopy, nil
+}
+
+// AcquireLease acquires a lease that is free or has expired, or renews a lease already held by the same owner. If the
+// lease is held by someone else, the current lease is returned instead.
+func (db *MemoryDb) AcquireLease(name, owner, address string, ttl time.Duration) (manager.Lease, bool, error) {
+	now := time.Now()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Leases are stored with second granularity, as with DynamoDB
+	lease := manager.Lease{Name: name, Owner: owner, Address: address, Token: 1, Expires: now.Add(ttl).Truncate(time.Second)}
+	if currentLease, found := db.leases[name]; found {
+		if (currentLease.Owner != owner) && currentLease.Expires.After(now) {
+			return currentLease, false, nil
+		}
+		lease.Token = currentLease.Token
+		// Increment the token when taking over someone else's lease
+		if currentLease.Owner != owner {
+			lease.Token++
+		}
+	}
+	db.leases[name] = lease
+	return lease, true, nil
+}
+
+// ReleaseLease gives up a lease so that someone else can acquire it right away, unless it has already changed owners.
+// The lease is expired rather than deleted so that its token keeps on increasing.
+func (db *MemoryDb) ReleaseLease(lease manager.Lease) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if currentLease, found := db.leases[lease.Name]; found && (currentLease.Owner == lease.Owner) && (currentLease.Token == lease.Token) {
+		currentLease.Expires = time.Unix(0, 0)
+		db.leases[lease.Name] = currentLease
+	}
+	return nil
+}
+
+// Fence makes job updates conditional on the lease still being held by the same owner. An empty lease disables fencing.
+func (db *MemoryDb) Fence(lease manager.Lease) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.fence = lease
+}
+
+// IsPaused returns whether job processing has been paused
+func (db *MemoryDb) IsPaused() (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.paused, nil
+}
+
+// SetPaused pauses or unpauses job processing
+func (db *MemoryDb) SetPaused(paused bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.paused = paused
+	return nil
+}
@@ -0,0 +1,14 @@
+package mem
+
+import (
+	"testing"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/dbtest"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(cache manager.Cache) manager.Database {
+		return NewMemoryDb(cache)
+	})
+}
@@ -0,0 +1,138 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Arbitrary key for the Postgres advisory lock held while migrating, so that replicas starting up at the same time
+// don't apply the same migration concurrently.
+const migrationLockKey = 0x63646d67
+
+const migrationTimeout = 5 * time.Minute
+
+// Schema migrations, applied in order. Each migration is applied in its own transaction along with a record of its
+// version, and migrations are never changed once released, only added.
+//
+// Timestamps are stored as nanoseconds since the epoch, except for schedule run times and lease expirations, which are
+// stored in seconds.
+var migrations = [][]string{
+	{
+		// Stage records, one for each job update, with indices for looking up jobs by stage, type, or job ID, in order
+		// of their timestamps.
+		`CREATE TABLE IF NOT EXISTS job_records (
+			id TEXT PRIMARY KEY,
+			job_id TEXT NOT NULL,
+			stage TEXT NOT NULL,
+			type TEXT NOT NULL,
+			ts BIGINT NOT NULL,
+			params TEXT NOT NULL,
+			ttl BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS job_records_stage_ts ON job_records (stage, ts)`,
+		`CREATE INDEX IF NOT EXISTS job_records_type_ts ON job_records (type, ts)`,
+		`CREATE INDEX IF NOT EXISTS job_records_job_ts ON job_records (job_id, ts)`,
+		`CREATE INDEX IF NOT EXISTS job_records_ttl ON job_records (ttl)`,
+		// Current state of each job that has been updated since it was queued, along with the job's version
+		`CREATE TABLE IF NOT EXISTS current_jobs (
+			job_id TEXT PRIMARY KEY,
+			job_state TEXT NOT NULL,
+			version BIGINT NOT NULL,
+			ttl BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			idempotency_key TEXT PRIMARY KEY,
+			job_state TEXT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS build_tags (
+			component TEXT PRIMARY KEY,
+			build_tag TEXT NOT NULL DEFAULT '',
+			deploy_tag TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE IF NOT EXISTS schedules (
+			id TEXT PRIMARY KEY,
+			next_run BIGINT NOT NULL,
+			schedule TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS pipelines (
+			id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			ts BIGINT NOT NULL,
+			pipeline TEXT NOT NULL,
+			ttl BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS pipelines_status_ts ON pipelines (status, ts)`,
+		`CREATE TABLE IF NOT EXISTS leases (
+			name TEXT PRIMARY KEY,
+			owner TEXT NOT NULL,
+			token BIGINT NOT NULL,
+			expires BIGINT NOT NULL
+		)`,
+	},
+	{
+		// Settings shared by all replicas, e.g. whether job processing is paused
+		`CREATE TABLE IF NOT EXISTS flags (
+			name TEXT PRIMARY KEY,
+			value BOOLEAN NOT NULL
+		)`,
+	},
+	{
+		// Address at which the owner of a lease can be reached, e.g. for forwarding API requests to the leader
+		`ALTER TABLE leases ADD COLUMN address TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// migrate brings the schema up to date by applying the migrations that haven't been applied yet
+func (db *SqlDb) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	for idx, statements := range migrations {
+		version := idx + 1
+		if applied, err := db.applyMigration(ctx, version, statements); err != nil {
+			return fmt.Errorf("migrate: failed to apply migration %d: %v", version, err)
+		} else if applied {
+			log.Printf("migrate: applied migration: %d", version)
+		}
+	}
+	return nil
+}
+
+func (db *SqlDb) applyMigration(ctx context.Context, version int, statements []string) (bool, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if db.driver == Driver_Postgres {
+		if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+			return false, err
+		}
+	}
+	var appliedAt int64
+	if err = db.queryRow(ctx, tx, `SELECT applied_at FROM schema_migrations WHERE version = ?`, version).Scan(&appliedAt); err == nil {
+		return false, nil
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+	if _, err = db.exec(ctx, tx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().Unix()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,972 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/google/uuid"
+
+	_ "github.com/lib/pq"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+var _ manager.Database = &SqlDb{}
+
+type Driver string
+
+const (
+	Driver_Postgres Driver = "postgres"
+	// The SQLite driver requires cgo, and so is only included in builds with the "sqlite" tag (see sqlite.go)
+	Driver_Sqlite Driver = "sqlite3"
+)
+
+// SqlDb stores jobs in a PostgreSQL or SQLite database. Records are laid out the same way as with DynamoDB, i.e. each
+// job update is a separate stage record, and jobs updated since they were queued have a versioned current state record.
+//
+// With Postgres, jobs are claimed before they're updated by locking their waiting records with `SELECT ... FOR UPDATE
+// SKIP LOCKED` in the same transaction that writes the job's next version. A replica that finds a job already claimed by
+// another replica, e.g. because both found it in the queue, gives up right away instead of waiting for the other
+// transaction to finish. SQLite only allows one writer at a time, and so doesn't need row locks.
+type SqlDb struct {
+	db                *sql.DB
+	driver            Driver
+	cache             manager.Cache
+	idempotencyWindow time.Duration
+	mu                sync.Mutex
+	cursor            time.Time
+	fence             manager.Lease
+	pruned            time.Time
+}
+
+const defaultJobStateTtl = 2 * 7 * 24 * time.Hour // Two weeks
+
+// Expired records are deleted at most this often
+const pruneInterval = time.Hour
+
+// Jobs are timestamped before they're queued, so leave a margin for jobs being queued while the queue is being searched.
+const queueCursorMargin = time.Second
+
+const jobRecordColumns = "id, job_id, stage, type, ts, params"
+
+// Name of the flag that records whether job processing is paused
+const pausedFlag = "paused"
+
+// querier is implemented by both `sql.DB` and `sql.Tx`
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func NewSqlDb(driver Driver, dataSource string, cache manager.Cache) manager.Database {
+	if !slices.Contains(sql.Drivers(), string(driver)) {
+		log.Fatalf("sqldb: driver not included in this build: %s", driver)
+	}
+	sqlDb, err := sql.Open(string(driver), dataSource)
+	if err != nil {
+		log.Fatalf("sqldb: failed to open database: %v", err)
+	}
+	if driver == Driver_Sqlite {
+		// SQLite only allows one writer at a time, so avoid "database is locked" errors by using a single connection.
+		sqlDb.SetMaxOpenConns(1)
+	}
+	idempotencyWindow, err := manager.IdempotencyWindow()
+	if err != nil {
+		log.Fatalf("sqldb: %v", err)
+	}
+	db := &SqlDb{
+		db:                sqlDb,
+		driver:            driver,
+		cache:             cache,
+		idempotencyWindow: idempotencyWindow,
+		cursor:            time.Unix(0, 0),
+	}
+	if err = db.migrate(); err != nil {
+		log.Fatalf("sqldb: schema migration failed: %v", err)
+	}
+	return db
+}
+
+func (db *SqlDb) InitializeJobs() error {
+	db.pruneExpired()
+	ttlCursor := time.Now().AddDate(0, 0, -manager.DefaultTtlDays)
+	// Load all jobs in an advanced stage of processing (completed, failed, delayed, waiting, started, skipped), so that
+	// we know which jobs have already been dequeued.
+	for _, stage := range []job.JobStage{
+		job.JobStage_Completed,
+		job.JobStage_Failed,
+		job.JobStage_Canceled,
+		job.JobStage_Waiting,
+		job.JobStage_Started,
+		job.JobStage_Dequeued,
+		job.JobStage_Skipped,
+	} {
+		if err := db.iterateByStage(stage, ttlCursor, true, func(jobState job.JobState) bool {
+			db.cache.WriteJob(jobState)
+			return true
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueJob writes a job that isn't in the cache yet, honoring idempotency keys the same way as the DynamoDB database.
+func (db *SqlDb) QueueJob(jobState job.JobState) (job.JobState, error) {
+	if cachedJob, found := db.cache.JobById(jobState.JobId); found {
+		return cachedJob, nil
+	}
+	if idempotencyKey, _ := jobState.Params[job.JobParam_IdempotencyKey].(string); len(idempotencyKey) > 0 {
+		if originalJob, claimed, err := db.claimIdempotencyKey(idempotencyKey, jobState); err != nil {
+			return job.JobState{}, err
+		} else if !claimed {
+			log.Printf("queueJob: found job for idempotency key: %s, %s", idempotencyKey, manager.PrintJob(originalJob))
+			return originalJob, nil
+		} else if err = db.WriteJob(jobState); err != nil {
+			// Release the key so that the caller can retry
+			db.releaseIdempotencyKey(idempotencyKey)
+			return job.JobState{}, err
+		}
+		return jobState, nil
+	}
+	return jobState, db.WriteJob(jobState)
+}
+
+// claimIdempotencyKey attempts to record the job as the one queued with the idempotency key. If another job was already
+// queued with the key within the idempotency window, the latest state of that job is returned.
+func (db *SqlDb) claimIdempotencyKey(idempotencyKey string, jobState job.JobState) (job.JobState, bool, error) {
+	now := time.Now()
+	encodedJobState, err := json.Marshal(jobState)
+	if err != nil {
+		return job.JobState{}, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	// Expired keys can be claimed again
+	if result, err := db.exec(ctx, db.db,
+		`INSERT INTO idempotency_keys (idempotency_key, job_state, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (idempotency_key) DO UPDATE SET job_state = excluded.job_state, expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at < ?`,
+		idempotencyKey, string(encodedJobState), now.Add(db.idempotencyWindow).Unix(), now.Unix(),
+	); err != nil {
+		return job.JobState{}, false, err
+	} else if numRows, err := result.RowsAffected(); err != nil {
+		return job.JobState{}, false, err
+	} else if numRows > 0 {
+		return jobState, true, nil
+	}
+	// The key was already claimed, so look up the job that claimed it.
+	var encodedOriginalJob string
+	originalJob := job.JobState{}
+	if err = db.queryRow(ctx, db.db, `SELECT job_state FROM idempotency_keys WHERE idempotency_key = ?`, idempotencyKey).Scan(&encodedOriginalJob); err != nil {
+		return job.JobState{}, false, err
+	} else if err = json.Unmarshal([]byte(encodedOriginalJob), &originalJob); err != nil {
+		return job.JobState{}, false, err
+	} else if err = manager.DecodeJobLayout(originalJob); err != nil {
+		return job.JobState{}, false, err
+	} else if cachedJob, found := db.cache.JobById(originalJob.JobId); found {
+		return cachedJob, false, nil
+	} else if latestJob, found, err := db.GetJob(originalJob.JobId); err != nil {
+		return job.JobState{}, false, err
+	} else if found {
+		return latestJob, false, nil
+	}
+	return originalJob, false, nil
+}
+
+func (db *SqlDb) releaseIdempotencyKey(idempotencyKey string) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	if _, err := db.exec(ctx, db.db, `DELETE FROM idempotency_keys WHERE idempotency_key = ?`, idempotencyKey); err != nil {
+		log.Printf("releaseIdempotencyKey: failed to release key: %s, %v", idempotencyKey, err)
+	}
+}
+
+// QueuedJobs returns jobs in order of their timestamps that have not yet been picked up and are thus not in the cache.
+// The search starts from the first job found during the previous search that wasn't already in processing, just like
+// with the DynamoDB database.
+func (db *SqlDb) QueuedJobs() []job.JobState {
+	db.pruneExpired()
+	now := time.Now()
+	ttlCursor := now.AddDate(0, 0, -manager.DefaultTtlDays)
+	db.mu.Lock()
+	cursor := db.cursor
+	db.mu.Unlock()
+	if cursor.Before(ttlCursor) {
+		cursor = ttlCursor
+	}
+	jobs, err := db.queuedJobs(cursor, now)
+	if err != nil {
+		log.Printf("queuedJobs: failed iteration through jobs: %v", err)
+	}
+	db.mu.Lock()
+	if len(jobs) > 0 {
+		db.cursor = jobs[0].Ts
+	} else if err == nil {
+		db.cursor = now.Add(-queueCursorMargin)
+	}
+	db.mu.Unlock()
+	return jobs
+}
+
+func (db *SqlDb) queuedJobs(cursor, now time.Time) ([]job.JobState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	records, err := db.queryJobs(ctx, db.db,
+		`SELECT `+jobRecordColumns+` FROM job_records WHERE stage = ? AND ts BETWEEN ? AND ? ORDER BY ts ASC, id ASC`,
+		job.JobStage_Queued, cursor.UnixNano(), now.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Skip jobs already in processing
+	queuedJobs := make([]job.JobState, 0, 0)
+	for _, jobState := range records {
+		if _, found := db.cache.JobById(jobState.JobId); !found {
+			queuedJobs = append(queuedJobs, jobState)
+		}
+	}
+	if len(queuedJobs) == 0 {
+		return queuedJobs, nil
+	}
+	// Skip records replaced by a later version of the same job, including ones that were rescheduled for later.
+	scheduledJobs, err := db.queryJobs(ctx, db.db,
+		`SELECT `+jobRecordColumns+` FROM job_records WHERE stage = ? AND ts >= ? ORDER BY ts ASC, id ASC`,
+		job.JobStage_Scheduled, cursor.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return manager.LatestQueuedJobs(queuedJobs, scheduledJobs, func(jobId string) (job.JobState, bool, error) {
+		return db.getJob(ctx, db.db, jobId)
+	}), nil
+}
+
+// OrderedJobs returns jobs in order of their timestamps that are in the cache in a certain stage of processing. Jobs in
+// later stages can have been dequeued before the queue cursor, so the whole retention window is searched.
+func (db *SqlDb) OrderedJobs(jobStage job.JobStage) []job.JobState {
+	jobs := make([]job.JobState, 0, 0)
+	if err := db.iterateByStage(jobStage, time.Now().AddDate(0, 0, -manager.DefaultTtlDays), true, func(jobState job.JobState) bool {
+		// Skip records for earlier versions of jobs updated while waiting to be processed
+		if cachedJob, found := db.cache.JobById(jobState.JobId); found && (cachedJob.Stage == jobStage) && (job.JobVersion(cachedJob) == job.JobVersion(jobState)) {
+			jobs = append(jobs, jobState)
+		}
+		return true
+	}); err != nil {
+		log.Printf("orderedJobs: failed iteration through jobs: %v", err)
+	}
+	return jobs
+}
+
+func (db *SqlDb) IterateByType(jobType job.JobType, asc bool, iter func(job.JobState) bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	// Only look for jobs up till the current time, so that jobs scheduled in the future stay hidden until they're due.
+	jobs, err := db.queryJobs(ctx, db.db,
+		`SELECT `+jobRecordColumns+` FROM job_records WHERE type = ? AND ts BETWEEN ? AND ? ORDER BY `+orderBy(asc),
+		jobType, time.Now().AddDate(0, 0, -manager.DefaultTtlDays).UnixNano(), time.Now().UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	for _, jobState := range jobs {
+		if !iter(jobState) {
+			break
+		}
+	}
+	return nil
+}
+
+// GetJob returns the most recent state of a job, regardless of whether the job is in the cache.
+func (db *SqlDb) GetJob(jobId string) (job.JobState, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	return db.getJob(ctx, db.db, jobId)
+}
+
+func (db *SqlDb) getJob(ctx context.Context, q querier, jobId string) (job.JobState, bool, error) {
+	// Use the job's current state record if the job has been updated since it was queued
+	if currentJob, found, err := db.getCurrentJob(ctx, q, jobId); err != nil {
+		return job.JobState{}, false, err
+	} else if found {
+		return currentJob, true, nil
+	}
+	jobs, err := db.queryJobs(ctx, q, `SELECT `+jobRecordColumns+` FROM job_records WHERE job_id = ? ORDER BY ts DESC, id DESC`, jobId)
+	if err != nil {
+		return job.JobState{}, false, err
+	}
+	// Jobs updated while waiting to be processed can have records with timestamps later than their latest state, so we
+	// need to look at all the records for the job.
+	var jobState job.JobState
+	for idx, js := range jobs {
+		if (idx == 0) || job.IsLaterJobState(js, jobState) {
+			jobState = js
+		}
+	}
+	return jobState, len(jobs) > 0, nil
+}
+
+// GetJobHistory returns all the stage records for a job in order of their timestamps
+func (db *SqlDb) GetJobHistory(jobId string) ([]job.JobState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	return db.queryJobs(ctx, db.db, `SELECT `+jobRecordColumns+` FROM job_records WHERE job_id = ? ORDER BY ts ASC, id ASC`, jobId)
+}
+
+// ListJobs returns a page of job records matching the filter, in the same order and with the same cursors as the
+// DynamoDB database. The stage, type, and time range are applied in the query, and the remaining criteria to the
+// records found.
+func (db *SqlDb) ListJobs(filter manager.JobFilter) (manager.JobPage, error) {
+	end := filter.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	conditions := []string{"ts BETWEEN ? AND ?"}
+	args := []interface{}{filter.Start.UnixNano(), end.UnixNano()}
+	if filter.Start.IsZero() {
+		args[0] = int64(0)
+	}
+	if len(filter.Cursor) > 0 {
+		if cursorTs, cursorId, err := manager.DecodeJobCursor(filter.Cursor); err != nil {
+			return manager.JobPage{}, err
+		} else if filter.Asc {
+			conditions = append(conditions, "(ts > ? OR (ts = ? AND id > ?))")
+			args = append(args, cursorTs.UnixNano(), cursorTs.UnixNano(), cursorId)
+		} else {
+			conditions = append(conditions, "(ts < ? OR (ts = ? AND id < ?))")
+			args = append(args, cursorTs.UnixNano(), cursorTs.UnixNano(), cursorId)
+		}
+	}
+	if len(filter.Stage) > 0 {
+		conditions = append(conditions, "stage = ?")
+		args = append(args, filter.Stage)
+	}
+	if len(filter.Type) > 0 {
+		conditions = append(conditions, "type = ?")
+		args = append(args, filter.Type)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	records, err := db.queryJobs(ctx, db.db,
+		`SELECT `+jobRecordColumns+` FROM job_records WHERE `+strings.Join(conditions, " AND ")+` ORDER BY `+orderBy(filter.Asc),
+		args...,
+	)
+	if err != nil {
+		return manager.JobPage{}, err
+	}
+	jobs := make([]job.JobState, 0, 0)
+	for _, jobState := range records {
+		if filter.Matches(jobState) {
+			jobs = append(jobs, jobState)
+			// One more job than the page size tells us whether there are more results
+			if len(jobs) > filter.Limit {
+				break
+			}
+		}
+	}
+	page := manager.JobPage{Jobs: jobs}
+	if len(jobs) > filter.Limit {
+		page.Jobs = jobs[:filter.Limit]
+		page.Cursor = manager.EncodeJobCursor(page.Jobs[filter.Limit-1])
+	}
+	return page, nil
+}
+
+func (db *SqlDb) iterateByStage(jobStage job.JobStage, cursor time.Time, asc bool, iter func(job.JobState) bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	// Only look for jobs up till the current time, so that jobs scheduled in the future stay hidden until they're due.
+	jobs, err := db.queryJobs(ctx, db.db,
+		`SELECT `+jobRecordColumns+` FROM job_records WHERE stage = ? AND ts BETWEEN ? AND ? ORDER BY `+orderBy(asc),
+		jobStage, cursor.UnixNano(), time.Now().UnixNano(),
+	)
+	if err != nil {
+		return err
+	}
+	for _, jobState := range jobs {
+		if !iter(jobState) {
+			break
+		}
+	}
+	return nil
+}
+
+// queryJobs reads all the stage records returned by a query before returning them, so that callers can make other
+// queries while going through the results, even with a single connection.
+func (db *SqlDb) queryJobs(ctx context.Context, q querier, query string, args ...interface{}) ([]job.JobState, error) {
+	rows, err := q.QueryContext(ctx, db.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]job.JobState, 0, 0)
+	for rows.Next() {
+		var ts int64
+		var params string
+		jobState := job.JobState{}
+		if err = rows.Scan(&jobState.Id, &jobState.JobId, &jobState.Stage, &jobState.Type, &ts, &params); err != nil {
+			return nil, err
+		} else if err = json.Unmarshal([]byte(params), &jobState.Params); err != nil {
+			return nil, err
+		}
+		jobState.Ts = time.Unix(0, ts)
+		if err = manager.DecodeJobLayout(jobState); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, jobState)
+	}
+	return jobs, rows.Err()
+}
+
+// AdvanceJob records a new state for a job as long as the job hasn't been updated since the state it was based on. The
+// new state gets the next version. If the job was updated concurrently, the update is rejected and the latest state of
+// the job is loaded into the cache.
+func (db *SqlDb) AdvanceJob(jobState job.JobState) error {
+	// Copy the params so that the caller's state isn't modified if the update is rejected
+	params := make(map[string]interface{}, len(jobState.Params)+1)
+	for k, v := range jobState.Params {
+		params[k] = v
+	}
+	version := job.JobVersion(jobState)
+	params[job.JobParam_Version] = version + 1
+	jobState.Params = params
+	if err := db.writeJobVersion(jobState, version); err != nil {
+		if errors.Is(err, manager.Error_JobConflict) {
+			ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+			defer cancel()
+
+			if latestJob, found, getErr := db.getCurrentJob(ctx, db.db, jobState.JobId); getErr != nil {
+				log.Printf("advanceJob: failed to load latest job state: %v, %s", getErr, manager.PrintJob(jobState))
+			} else if found && (latestJob.Stage != job.JobStage_Scheduled) && (latestJob.Stage != job.JobStage_Queued) {
+				db.cache.WriteJob(latestJob)
+			}
+		}
+		return err
+	}
+	// Jobs updated while still scheduled or queued are kept out of the cache, just like when they're first queued.
+	if (jobState.Stage != job.JobStage_Scheduled) && (jobState.Stage != job.JobStage_Queued) {
+		db.cache.WriteJob(jobState)
+	}
+	return nil
+}
+
+// writeJobVersion writes the job's current state record along with a stage record for the job's history in a single
+// transaction, as long as the version and the fencing lease, if any, are still current.
+func (db *SqlDb) writeJobVersion(jobState job.JobState, prevVersion int64) error {
+	encodedJobState, err := json.Marshal(jobState)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	lease := db.fence
+	db.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if db.driver == Driver_Postgres {
+		if claimed, err := db.claimJob(ctx, tx, jobState.JobId); err != nil {
+			return err
+		} else if !claimed {
+			return fmt.Errorf("%w: job is being updated by another replica: %s", manager.Error_JobConflict, jobState.JobId)
+		}
+	}
+	if len(lease.Name) > 0 {
+		var owner string
+		var token int64
+		if err = db.queryRow(ctx, tx, `SELECT owner, token FROM leases WHERE name = ?`+db.forShare(), lease.Name).Scan(&owner, &token); (err != nil) && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		} else if (owner != lease.Owner) || (token != lease.Token) {
+			return fmt.Errorf("%w: lease %s lost by %s (token %d)", manager.Error_NotLeader, lease.Name, lease.Owner, lease.Token)
+		}
+	}
+	ttl := time.Now().Add(defaultJobStateTtl).UnixNano()
+	var result sql.Result
+	// Jobs that haven't been updated since they were queued don't have a current state record yet
+	if prevVersion == 0 {
+		result, err = db.exec(ctx, tx,
+			`INSERT INTO current_jobs (job_id, job_state, version, ttl) VALUES (?, ?, ?, ?) ON CONFLICT (job_id) DO NOTHING`,
+			jobState.JobId, string(encodedJobState), job.JobVersion(jobState), ttl,
+		)
+	} else {
+		result, err = db.exec(ctx, tx,
+			`UPDATE current_jobs SET job_state = ?, version = ?, ttl = ? WHERE job_id = ? AND version = ?`,
+			string(encodedJobState), job.JobVersion(jobState), ttl, jobState.JobId, prevVersion,
+		)
+	}
+	if err != nil {
+		return err
+	} else if numRows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if numRows == 0 {
+		return fmt.Errorf("%w: version %d is no longer current: %s", manager.Error_JobConflict, prevVersion, jobState.JobId)
+	}
+	if err = db.insertJob(ctx, tx, jobState); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// claimJob locks the job's waiting records until the transaction ends, skipping records already locked by another
+// transaction. The job is claimed unless all of its waiting records are locked.
+func (db *SqlDb) claimJob(ctx context.Context, tx *sql.Tx, jobId string) (bool, error) {
+	rows, err := tx.QueryContext(ctx, db.rebind(
+		`SELECT id FROM job_records WHERE job_id = ? AND stage IN (?, ?) FOR UPDATE SKIP LOCKED`),
+		jobId, job.JobStage_Scheduled, job.JobStage_Queued,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	numLocked := 0
+	for rows.Next() {
+		numLocked++
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	} else if numLocked > 0 {
+		return true, nil
+	}
+	// Jobs whose waiting records have expired can't be contended
+	var exists bool
+	if err = db.queryRow(ctx, tx,
+		`SELECT EXISTS (SELECT 1 FROM job_records WHERE job_id = ? AND stage IN (?, ?))`,
+		jobId, job.JobStage_Scheduled, job.JobStage_Queued,
+	).Scan(&exists); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+func (db *SqlDb) getCurrentJob(ctx context.Context, q querier, jobId string) (job.JobState, bool, error) {
+	var encodedJobState string
+	jobState := job.JobState{}
+	if err := db.queryRow(ctx, q, `SELECT job_state FROM current_jobs WHERE job_id = ?`, jobId).Scan(&encodedJobState); errors.Is(err, sql.ErrNoRows) {
+		return job.JobState{}, false, nil
+	} else if err != nil {
+		return job.JobState{}, false, err
+	} else if err = json.Unmarshal([]byte(encodedJobState), &jobState); err != nil {
+		return job.JobState{}, false, err
+	} else if err = manager.DecodeJobLayout(jobState); err != nil {
+		return job.JobState{}, false, err
+	}
+	return jobState, true, nil
+}
+
+// WriteJob writes a stage record for a job without checking or updating the job's version, e.g. when the job is queued.
+func (db *SqlDb) WriteJob(jobState job.JobState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	return db.insertJob(ctx, db.db, jobState)
+}
+
+func (db *SqlDb) insertJob(ctx context.Context, q querier, jobState job.JobState) error {
+	encodedParams, err := json.Marshal(jobState.Params)
+	if err != nil {
+		return err
+	}
+	// Set record expiration, counting from when the job comes due for jobs scheduled in the future.
+	ttlStart := time.Now()
+	if jobState.Ts.After(ttlStart) {
+		ttlStart = jobState.Ts
+	}
+	// Generate a new UUID for every job update
+	_, err = db.exec(ctx, q,
+		`INSERT INTO job_records (id, job_id, stage, type, ts, params, ttl) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), jobState.JobId, jobState.Stage, jobState.Type, jobState.Ts.UnixNano(), string(encodedParams), ttlStart.Add(defaultJobStateTtl).UnixNano(),
+	)
+	return err
+}
+
+func (db *SqlDb) UpdateBuildTag(component manager.DeployComponent, buildTag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err := db.exec(ctx, db.db,
+		`INSERT INTO build_tags (component, build_tag) VALUES (?, ?) ON CONFLICT (component) DO UPDATE SET build_tag = excluded.build_tag`,
+		component, buildTag,
+	)
+	return err
+}
+
+func (db *SqlDb) UpdateDeployTag(component manager.DeployComponent, deployTag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err := db.exec(ctx, db.db,
+		`INSERT INTO build_tags (component, deploy_tag) VALUES (?, ?) ON CONFLICT (component) DO UPDATE SET deploy_tag = excluded.deploy_tag`,
+		component, deployTag,
+	)
+	return err
+}
+
+func (db *SqlDb) GetBuildTags() (map[manager.DeployComponent]string, error) {
+	return db.getTags("build_tag")
+}
+
+func (db *SqlDb) GetDeployTags() (map[manager.DeployComponent]string, error) {
+	return db.getTags("deploy_tag")
+}
+
+func (db *SqlDb) getTags(column string) (map[manager.DeployComponent]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT component, `+column+` FROM build_tags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[manager.DeployComponent]string)
+	for rows.Next() {
+		var component manager.DeployComponent
+		var tag string
+		if err = rows.Scan(&component, &tag); err != nil {
+			return nil, err
+		}
+		tags[component] = tag
+	}
+	return tags, rows.Err()
+}
+
+// AddSchedule writes a new schedule, failing if a schedule with the same ID already exists
+func (db *SqlDb) AddSchedule(schedule manager.JobSchedule) error {
+	if encodedSchedule, err := json.Marshal(schedule); err != nil {
+		return err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		if result, err := db.exec(ctx, db.db,
+			`INSERT INTO schedules (id, next_run, schedule) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+			schedule.Id, schedule.NextRun.Unix(), string(encodedSchedule),
+		); err != nil {
+			return err
+		} else if numRows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if numRows == 0 {
+			return manager.Error_ScheduleExists
+		}
+		return nil
+	}
+}
+
+func (db *SqlDb) GetSchedule(id string) (manager.JobSchedule, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	var encodedSchedule string
+	schedule := manager.JobSchedule{}
+	if err := db.queryRow(ctx, db.db, `SELECT schedule FROM schedules WHERE id = ?`, id).Scan(&encodedSchedule); errors.Is(err, sql.ErrNoRows) {
+		return manager.JobSchedule{}, false, nil
+	} else if err != nil {
+		return manager.JobSchedule{}, false, err
+	} else if err = json.Unmarshal([]byte(encodedSchedule), &schedule); err != nil {
+		return manager.JobSchedule{}, false, err
+	}
+	return schedule, true, nil
+}
+
+// GetSchedules returns all schedules, enabled or not, in order of their IDs
+func (db *SqlDb) GetSchedules() ([]manager.JobSchedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT schedule FROM schedules ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make([]manager.JobSchedule, 0, 0)
+	for rows.Next() {
+		var encodedSchedule string
+		schedule := manager.JobSchedule{}
+		if err = rows.Scan(&encodedSchedule); err != nil {
+			return nil, err
+		} else if err = json.Unmarshal([]byte(encodedSchedule), &schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// UpdateSchedule replaces a schedule as long as its next run is still at the specified time, with second granularity.
+func (db *SqlDb) UpdateSchedule(schedule manager.JobSchedule, nextRun time.Time) (bool, error) {
+	if encodedSchedule, err := json.Marshal(schedule); err != nil {
+		return false, err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		if result, err := db.exec(ctx, db.db,
+			`UPDATE schedules SET next_run = ?, schedule = ? WHERE id = ? AND next_run = ?`,
+			schedule.NextRun.Unix(), string(encodedSchedule), schedule.Id, nextRun.Unix(),
+		); err != nil {
+			return false, err
+		} else if numRows, err := result.RowsAffected(); err != nil {
+			return false, err
+		} else {
+			return numRows > 0, nil
+		}
+	}
+}
+
+func (db *SqlDb) WritePipeline(pipeline manager.Pipeline) error {
+	// Set entry expiration
+	pipeline.Ttl = time.Now().Add(defaultJobStateTtl)
+	if encodedPipeline, err := json.Marshal(pipeline); err != nil {
+		return err
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+		defer cancel()
+
+		_, err = db.exec(ctx, db.db,
+			`INSERT INTO pipelines (id, status, ts, pipeline, ttl) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET status = excluded.status, ts = excluded.ts, pipeline = excluded.pipeline, ttl = excluded.ttl`,
+			pipeline.Id, pipeline.Status, pipeline.Ts.UnixNano(), string(encodedPipeline), pipeline.Ttl.UnixNano(),
+		)
+		return err
+	}
+}
+
+func (db *SqlDb) GetPipeline(id string) (manager.Pipeline, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	var encodedPipeline string
+	pipeline := manager.Pipeline{}
+	if err := db.queryRow(ctx, db.db, `SELECT pipeline FROM pipelines WHERE id = ?`, id).Scan(&encodedPipeline); errors.Is(err, sql.ErrNoRows) {
+		return manager.Pipeline{}, false, nil
+	} else if err != nil {
+		return manager.Pipeline{}, false, err
+	} else if err = json.Unmarshal([]byte(encodedPipeline), &pipeline); err != nil {
+		return manager.Pipeline{}, false, err
+	}
+	return pipeline, true, nil
+}
+
+// ActivePipelines returns all running pipelines in order of when they were submitted
+func (db *SqlDb) ActivePipelines() ([]manager.Pipeline, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, db.rebind(`SELECT pipeline FROM pipelines WHERE status = ? ORDER BY ts ASC, id ASC`), manager.PipelineStatus_Running)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pipelines := make([]manager.Pipeline, 0, 0)
+	for rows.Next() {
+		var encodedPipeline string
+		pipeline := manager.Pipeline{}
+		if err = rows.Scan(&encodedPipeline); err != nil {
+			return nil, err
+		} else if err = json.Unmarshal([]byte(encodedPipeline), &pipeline); err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	return pipelines, rows.Err()
+}
+
+// AcquireLease acquires a lease that is free or has expired, or renews a lease already held by the same owner. If the
+// lease is held by someone else, the current lease is returned instead.
+//
+// Leases are only replaced if they haven't changed since they were looked up, so that at most one of multiple owners
+// racing for the same lease can acquire it.
+func (db *SqlDb) AcquireLease(name, owner, address string, ttl time.Duration) (manager.Lease, bool, error) {
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	currentLease, found, err := db.getLease(ctx, name)
+	if err != nil {
+		return manager.Lease{}, false, err
+	}
+	// Leases are stored with second granularity
+	lease := manager.Lease{Name: name, Owner: owner, Address: address, Token: 1, Expires: now.Add(ttl).Truncate(time.Second)}
+	var result sql.Result
+	if found {
+		if (currentLease.Owner != owner) && currentLease.Expires.After(now) {
+			return currentLease, false, nil
+		}
+		lease.Token = currentLease.Token
+		// Increment the token when taking over someone else's lease
+		if currentLease.Owner != owner {
+			lease.Token++
+		}
+		result, err = db.exec(ctx, db.db,
+			`UPDATE leases SET owner = ?, address = ?, token = ?, expires = ? WHERE name = ? AND owner = ? AND token = ? AND expires = ?`,
+			lease.Owner, lease.Address, lease.Token, lease.Expires.Unix(), name, currentLease.Owner, currentLease.Token, currentLease.Expires.Unix(),
+		)
+	} else {
+		result, err = db.exec(ctx, db.db,
+			`INSERT INTO leases (name, owner, address, token, expires) VALUES (?, ?, ?, ?, ?) ON CONFLICT (name) DO NOTHING`,
+			name, lease.Owner, lease.Address, lease.Token, lease.Expires.Unix(),
+		)
+	}
+	if err != nil {
+		return manager.Lease{}, false, err
+	} else if numRows, err := result.RowsAffected(); err != nil {
+		return manager.Lease{}, false, err
+	} else if numRows > 0 {
+		return lease, true, nil
+	}
+	// Someone else got to the lease first
+	if currentLease, _, err = db.getLease(ctx, name); err != nil {
+		return manager.Lease{}, false, err
+	}
+	return currentLease, false, nil
+}
+
+func (db *SqlDb) getLease(ctx context.Context, name string) (manager.Lease, bool, error) {
+	var expires int64
+	lease := manager.Lease{Name: name}
+	if err := db.queryRow(ctx, db.db, `SELECT owner, address, token, expires FROM leases WHERE name = ?`, name).Scan(&lease.Owner, &lease.Address, &lease.Token, &expires); errors.Is(err, sql.ErrNoRows) {
+		return manager.Lease{}, false, nil
+	} else if err != nil {
+		return manager.Lease{}, false, err
+	}
+	lease.Expires = time.Unix(expires, 0)
+	return lease, true, nil
+}
+
+// ReleaseLease gives up a lease so that someone else can acquire it right away, unless it has already changed owners.
+// The lease is expired rather than deleted so that its token keeps on increasing.
+func (db *SqlDb) ReleaseLease(lease manager.Lease) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err := db.exec(ctx, db.db, `UPDATE leases SET expires = 0 WHERE name = ? AND owner = ? AND token = ?`, lease.Name, lease.Owner, lease.Token)
+	return err
+}
+
+// Fence makes job updates conditional on the lease still being held by the same owner. An empty lease disables fencing.
+func (db *SqlDb) Fence(lease manager.Lease) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.fence = lease
+}
+
+// IsPaused returns whether job processing has been paused
+func (db *SqlDb) IsPaused() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	var paused bool
+	if err := db.queryRow(ctx, db.db, `SELECT value FROM flags WHERE name = ?`, pausedFlag).Scan(&paused); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return paused, nil
+}
+
+// SetPaused pauses or unpauses job processing
+func (db *SqlDb) SetPaused(paused bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	_, err := db.exec(ctx, db.db, `INSERT INTO flags (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value`, pausedFlag, paused)
+	return err
+}
+
+// pruneExpired deletes expired records, which DynamoDB would have deleted through its TTL mechanism
+func (db *SqlDb) pruneExpired() {
+	now := time.Now()
+	db.mu.Lock()
+	if now.Sub(db.pruned) < pruneInterval {
+		db.mu.Unlock()
+		return
+	}
+	db.pruned = now
+	db.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	for _, query := range []string{
+		`DELETE FROM job_records WHERE ttl < ?`,
+		`DELETE FROM current_jobs WHERE ttl < ?`,
+		`DELETE FROM pipelines WHERE ttl < ?`,
+	} {
+		if _, err := db.exec(ctx, db.db, query, now.UnixNano()); err != nil {
+			log.Printf("pruneExpired: failed to delete expired records: %v", err)
+		}
+	}
+	if _, err := db.exec(ctx, db.db, `DELETE FROM idempotency_keys WHERE expires_at < ?`, now.Unix()); err != nil {
+		log.Printf("pruneExpired: failed to delete expired records: %v", err)
+	}
+}
+
+func (db *SqlDb) exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	return q.ExecContext(ctx, db.rebind(query), args...)
+}
+
+func (db *SqlDb) queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRowContext(ctx, db.rebind(query), args...)
+}
+
+// rebind replaces "?" placeholders with the numbered placeholders used by Postgres, i.e. "$1", "$2", etc.
+func (db *SqlDb) rebind(query string) string {
+	if db.driver != Driver_Postgres {
+		return query
+	}
+	var b strings.Builder
+	argNum := 0
+	for _, c := range query {
+		if c == '?' {
+			argNum++
+			b.WriteString("$" + strconv.Itoa(argNum))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (db *SqlDb) forShare() string {
+	if db.driver == Driver_Postgres {
+		return " FOR SHARE"
+	}
+	return ""
+}
+
+func orderBy(asc bool) string {
+	if asc {
+		return "ts ASC, id ASC"
+	}
+	return "ts DESC, id DESC"
+}
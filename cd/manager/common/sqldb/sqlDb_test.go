@@ -0,0 +1,27 @@
+package sqldb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/dbtest"
+)
+
+func TestSqliteConformance(t *testing.T) {
+	// Each in-memory SQLite database lives on its own connection, so every test gets an empty database.
+	dbtest.Run(t, func(cache manager.Cache) manager.Database {
+		return NewSqlDb(Driver_Sqlite, ":memory:", cache)
+	})
+}
+
+// TestPostgresConformance runs against the database at TEST_POSTGRES_URL, if set
+func TestPostgresConformance(t *testing.T) {
+	dataSource := os.Getenv("TEST_POSTGRES_URL")
+	if len(dataSource) == 0 {
+		t.Skip("TEST_POSTGRES_URL not set")
+	}
+	dbtest.Run(t, func(cache manager.Cache) manager.Database {
+		return NewSqlDb(Driver_Postgres, dataSource, cache)
+	})
+}
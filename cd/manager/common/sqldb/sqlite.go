@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package sqldb
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
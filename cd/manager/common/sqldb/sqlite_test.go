@@ -0,0 +1,6 @@
+package sqldb
+
+import (
+	// Tests always run against SQLite, whether or not the driver is included in the build
+	_ "github.com/mattn/go-sqlite3"
+)
@@ -0,0 +1,156 @@
+package jobmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed cron expression, with each field represented as a bitset of the values it matches. Cron
+// expressions are always evaluated in UTC.
+type cronSpec struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	// Whether the day fields were unrestricted, which changes how they're combined (see `matchesDay`)
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // Sunday is either 0 or 7
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedules that don't match any time within this period, e.g. "0 0 30 2 *", are rejected
+const cronSearchLimit = 5 * 365 * 24 * time.Hour
+
+// parseCronSpec parses a standard 5-field cron expression (minute, hour, day of month, month, day of week) supporting
+// "*", lists, ranges, and steps, e.g. "*/15 9-17 * * 1-5", or one of the "@hourly", "@daily", etc. descriptors.
+func parseCronSpec(spec string) (cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if descriptor, found := cronDescriptors[spec]; found {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return cronSpec{}, fmt.Errorf("expected %d fields in cron spec: %s", len(cronFields), spec)
+	}
+	bits := make([]uint64, len(fields))
+	for idx, field := range fields {
+		if fieldBits, err := parseCronField(field, cronFields[idx]); err != nil {
+			return cronSpec{}, err
+		} else {
+			bits[idx] = fieldBits
+		}
+	}
+	// Treat Sunday as 0 so that it can be compared with `time.Weekday`
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	c := cronSpec{
+		bits[0],
+		bits[1],
+		bits[2],
+		bits[3],
+		bits[4],
+		strings.HasPrefix(fields[2], "*"),
+		strings.HasPrefix(fields[4], "*"),
+	}
+	if c.next(time.Now()).IsZero() {
+		return cronSpec{}, fmt.Errorf("cron spec never matches: %s", spec)
+	}
+	return c, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			rangePart = part[:idx]
+			if parsedStep, err := strconv.Atoi(part[idx+1:]); (err != nil) || (parsedStep <= 0) {
+				return 0, fmt.Errorf("invalid %s step: %s", f.name, part)
+			} else {
+				step = parsedStep
+			}
+		}
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s: %s", f.name, part)
+			}
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s: %s", f.name, part)
+				}
+			} else if step == 1 {
+				end = start
+			}
+			// A step without a range, e.g. "5/15", runs from the start value to the end of the field's range
+		}
+		if (start < f.min) || (end > f.max) || (start > end) {
+			return 0, fmt.Errorf("%s out of range: %s", f.name, part)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time strictly after the given time that matches the cron spec, or the zero time if there is
+// no such time within the search limit.
+func (c cronSpec) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if c.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		} else if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		} else if c.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		} else if c.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay returns whether the day of the given time matches the cron spec. Like standard cron, if both the day of
+// month and day of week are restricted, a day matching either of them matches.
+func (c cronSpec) matchesDay(t time.Time) bool {
+	dayOfMonth := c.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.daysOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
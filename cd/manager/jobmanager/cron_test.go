@@ -0,0 +1,172 @@
+package jobmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSpec(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0,30 * * * *", true},
+		{"5/15 * * * *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"0 0 29 2 *", true},
+		// Day of month and day of week are combined with OR when both are restricted, so Mondays in February match
+		{"0 0 30 2 1", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"@reboot", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"*/x * * * *", false},
+		{"a * * * *", false},
+		{"1-b * * * *", false},
+		// Specs that never match
+		{"0 0 30 2 *", false},
+		{"0 0 31 4,6,9,11 *", false},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			if _, err := parseCronSpec(test.spec); test.valid && (err != nil) {
+				t.Fatalf("expected valid spec: %v", err)
+			} else if !test.valid && (err == nil) {
+				t.Fatalf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field  string
+		values []int
+	}{
+		{"5", []int{5}},
+		{"5-8", []int{5, 6, 7, 8}},
+		{"1,3,5", []int{1, 3, 5}},
+		{"*/20", []int{0, 20, 40}},
+		{"10-30/10", []int{10, 20, 30}},
+		// A step without a range runs from the start value to the end of the field's range
+		{"5/15", []int{5, 20, 35, 50}},
+		{"0-5/15,58", []int{0, 58}},
+	}
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			var expectedBits uint64
+			for _, v := range test.values {
+				expectedBits |= 1 << uint(v)
+			}
+			if bits, err := parseCronField(test.field, cronFields[0]); err != nil {
+				t.Fatalf("failed to parse field: %v", err)
+			} else if bits != expectedBits {
+				t.Fatalf("expected bits: %b, found: %b", expectedBits, bits)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-03-01 is a Friday, and 2024-03-13 is a Wednesday
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		{"next minute", "* * * * *", cronTime(2024, 3, 1, 10, 0), cronTime(2024, 3, 1, 10, 1)},
+		{"seconds are ignored", "* * * * *", time.Date(2024, 3, 1, 10, 0, 59, 0, time.UTC), cronTime(2024, 3, 1, 10, 1)},
+		{"step from start value", "5/15 * * * *", cronTime(2024, 3, 1, 10, 0), cronTime(2024, 3, 1, 10, 5)},
+		{"step strictly after", "5/15 * * * *", cronTime(2024, 3, 1, 10, 5), cronTime(2024, 3, 1, 10, 20)},
+		{"step into next hour", "5/15 * * * *", cronTime(2024, 3, 1, 10, 50), cronTime(2024, 3, 1, 11, 5)},
+		{"weekdays skip weekend", "*/15 9-17 * * 1-5", cronTime(2024, 3, 1, 17, 50), cronTime(2024, 3, 4, 9, 0)},
+		{"sunday as 0", "0 12 * * 0", cronTime(2024, 3, 1, 0, 0), cronTime(2024, 3, 3, 12, 0)},
+		{"sunday as 7", "0 12 * * 7", cronTime(2024, 3, 1, 0, 0), cronTime(2024, 3, 3, 12, 0)},
+		{"sunday in range", "0 12 * * 6-7", cronTime(2024, 3, 2, 12, 0), cronTime(2024, 3, 3, 12, 0)},
+		{"weekly", "@weekly", cronTime(2024, 3, 1, 0, 0), cronTime(2024, 3, 3, 0, 0)},
+		{"day of month or day of week", "0 0 13 * 5", cronTime(2024, 3, 1, 0, 0), cronTime(2024, 3, 8, 0, 0)},
+		{"day of month or day of week, by day of month", "0 0 13 * 5", cronTime(2024, 3, 9, 0, 0), cronTime(2024, 3, 13, 0, 0)},
+		{"only day of week", "0 0 * * 5", cronTime(2024, 3, 9, 0, 0), cronTime(2024, 3, 15, 0, 0)},
+		{"only day of month", "0 0 13 * *", cronTime(2024, 3, 14, 0, 0), cronTime(2024, 4, 13, 0, 0)},
+		// A day of month step starting with "*" counts as unrestricted, so it's combined with the day of week with AND
+		{"day of month step and day of week", "0 0 */2 * 5", cronTime(2024, 3, 1, 0, 0), cronTime(2024, 3, 15, 0, 0)},
+		{"next year", "@yearly", cronTime(2024, 12, 31, 23, 59), cronTime(2025, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", cronTime(2024, 3, 1, 0, 0), cronTime(2028, 2, 29, 0, 0)},
+		{"evaluated in utc", "0 0 * * *", time.Date(2024, 3, 1, 2, 0, 0, 0, time.FixedZone("UTC+5", 5*60*60)), cronTime(2024, 3, 1, 0, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if spec, err := parseCronSpec(test.spec); err != nil {
+				t.Fatalf("failed to parse spec: %v", err)
+			} else if next := spec.next(test.from); !next.Equal(test.next) {
+				t.Fatalf("expected next: %s, found: %s", test.next, next)
+			}
+		})
+	}
+}
+
+func TestCronNextNeverMatches(t *testing.T) {
+	// Specs that never match are rejected by `parseCronSpec`, so build one directly
+	spec := cronSpec{
+		minutes:      1,
+		hours:        1,
+		daysOfMonth:  1 << 30,
+		months:       1 << 2,
+		daysOfWeek:   1<<7 - 1,
+		anyDayOfWeek: true,
+	}
+	if next := spec.next(cronTime(2024, 3, 1, 0, 0)); !next.IsZero() {
+		t.Fatalf("expected no match, found: %s", next)
+	}
+}
+
+func TestCronMatchesDay(t *testing.T) {
+	// 2024-03-13 is a Wednesday
+	tests := []struct {
+		spec    string
+		day     int
+		matches bool
+	}{
+		{"0 0 * * *", 13, true},
+		{"0 0 13 * *", 13, true},
+		{"0 0 14 * *", 13, false},
+		{"0 0 * * 3", 13, true},
+		{"0 0 * * 4", 13, false},
+		// Both restricted: either matching is enough
+		{"0 0 13 * 4", 13, true},
+		{"0 0 14 * 3", 13, true},
+		{"0 0 14 * 4", 13, false},
+		// Day of week unrestricted: the day of month has to match
+		{"0 0 14 * *", 13, false},
+		{"0 0 1-31/2 * *", 13, true},
+		// Day of month unrestricted, even with a step: the day of week has to match
+		{"0 0 */2 * 4", 13, false},
+		{"0 0 */2 * 3", 13, true},
+		{"0 0 */2 * 3", 15, false},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			if spec, err := parseCronSpec(test.spec); err != nil {
+				t.Fatalf("failed to parse spec: %v", err)
+			} else if matches := spec.matchesDay(cronTime(2024, 3, test.day, 0, 0)); matches != test.matches {
+				t.Fatalf("expected match on day %d: %t, found: %t", test.day, test.matches, matches)
+			}
+		})
+	}
+}
+
+func cronTime(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
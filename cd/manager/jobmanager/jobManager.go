@@ -1,16 +1,21 @@
 package jobmanager
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 
 	"github.com/google/uuid"
 
@@ -28,11 +33,25 @@ type JobManager struct {
 	apiGw         manager.ApiGw
 	repo          manager.Repository
 	notifs        manager.Notifs
-	maxAnchorJobs int
+	policy        manager.SchedulerPolicy
 	minAnchorJobs int
-	paused        bool
+	// Whether new jobs are started, as last read from the database
+	paused        *atomic.Bool
 	env           manager.EnvType
 	waitGroup     *sync.WaitGroup
+	inFlight      *sync.Map
+	cancels       *sync.Map
+	updates       *sync.Map
+	scheduler     *schedulerLog
+	priorityAging time.Duration
+	retryPolicies manager.RetryPolicies
+	pollInterval  time.Duration
+	wakeCh        chan bool
+	leader        *leaderElection
+	// Earliest time a scheduled job or recurring schedule comes due, only accessed from the processing loop
+	nextDue time.Time
+	// Time from which to search for scheduled jobs that came due, only accessed from the processing loop
+	scheduledCursor time.Time
 }
 
 const (
@@ -47,6 +66,12 @@ const (
 const defaultCasMaxAnchorWorkers = 1
 const defaultCasMinAnchorWorkers = 0
 
+// Interval at which the processing loop polls the database when it is idle and nothing wakes it up
+const defaultPollInterval = 2 * time.Minute
+
+// Jobs gain one level of priority for every interval spent waiting to be processed
+const defaultPriorityAging = 30 * time.Minute
+
 func NewJobManager(cache manager.Cache, db manager.Database, d manager.Deployment, apiGw manager.ApiGw, repo manager.Repository, notifs manager.Notifs) (manager.Manager, error) {
 	maxAnchorJobs := defaultCasMaxAnchorWorkers
 	if configMaxAnchorWorkers, found := os.LookupEnv("CAS_MAX_ANCHOR_WORKERS"); found {
@@ -63,23 +88,87 @@ func NewJobManager(cache manager.Cache, db manager.Database, d manager.Deploymen
 	if minAnchorJobs > maxAnchorJobs {
 		return nil, fmt.Errorf("newJobManager: invalid anchor worker config: %d, %d", minAnchorJobs, maxAnchorJobs)
 	}
-	paused, _ := strconv.ParseBool(os.Getenv("PAUSED"))
-	return &JobManager{cache, db, d, apiGw, repo, notifs, maxAnchorJobs, minAnchorJobs, paused, manager.EnvType(os.Getenv(manager.EnvVar_Env)), new(sync.WaitGroup)}, nil
+	policy, err := loadSchedulerPolicy(maxAnchorJobs)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicies, err := loadRetryPolicies()
+	if err != nil {
+		return nil, err
+	}
+	priorityAging := defaultPriorityAging
+	if configPriorityAging, found := os.LookupEnv("PRIORITY_AGING_INTERVAL"); found {
+		if parsedPriorityAging, err := time.ParseDuration(configPriorityAging); err != nil {
+			return nil, fmt.Errorf("newJobManager: invalid priority aging interval: %v", err)
+		} else if parsedPriorityAging <= 0 {
+			return nil, fmt.Errorf("newJobManager: priority aging interval must be positive: %s", parsedPriorityAging)
+		} else {
+			priorityAging = parsedPriorityAging
+		}
+	}
+	pollInterval := defaultPollInterval
+	if configPollInterval, found := os.LookupEnv("POLL_INTERVAL"); found {
+		if parsedPollInterval, err := time.ParseDuration(configPollInterval); err != nil {
+			return nil, fmt.Errorf("newJobManager: invalid poll interval: %v", err)
+		} else if parsedPollInterval < manager.DefaultTick {
+			return nil, fmt.Errorf("newJobManager: poll interval shorter than %s: %s", manager.DefaultTick, parsedPollInterval)
+		} else {
+			pollInterval = parsedPollInterval
+		}
+	}
+	leader, err := newLeaderElection(db)
+	if err != nil {
+		return nil, err
+	}
+	// The paused state is stored in the database so that it's shared by all replicas. Starting any replica with PAUSED
+	// set pauses all of them.
+	if pause, _ := strconv.ParseBool(os.Getenv("PAUSED")); pause {
+		if err = db.SetPaused(true); err != nil {
+			return nil, fmt.Errorf("newJobManager: failed to pause: %v", err)
+		}
+	}
+	paused := new(atomic.Bool)
+	if isPaused, err := db.IsPaused(); err != nil {
+		return nil, fmt.Errorf("newJobManager: failed to get paused state: %v", err)
+	} else {
+		paused.Store(isPaused)
+	}
+	return &JobManager{cache, db, d, apiGw, repo, notifs, policy, minAnchorJobs, paused, manager.EnvType(os.Getenv(manager.EnvVar_Env)), new(sync.WaitGroup), new(sync.Map), new(sync.Map), new(sync.Map), newSchedulerLog(), priorityAging, retryPolicies, pollInterval, make(chan bool, 1), leader, time.Time{}, time.Time{}}, nil
 }
 
 func (m *JobManager) NewJob(jobState job.JobState) (job.JobState, error) {
+	now := time.Now()
 	jobState.Stage = job.JobStage_Queued
 	// Only set the job ID/time if not already set by the caller
 	if len(jobState.JobId) == 0 {
 		jobState.JobId = uuid.New().String()
 	}
 	if jobState.Ts.IsZero() {
-		jobState.Ts = time.Now()
+		jobState.Ts = now
+	}
+	// Reject malformed jobs up front instead of failing them when they're dequeued
+	if params, err := job.NormalizeParams(jobState); err != nil {
+		return job.JobState{}, fmt.Errorf("%w: %v", manager.Error_InvalidJob, err)
+	} else {
+		// Versions are assigned by the database as the job is updated
+		delete(params, job.JobParam_Version)
+		jobState.Params = params
+	}
+	// Jobs that can't be processed until later are scheduled, then queued once they come due. Jobs submitted with a
+	// future timestamp are treated the same way.
+	if _, found := job.NotBefore(jobState); !found && jobState.Ts.After(now) {
+		jobState.Params[job.JobParam_NotBefore] = jobState.Ts.Format(time.RFC3339Nano)
+	}
+	var err error
+	if jobState, err = scheduleJob(jobState, now); err != nil {
+		return job.JobState{}, err
 	}
-	if jobState.Params == nil {
-		jobState.Params = make(map[string]interface{}, 0)
+	// If the job has an idempotency key that was already used, the original job is returned instead.
+	if jobState, err = m.db.QueueJob(jobState); err != nil {
+		return job.JobState{}, err
 	}
-	return jobState, m.db.QueueJob(jobState)
+	m.Wake()
+	return jobState, nil
 }
 
 func (m *JobManager) CheckJob(jobId string) job.JobState {
@@ -89,46 +178,254 @@ func (m *JobManager) CheckJob(jobId string) job.JobState {
 	return job.JobState{}
 }
 
+// CancelJob registers a request to cancel a job. The job will be canceled, and any tasks or workflows it launched will be
+// stopped, during the next iteration of the processing loop so that cancellation doesn't race with job advancement.
+func (m *JobManager) CancelJob(jobId string) (job.JobState, error) {
+	jobState, err := m.getJob(jobId)
+	if err != nil {
+		return job.JobState{}, err
+	}
+	if !job.IsCancelableJob(jobState) {
+		return jobState, manager.Error_JobNotCancelable
+	}
+	m.cancels.Store(jobId, jobState)
+	log.Printf("cancelJob: cancellation requested: %s", manager.PrintJob(jobState))
+	m.Wake()
+	return jobState, nil
+}
+
+// getJob returns the latest state of a job from the cache or, for jobs that haven't been dequeued yet, the database
+func (m *JobManager) getJob(jobId string) (job.JobState, error) {
+	if jobState, found := m.cache.JobById(jobId); found {
+		return jobState, nil
+	} else if jobState, found, err := m.db.GetJob(jobId); err != nil {
+		return job.JobState{}, err
+	} else if !found {
+		return job.JobState{}, manager.Error_JobNotFound
+	} else {
+		return jobState, nil
+	}
+}
+
+// CloneJob returns a new job with the same type and params as a finished job, minus any params filled in while the
+// original job was processed. The new job is not queued.
+func (m *JobManager) CloneJob(jobId string) (job.JobState, error) {
+	if jobState, err := m.getJob(jobId); err != nil {
+		return job.JobState{}, err
+	} else if !job.IsFinishedJob(jobState) {
+		return jobState, manager.Error_JobNotFinished
+	} else {
+		params := make(map[string]interface{}, len(jobState.Params))
+		for k, v := range jobState.Params {
+			if slices.Contains(job.VolatileParams, k) {
+				continue
+			} else if nestedParams, ok := v.(map[string]interface{}); ok {
+				// Copy nested params, e.g. workflow inputs, so that processing the new job doesn't modify the original
+				params[k] = maps.Clone(nestedParams)
+			} else {
+				params[k] = v
+			}
+		}
+		params[job.JobParam_RerunOf] = jobState.JobId
+		return job.JobState{Type: jobState.Type, Params: params}, nil
+	}
+}
+
+func (m *JobManager) ListJobs(filter manager.JobFilter) (manager.JobPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = manager.DefaultJobPageSize
+	} else if filter.Limit > manager.MaxJobPageSize {
+		filter.Limit = manager.MaxJobPageSize
+	}
+	// Scheduled jobs are recorded with the time they come due, so include jobs scheduled in the future.
+	if (filter.Stage == job.JobStage_Scheduled) && filter.End.IsZero() {
+		filter.End = time.Now().Add(maxScheduleAhead)
+	}
+	return m.db.ListJobs(filter)
+}
+
+// JobHistory returns the stage records for a job, along with the params that changed at each stage
+func (m *JobManager) JobHistory(jobId string) ([]manager.JobHistoryEntry, error) {
+	if jobStates, err := m.db.GetJobHistory(jobId); err != nil {
+		return nil, err
+	} else if len(jobStates) == 0 {
+		return nil, manager.Error_JobNotFound
+	} else {
+		history := make([]manager.JobHistoryEntry, len(jobStates))
+		prevParams := map[string]interface{}{}
+		for idx, jobState := range jobStates {
+			entry := manager.JobHistoryEntry{Stage: jobState.Stage, Ts: jobState.Ts, Params: map[string]interface{}{}}
+			entry.Error, _ = jobState.Params[job.JobParam_Error].(string)
+			entry.WaitTime, _ = jobState.Params[job.JobParam_WaitTime].(string)
+			for k, v := range jobState.Params {
+				// The error and wait time are reported separately, and versions change with every update
+				if (k == job.JobParam_Error) || (k == job.JobParam_WaitTime) || (k == job.JobParam_Version) {
+					continue
+				}
+				if prevValue, found := prevParams[k]; !found || !reflect.DeepEqual(prevValue, v) {
+					entry.Params[k] = v
+				}
+			}
+			for k := range prevParams {
+				if _, found := jobState.Params[k]; !found && (k != job.JobParam_Error) && (k != job.JobParam_WaitTime) && (k != job.JobParam_Version) {
+					entry.Removed = append(entry.Removed, k)
+				}
+			}
+			history[idx] = entry
+			prevParams = jobState.Params
+		}
+		return history, nil
+	}
+}
+
+// ProcessJobs runs the processing loop whenever it is woken up (see `Wake`), or when the next poll comes due (see
+// `nextPoll`).
 func (m *JobManager) ProcessJobs(shutdownCh chan bool) {
-	// Create a ticker to poll the database for new jobs
-	tick := time.NewTicker(manager.DefaultTick)
+	// Create a timer to poll the database for new jobs
+	timer := time.NewTimer(manager.DefaultTick)
 	// Only allow one run token to exist, and start with it available for the processing loop to start running.
 	runToken := make(chan bool, 1)
 	runToken <- true
+	// Start leader election, if enabled, so that only one replica processes jobs at a time.
+	leaderStopCh := make(chan bool)
+	if m.leader != nil {
+		m.leader.heartbeat()
+		go m.runLeaderElection(leaderStopCh)
+	}
+	wasLeader := true
+	processJobs := func(woken bool) {
+		// Acquire the run token so that no loop iterations can run in parallel (shouldn't happen), and so that shutdown
+		// can't complete until a running iteration has finished.
+		<-runToken
+		isLeader := m.IsLeader()
+		// Every replica reads the paused state, so that it's reported correctly and a standby that takes over starts out
+		// in the same state.
+		m.refreshPaused()
+		if !isLeader || !wasLeader {
+			// Standbys keep their cache up to date so that they can serve API traffic. A replica that just took over
+			// also needs to reload the jobs the previous leader advanced before it starts processing.
+			if err := m.db.InitializeJobs(); err != nil {
+				log.Printf("processJobs: failed to refresh jobs from database: %v", err)
+				isLeader = false
+			}
+		}
+		if isLeader {
+			m.processJobs()
+			timer.Reset(m.nextPoll(time.Now(), woken))
+		} else {
+			timer.Reset(m.pollInterval)
+		}
+		wasLeader = isLeader
+		// Release the run token
+		runToken <- true
+	}
 	for {
 		log.Println("manager: start processing jobs...")
 		for {
 			select {
 			case <-shutdownCh:
 				log.Println("manager: stop processing jobs...")
-				tick.Stop()
-				// Attempt to acquire the run token to ensure that no jobs are being processed while shutting down
+				timer.Stop()
+				// Attempt to acquire the run token to ensure that the processing loop isn't running while shutting down,
+				// then wait for any job workers still running to finish.
 				<-runToken
+				m.waitGroup.Wait()
+				// Hand over processing to a standby replica, if any, as soon as possible.
+				if m.leader != nil {
+					close(leaderStopCh)
+					m.leader.release()
+				}
 				return
-			case <-tick.C:
-				// Acquire the run token so that no loop iterations can run in parallel (shouldn't happen), and so that
-				// shutdown can't complete until a running iteration has finished.
-				<-runToken
-				m.processJobs()
-				// Release the run token
-				runToken <- true
+			case <-timer.C:
+				processJobs(false)
+			case <-m.wakeCh:
+				// Stop the timer before resetting it, draining it if it fired in the meantime.
+				if !timer.Stop() {
+					<-timer.C
+				}
+				processJobs(true)
 			}
 		}
 	}
 }
 
-func (m *JobManager) Pause() {
-	// Toggle paused status
-	m.paused = !m.paused
-	status := "paused"
-	if !m.paused {
-		status = "un" + status
+// Wake runs the processing loop as soon as possible, e.g. after a job was submitted or an external event was received,
+// instead of waiting for the next poll. Wake-ups received while the loop is running result in one more iteration once
+// the current one has finished.
+func (m *JobManager) Wake() {
+	select {
+	case m.wakeCh <- true:
+	default:
+		// A wake-up is already pending
 	}
-	log.Printf("pause: job manager %s", status)
+}
+
+// nextPoll returns how long to wait before the next iteration of the processing loop if nothing wakes it up earlier.
+//
+// Jobs in progress or waiting to start are polled at the regular interval so that they are advanced promptly. Otherwise,
+// the loop only polls at the slower interval, or when the next scheduled job or recurring schedule comes due. A loop
+// that was woken up also polls again at the regular interval since newly queued jobs might not be visible in the
+// database's indices right away.
+func (m *JobManager) nextPoll(now time.Time, woken bool) time.Duration {
+	if woken || (len(m.cache.JobsByMatcher(func(js job.JobState) bool {
+		return job.IsActiveJob(js) || job.IsWaitingJob(js)
+	})) > 0) {
+		return manager.DefaultTick
+	}
+	delay := m.pollInterval
+	if !m.nextDue.IsZero() && m.nextDue.Before(now.Add(delay)) {
+		delay = m.nextDue.Sub(now)
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+// dueAt records when a scheduled job or recurring schedule comes due so that the processing loop can poll in time
+func (m *JobManager) dueAt(ts time.Time) {
+	if m.nextDue.IsZero() || ts.Before(m.nextDue) {
+		m.nextDue = ts
+	}
+}
+
+// Pause toggles whether new jobs are started, and returns the new paused state. The paused state is stored in the
+// database so that it carries over to a standby that takes over.
+func (m *JobManager) Pause() (bool, error) {
+	paused, err := m.db.IsPaused()
+	if err != nil {
+		return false, err
+	}
+	paused = !paused
+	if err = m.db.SetPaused(paused); err != nil {
+		return false, err
+	}
+	m.paused.Store(paused)
+	log.Printf("pause: job manager %s", pausedStatus(paused))
+	m.Wake()
+	return paused, nil
+}
+
+// refreshPaused picks up the paused state from the database, keeping the last known state if it can't be read
+func (m *JobManager) refreshPaused() {
+	if paused, err := m.db.IsPaused(); err != nil {
+		log.Printf("refreshPaused: failed to get paused state: %v", err)
+	} else if m.paused.Swap(paused) != paused {
+		log.Printf("refreshPaused: job manager %s", pausedStatus(paused))
+	}
+}
+
+func pausedStatus(paused bool) string {
+	if paused {
+		return "paused"
+	}
+	return "unpaused"
 }
 
 func (m *JobManager) processJobs() {
 	now := time.Now()
+	m.scheduler.start(now, m.paused.Load())
+	m.nextDue = time.Time{}
 	// Age out completed/failed/skipped jobs older than 1 day
 	oldJobs := m.cache.JobsByMatcher(func(js job.JobState) bool {
 		return job.IsFinishedJob(js) && now.AddDate(0, 0, -manager.DefaultTtlDays).After(js.Ts)
@@ -141,21 +438,46 @@ func (m *JobManager) processJobs() {
 			m.cache.DeleteJob(oldJob.JobId)
 		}
 	}
+	// Cancel jobs before advancing them so that we don't start any new work for them. Cancellations are processed even
+	// if the job manager is paused.
+	m.processCancelJobs()
+	// Apply changes to waiting jobs before looking for jobs to process, so that they take effect in this iteration.
+	m.processQueueUpdates()
+	// Queue jobs for recurring schedules that are due
+	m.processSchedules()
+	// Submit jobs for pipeline stages whose upstream stages have finished
+	m.processPipelines()
+	// Queue scheduled jobs that have come due. This happens even if the job manager is paused, the jobs just won't be
+	// dequeued until it's unpaused.
+	m.processScheduledJobs()
 	// Find all jobs in progress and advance their state before looking for new jobs
 	m.advanceJobs(m.cache.JobsByMatcher(job.IsActiveJob))
+	var dequeuedJobs []job.JobState
 	// Don't start any new jobs if the job manager is paused. Existing jobs will continue to be advanced.
-	if !m.paused {
+	if m.paused.Load() {
+		for _, dequeuedJob := range m.db.OrderedJobs(job.JobStage_Dequeued) {
+			m.scheduler.record(dequeuedJob, manager.SchedulerAction_Waiting, "job manager is paused")
+		}
+	} else {
 		// Advance each freshly discovered "queued" job to the "dequeued" stage
 		m.advanceJobs(m.db.QueuedJobs())
-		// Jobs in the "dequeued" stage are in the cache but haven't been "started" yet and can thus begin processing
-		dequeuedJobs := m.db.OrderedJobs(job.JobStage_Dequeued)
+		// Jobs in the "dequeued" stage are in the cache but haven't been "started" yet and can thus begin processing,
+		// unless a worker is already starting them.
+		for _, dequeuedJob := range m.db.OrderedJobs(job.JobStage_Dequeued) {
+			if m.isInFlight(dequeuedJob.JobId) {
+				m.scheduler.record(dequeuedJob, manager.SchedulerAction_Started, "job is being started")
+			} else {
+				dequeuedJobs = append(dequeuedJobs, dequeuedJob)
+			}
+		}
+		// Process higher priority jobs first, while maintaining the order of jobs with the same priority. Jobs gain
+		// priority the longer they wait so that a steady stream of higher priority jobs can't starve the rest.
+		sort.SliceStable(dequeuedJobs, func(i, j int) bool {
+			return m.effectivePriority(dequeuedJobs[i], now) > m.effectivePriority(dequeuedJobs[j], now)
+		})
 		if len(dequeuedJobs) > 0 {
-			// Try to start multiple jobs and collapse similar ones:
-			// - one deploy at a time (compatible with anchor jobs)
-			// - one smoke test at a time (compatible with non-deploy jobs)
-			// - one E2E test at a time (compatible with non-deploy jobs)
-			// - one workflow at a time (compatible with non-deploy jobs)
-			// - any number of anchor workers (compatible with any other type of job)
+			// Try to start multiple jobs and collapse similar ones. Which jobs can run alongside each other, and how many
+			// of each can run at once, is determined by the scheduler policy (see `defaultSchedulerPolicy`).
 			//
 			// Loop over compatible dequeued jobs until we find an incompatible one and need to wait for existing jobs
 			// to complete.
@@ -171,49 +493,71 @@ func (m *JobManager) processJobs() {
 		// Anchor jobs can be run independently of deployments and do not need any exclusion rules
 		m.processAnchorJobs(dequeuedJobs)
 	}
-	// Wait for all of this iteration's job advancement goroutines to finish before we iterate again. The ticker will
-	// automatically drop ticks then pick back up later if a round of processing takes longer than 1 tick.
-	m.waitGroup.Wait()
+	// Don't wait for this iteration's job workers to finish. Slow jobs, e.g. deployments waiting on commit statuses,
+	// keep on being advanced by their workers while the loop continues, and workers wake the loop up once their job
+	// moves to a new stage.
+	m.scheduler.finish(dequeuedJobs)
 }
 
 func (m *JobManager) advanceJobs(jobs []job.JobState) {
-	if len(jobs) > 0 {
-		for _, jobState := range jobs {
-			m.advanceJob(jobState)
-		}
-		// Wait for any running job advancement goroutines to finish
-		m.waitGroup.Wait()
+	for _, jobState := range jobs {
+		m.advanceJob(jobState)
 	}
 }
 
-func (m *JobManager) checkJobInterval(jobType job.JobType, jobStage job.JobStage, intervalEnv string, processFn func(time.Time) error) error {
-	if interval, found := os.LookupEnv(intervalEnv); found {
-		if parsedInterval, err := time.ParseDuration(interval); err != nil {
-			log.Printf("checkJobInterval: failed to parse interval: %s, %s, %v", jobType, intervalEnv, err)
-			return err
+func (m *JobManager) isInFlight(jobId string) bool {
+	_, found := m.inFlight.Load(jobId)
+	return found
+}
+
+// startingJobs returns the dequeued jobs that workers are in the process of starting
+func (m *JobManager) startingJobs() []job.JobState {
+	startingJobs := make([]job.JobState, 0, 0)
+	m.inFlight.Range(func(key, value interface{}) bool {
+		// Jobs that have already been started are in the cache with their new stage
+		if cachedJob, found := m.cache.JobById(key.(string)); found && (cachedJob.Stage == job.JobStage_Dequeued) {
+			startingJobs = append(startingJobs, cachedJob)
+		}
+		return true
+	})
+	return startingJobs
+}
+
+func (m *JobManager) processCancelJobs() {
+	m.cancels.Range(func(key, value interface{}) bool {
+		jobState := value.(job.JobState)
+		// Wait for the job's worker to finish so that cancellation doesn't race with job advancement
+		if m.isInFlight(jobState.JobId) {
+			return true
+		}
+		// Use the latest state of the job if it was picked up for processing after cancellation was requested
+		if cachedJob, found := m.cache.JobById(jobState.JobId); found {
+			jobState = cachedJob
+		}
+		// The job might have finished since cancellation was requested, in which case there's nothing left to do.
+		if job.IsCancelableJob(jobState) {
+			m.cancelJob(jobState)
+		}
+		m.cancels.Delete(key)
+		return true
+	})
+}
+
+func (m *JobManager) cancelJob(jobState job.JobState) {
+	if job.IsActiveJob(jobState) {
+		// Active jobs might have launched tasks or workflows that need to be stopped
+		if jobSm, err := m.prepareJobSm(jobState); err != nil {
+			log.Printf("cancelJob: job generation failed: %v, %s", err, manager.PrintJob(jobState))
+		} else if newJobState, err := jobSm.Cancel(); err != nil {
+			log.Printf("cancelJob: job cancellation failed: %v, %s", err, manager.PrintJob(jobState))
 		} else {
-			now := time.Now()
-			var lastJob *job.JobState = nil
-			// Iterate the DB in descending order of timestamp
-			if err = m.db.IterateByType(jobType, false, func(js job.JobState) bool {
-				if js.Stage == jobStage {
-					lastJob = &js
-					// Stop iterating, we found the job we were looking for.
-					return false
-				}
-				// Keep iterating till we find the most recent job
-				return true
-			}); err != nil {
-				log.Printf("checkJobInterval: error iterating over %s: %v", jobType, err)
-				return err
-			}
-			// Only call `processFn` if we found an appropriate job
-			if (lastJob != nil) && now.Add(-parsedInterval).After(lastJob.Ts) {
-				return processFn(lastJob.Ts)
-			}
+			log.Printf("cancelJob: canceled job: %s", manager.PrintJob(newJobState))
 		}
+	} else
+	// Jobs that haven't started yet have nothing to clean up
+	if err := m.updateJobStage(jobState, job.JobStage_Canceled, nil); err != nil {
+		log.Printf("cancelJob: job update failed: %v, %s", err, manager.PrintJob(jobState))
 	}
-	return nil
 }
 
 func (m *JobManager) processForceDeployJobs(dequeuedJobs []job.JobState) bool {
@@ -222,8 +566,11 @@ func (m *JobManager) processForceDeployJobs(dequeuedJobs []job.JobState) bool {
 	for _, dequeuedJob := range dequeuedJobs {
 		if dequeuedJob.Type == job.JobType_Deploy {
 			if dequeuedJobForce, _ := dequeuedJob.Params[job.DeployJobParam_Force].(bool); dequeuedJobForce {
-				// Replace an existing job with a newer one, or add a new job (hence a map).
-				forceDeploys[dequeuedJob.Params[job.DeployJobParam_Component].(string)] = dequeuedJob
+				// Invalid deploy jobs are failed when processing regular deploys (see `processDeployJobs`)
+				if component, err := deployComponent(dequeuedJob); err == nil {
+					// Replace an existing job with a newer one, or add a new job (hence a map).
+					forceDeploys[component] = dequeuedJob
+				}
 			}
 		}
 	}
@@ -231,7 +578,10 @@ func (m *JobManager) processForceDeployJobs(dequeuedJobs []job.JobState) bool {
 		// Skip any dequeued jobs for components being force deployed
 		for _, dequeuedJob := range dequeuedJobs {
 			if dequeuedJob.Type == job.JobType_Deploy {
-				if forceDeploy, found := forceDeploys[dequeuedJob.Params[job.DeployJobParam_Component].(string)]; found && (dequeuedJob.JobId != forceDeploy.JobId) {
+				if component, err := deployComponent(dequeuedJob); err != nil {
+					continue
+				} else if forceDeploy, found := forceDeploys[component]; found && (dequeuedJob.JobId != forceDeploy.JobId) {
+					m.scheduler.record(dequeuedJob, manager.SchedulerAction_Collapsed, "replaced by force deploy", forceDeploy)
 					if err := m.updateJobStage(dequeuedJob, job.JobStage_Skipped, nil); err != nil {
 						// Return `true` from here so that no state is changed and the loop can restart cleanly. Any
 						// jobs already skipped won't be picked up again, which is ok.
@@ -245,7 +595,9 @@ func (m *JobManager) processForceDeployJobs(dequeuedJobs []job.JobState) bool {
 			return job.IsActiveJob(js) && (js.Type == job.JobType_Deploy)
 		})
 		for _, activeDeploy := range activeDeploys {
-			if _, found := forceDeploys[activeDeploy.Params[job.DeployJobParam_Component].(string)]; found {
+			if component, err := deployComponent(activeDeploy); err != nil {
+				log.Printf("processForceDeployJobs: invalid active deploy: %v, %s", err, manager.PrintJob(activeDeploy))
+			} else if _, found := forceDeploys[component]; found {
 				if err := m.updateJobStage(activeDeploy, job.JobStage_Canceled, nil); err != nil {
 					// Return `true` from here so that no state is changed and the loop can restart cleanly. Any jobs
 					// already skipped won't be picked up again, which is ok.
@@ -254,6 +606,9 @@ func (m *JobManager) processForceDeployJobs(dequeuedJobs []job.JobState) bool {
 			}
 		}
 		// Now advance all force deploy jobs, order doesn't matter.
+		for _, forceDeploy := range forceDeploys {
+			m.scheduler.record(forceDeploy, manager.SchedulerAction_Started, "force deploy")
+		}
 		m.advanceJobs(maps.Values(forceDeploys))
 		return true
 	}
@@ -261,36 +616,79 @@ func (m *JobManager) processForceDeployJobs(dequeuedJobs []job.JobState) bool {
 }
 
 func (m *JobManager) processDeployJobs(dequeuedJobs []job.JobState) bool {
-	// Check if there are any non-anchor jobs in progress. Deployments can run in parallel with anchor jobs but not with
-	// any other jobs.
-	activeNonAnchorJobs := m.getActiveNonAnchorJobs()
-	if len(activeNonAnchorJobs) == 0 {
-		// We know the first job is a deploy, so pick out the component for that job, collapse as many back-to-back jobs
-		// as possible for that component, then run the final job.
-		deployJob := dequeuedJobs[0]
-		deployComponent := deployJob.Params[job.DeployJobParam_Component].(string)
-		// Collapse similar, back-to-back deployments into a single run and kick it off.
-		for i := 1; i < len(dequeuedJobs); i++ {
-			dequeuedJob := dequeuedJobs[i]
-			// Break out of the loop as soon as we find a test job - we don't want to collapse deploys across them.
-			if (dequeuedJob.Type == job.JobType_TestE2E) || (dequeuedJob.Type == job.JobType_TestSmoke) {
-				break
-			} else if (dequeuedJob.Type == job.JobType_Deploy) && (dequeuedJob.Params[job.DeployJobParam_Component].(string) == deployComponent) {
-				// Skip the current deploy job, and replace it with a newer one.
-				if err := m.updateJobStage(deployJob, job.JobStage_Skipped, nil); err != nil {
-					// Return `true` from here so that no state is changed and the loop can restart cleanly. Any
-					// jobs already skipped won't be picked up again, which is ok.
+	// Gather the deploys queued ahead of the first test job, grouped by component in the order in which the components
+	// were first queued. We don't want to run or collapse deploys across test jobs.
+	components := make([]string, 0, 0)
+	componentDeploys := make(map[string][]job.JobState)
+	for _, dequeuedJob := range dequeuedJobs {
+		if (dequeuedJob.Type == job.JobType_TestE2E) || (dequeuedJob.Type == job.JobType_TestSmoke) {
+			break
+		} else if dequeuedJob.Type == job.JobType_Deploy {
+			component, err := deployComponent(dequeuedJob)
+			if err != nil {
+				// Fail invalid deploy jobs so that they don't block the queue
+				log.Printf("processDeployJobs: invalid deploy job: %v, %s", err, manager.PrintJob(dequeuedJob))
+				m.scheduler.record(dequeuedJob, manager.SchedulerAction_Finished, "invalid deploy params: "+err.Error())
+				if err = m.updateJobStage(dequeuedJob, job.JobStage_Failed, err); err != nil {
+					// Return `true` from here so that no state is changed and the loop can restart cleanly. Any jobs
+					// already failed won't be picked up again, which is ok.
 					return true
 				}
+				continue
+			}
+			if _, found := componentDeploys[component]; !found {
+				components = append(components, component)
+			}
+			componentDeploys[component] = append(componentDeploys[component], dequeuedJob)
+		}
+	}
+	activeDeploys := m.cache.JobsByMatcher(func(js job.JobState) bool {
+		return job.IsActiveJob(js) && (js.Type == job.JobType_Deploy)
+	})
+	startingDeploys := make([]job.JobState, 0, 0)
+	for _, component := range components {
+		// Collapse similar, back-to-back deployments into a single run of the most recently queued one, which isn't
+		// necessarily the last one seen since jobs are ordered by priority.
+		deploys := componentDeploys[component]
+		deployJob := deploys[0]
+		for _, dequeuedJob := range deploys[1:] {
+			if dequeuedJob.Ts.After(deployJob.Ts) {
 				deployJob = dequeuedJob
 			}
 		}
-		m.advanceJob(deployJob)
-		return true
-	} else {
-		log.Printf("processDeployJobs: other jobs in progress")
+		// Check if the scheduler policy allows the deployment to start alongside the jobs in progress. Deployments can
+		// run in parallel with each other as long as they don't touch any of the same services.
+		action, reason, relatedJobs := m.checkPolicy(deployJob, startingDeploys...)
+		if action == manager.SchedulerAction_Started {
+			if overlappingDeploys := overlappingDeploys(deployJob, append(activeDeploys, startingDeploys...)); len(overlappingDeploys) > 0 {
+				action, reason, relatedJobs = manager.SchedulerAction_Blocked, "deploy layout overlaps with deploys in progress", overlappingDeploys
+			}
+		}
+		if action != manager.SchedulerAction_Started {
+			log.Printf("processDeployJobs: %s: %s", reason, component)
+			for _, dequeuedJob := range deploys {
+				m.scheduler.record(dequeuedJob, action, reason, relatedJobs...)
+			}
+			continue
+		}
+		for _, dequeuedJob := range deploys {
+			// Skip the older deploy jobs, they have been replaced by the newest one.
+			if dequeuedJob.JobId == deployJob.JobId {
+				continue
+			}
+			m.scheduler.record(dequeuedJob, manager.SchedulerAction_Collapsed, "collapsed into later deploy", deployJob)
+			if err := m.updateJobStage(dequeuedJob, job.JobStage_Skipped, nil); err != nil {
+				// Return `true` from here so that no state is changed and the loop can restart cleanly. Any jobs
+				// already skipped won't be picked up again, which is ok.
+				return true
+			}
+		}
+		m.scheduler.record(deployJob, action, "allowed by scheduler policy")
+		startingDeploys = append(startingDeploys, deployJob)
 	}
-	return false
+	// Now advance all deploy jobs, order doesn't matter.
+	m.advanceJobs(startingDeploys)
+	return len(startingDeploys) > 0
 }
 
 func (m *JobManager) processAnchorJobs(dequeuedJobs []job.JobState) bool {
@@ -298,29 +696,26 @@ func (m *JobManager) processAnchorJobs(dequeuedJobs []job.JobState) bool {
 }
 
 func (m *JobManager) processVxAnchorJobs(dequeuedJobs []job.JobState, processV5Jobs bool) bool {
-	// Lookup any anchor jobs in progress
-	activeAnchors := m.cache.JobsByMatcher(func(js job.JobState) bool {
-		// Returns true if `processV5Jobs=true` and this is a v5 worker job, or if `processV5Jobs=false` and this is a
-		// v2 worker job.
-		return job.IsActiveJob(js) && (js.Type == job.JobType_Anchor) && (processV5Jobs == manager.IsV5WorkerJob(js))
-	})
 	dequeuedAnchors := make([]job.JobState, 0, 0)
 	for _, dequeuedJob := range dequeuedJobs {
 		if (dequeuedJob.Type == job.JobType_Anchor) && (processV5Jobs == manager.IsV5WorkerJob(dequeuedJob)) {
-			// Launch a new anchor job if:
-			//  - This is a v5 job - the v5 Scheduler is responsible for scaling/capping v5 workers
-			//  - The maximum number of anchor jobs is -1 (infinity)
-			//  - The number of active anchor jobs + the number of dequeued jobs < the configured maximum
-			if manager.IsV5WorkerJob(dequeuedJob) ||
-				(m.maxAnchorJobs == -1) ||
-				(len(activeAnchors)+len(dequeuedAnchors) < m.maxAnchorJobs) {
+			// Launch a new anchor job if the scheduler policy allows it alongside the jobs in progress and the anchor
+			// jobs already being launched. The v5 Scheduler is responsible for scaling/capping v5 workers, so there is
+			// no limit for them by default.
+			action, reason, relatedJobs := m.checkPolicy(dequeuedJob, dequeuedAnchors...)
+			if action == manager.SchedulerAction_Started {
+				m.scheduler.record(dequeuedJob, action, "allowed by scheduler policy")
 				dequeuedAnchors = append(dequeuedAnchors, dequeuedJob)
-			} else
-			// Skip any pending anchor jobs so that they don't linger in the job queue
-			if err := m.updateJobStage(dequeuedJob, job.JobStage_Skipped, nil); err != nil {
-				// Return `true` from here so that no state is changed and the loop can restart cleanly. Any jobs
-				// already skipped won't be picked up again, which is ok.
-				return true
+			} else if action == manager.SchedulerAction_Blocked {
+				m.scheduler.record(dequeuedJob, action, reason, relatedJobs...)
+			} else {
+				// Skip any pending anchor jobs over the limit so that they don't linger in the job queue
+				m.scheduler.record(dequeuedJob, manager.SchedulerAction_Skipped, reason, relatedJobs...)
+				if err := m.updateJobStage(dequeuedJob, job.JobStage_Skipped, nil); err != nil {
+					// Return `true` from here so that no state is changed and the loop can restart cleanly. Any jobs
+					// already skipped won't be picked up again, which is ok.
+					return true
+				}
 			}
 		}
 	}
@@ -336,6 +731,11 @@ func (m *JobManager) processVxAnchorJobs(dequeuedJobs []job.JobState, processV5J
 	//
 	// This mode will not be used for v5 anchor jobs - the v5 Scheduler is responsible for scaling v5 workers.
 	numJobs := len(dequeuedAnchors)
+	for _, startingJob := range m.startingJobs() {
+		if (startingJob.Type == job.JobType_Anchor) && (processV5Jobs == manager.IsV5WorkerJob(startingJob)) {
+			numJobs++
+		}
+	}
 	if !processV5Jobs {
 		for i := 0; i < m.minAnchorJobs-numJobs; i++ {
 			if _, err := m.NewJob(job.JobState{
@@ -352,58 +752,107 @@ func (m *JobManager) processVxAnchorJobs(dequeuedJobs []job.JobState, processV5J
 }
 
 func (m *JobManager) processTestJobs(dequeuedJobs []job.JobState) bool {
-	// Check if there are any deploy jobs in progress
-	if len(m.getActiveDeploys()) == 0 {
-		// - Collapse all smoke tests between deployments into a single run
-		// - Collapse all E2E tests between deployments into a single run
-		dequeuedTests := make(map[job.JobType]job.JobState)
-		for _, dequeuedJob := range dequeuedJobs {
-			// Break out of the loop as soon as we find a deploy job so that we don't collapse test jobs across deploys.
-			if dequeuedJob.Type == job.JobType_Deploy {
-				break
-			} else if (dequeuedJob.Type == job.JobType_TestE2E) || (dequeuedJob.Type == job.JobType_TestSmoke) {
-				// Update the cache and database for every skipped job
-				if jobToSkip, found := dequeuedTests[dequeuedJob.Type]; found {
-					if err := m.updateJobStage(jobToSkip, job.JobStage_Skipped, nil); err != nil {
-						// Return `true` from here so that no state is changed and the loop can restart cleanly. Any
-						// jobs already skipped won't be picked up again, which is ok.
-						return true
-					}
+	// - Collapse all smoke tests between deployments into a single run
+	// - Collapse all E2E tests between deployments into a single run
+	dequeuedTests := make(map[job.JobType]job.JobState)
+	for idx, dequeuedJob := range dequeuedJobs {
+		// Break out of the loop as soon as we find a deploy job so that we don't collapse test jobs across deploys.
+		if dequeuedJob.Type == job.JobType_Deploy {
+			for _, laterJob := range dequeuedJobs[idx+1:] {
+				if (laterJob.Type == job.JobType_TestE2E) || (laterJob.Type == job.JobType_TestSmoke) {
+					m.scheduler.record(laterJob, manager.SchedulerAction_Waiting, "queued behind deploy", dequeuedJob)
 				}
-				// Replace an existing test job with a newer one, or add a new job (hence a map).
-				dequeuedTests[dequeuedJob.Type] = dequeuedJob
 			}
+			break
+		} else if (dequeuedJob.Type == job.JobType_TestE2E) || (dequeuedJob.Type == job.JobType_TestSmoke) {
+			// Don't collapse tests that can't be started yet so that they remain in the queue as they were
+			if action, reason, relatedJobs := m.checkPolicy(dequeuedJob); action != manager.SchedulerAction_Started {
+				m.scheduler.record(dequeuedJob, action, reason, relatedJobs...)
+				continue
+			}
+			// Update the cache and database for every skipped job
+			jobToKeep := dequeuedJob
+			if jobToSkip, found := dequeuedTests[dequeuedJob.Type]; found {
+				// Keep the most recently queued test, which isn't necessarily the last one seen since jobs are
+				// ordered by priority.
+				if jobToSkip.Ts.After(jobToKeep.Ts) {
+					jobToSkip, jobToKeep = jobToKeep, jobToSkip
+				}
+				m.scheduler.record(jobToSkip, manager.SchedulerAction_Collapsed, "collapsed into later test", jobToKeep)
+				if err := m.updateJobStage(jobToSkip, job.JobStage_Skipped, nil); err != nil {
+					// Return `true` from here so that no state is changed and the loop can restart cleanly. Any
+					// jobs already skipped won't be picked up again, which is ok.
+					return true
+				}
+			}
+			// Replace an existing test job with a newer one, or add a new job (hence a map).
+			dequeuedTests[dequeuedJob.Type] = jobToKeep
 		}
-		m.advanceJobs(maps.Values(dequeuedTests))
-		return len(dequeuedTests) > 0
-	} else {
-		log.Printf("processTestJobs: deployment in progress")
 	}
-	return false
+	// Check the scheduler policy again in case the tests being started can't run alongside each other
+	startingTests := make([]job.JobState, 0, len(dequeuedTests))
+	for _, dequeuedTest := range dequeuedTests {
+		if action, reason, relatedJobs := m.checkPolicy(dequeuedTest, startingTests...); action == manager.SchedulerAction_Started {
+			m.scheduler.record(dequeuedTest, action, "allowed by scheduler policy")
+			startingTests = append(startingTests, dequeuedTest)
+		} else {
+			log.Printf("processTestJobs: %s", reason)
+			m.scheduler.record(dequeuedTest, action, reason, relatedJobs...)
+		}
+	}
+	m.advanceJobs(startingTests)
+	return len(startingTests) > 0
 }
 
 func (m *JobManager) processWorkflowJobs(dequeuedJobs []job.JobState) bool {
-	// Check if there are any non-anchor jobs in progress. Workflows can run in parallel with anchor jobs but not with
-	// any other jobs.
-	if len(m.getActiveNonAnchorJobs()) == 0 {
-		for _, dequeuedJob := range dequeuedJobs {
-			if dequeuedJob.Type == job.JobType_Workflow {
+	// Only consider the first workflow so that workflows are started in order
+	for _, dequeuedJob := range dequeuedJobs {
+		if dequeuedJob.Type == job.JobType_Workflow {
+			// Check if the scheduler policy allows the workflow to start alongside the jobs in progress
+			if action, reason, relatedJobs := m.checkPolicy(dequeuedJob); action == manager.SchedulerAction_Started {
+				m.scheduler.record(dequeuedJob, action, "allowed by scheduler policy")
 				m.advanceJob(dequeuedJob)
 				return true
+			} else {
+				log.Printf("processWorkflowJobs: %s", reason)
+				m.scheduler.record(dequeuedJob, action, reason, relatedJobs...)
 			}
+			break
 		}
-	} else {
-		log.Printf("processWorkflowJobs: other jobs in progress")
 	}
 	return false
 }
 
 func (m *JobManager) advanceJob(jobState job.JobState) {
+	// Jobs retrying after a transient failure aren't advanced until their backoff has elapsed. Dequeued jobs still count
+	// as starting in the meantime so that incompatible jobs aren't started ahead of them.
+	if retryAt, found := job.RetryAt(jobState); found && retryAt.After(time.Now()) {
+		if jobState.Stage == job.JobStage_Dequeued {
+			m.scheduler.record(jobState, manager.SchedulerAction_Waiting, fmt.Sprintf("retrying after %s", retryAt.Format(time.RFC3339)))
+		}
+		return
+	}
+	// Each job is advanced by at most one worker at a time. Jobs whose worker is still running are advanced again during
+	// a later iteration of the processing loop, once the worker has finished.
+	if _, found := m.inFlight.LoadOrStore(jobState.JobId, jobState); found {
+		return
+	}
 	m.waitGroup.Add(1)
 	go func() {
+		advanced := false
 		defer func() {
-			m.waitGroup.Done()
+			defer m.waitGroup.Done()
+			// Only release the job once any failure below has been recorded
+			defer func() {
+				m.inFlight.Delete(jobState.JobId)
+				// Run the processing loop again right away so that the job's next stage, or any jobs waiting on it,
+				// don't have to wait for the next poll.
+				if advanced {
+					m.Wake()
+				}
+			}()
 			if r := recover(); r != nil {
+				advanced = true
 				fmt.Println("Panic while advancing job: ", r)
 				fmt.Println("Stack Trace:")
 				debug.PrintStack()
@@ -428,6 +877,7 @@ func (m *JobManager) advanceJob(jobState job.JobState) {
 		} else if newJobState.Stage != currentJobStage {
 			log.Printf("advanceJob: next job state: %s", manager.PrintJob(newJobState))
 			m.postProcessJob(newJobState)
+			advanced = true
 		}
 	}()
 }
@@ -438,13 +888,14 @@ func (m *JobManager) postProcessJob(jobState job.JobState) {
 		{
 			switch jobState.Stage {
 			// For completed ECS deployments, run smoke tests after 5 minutes to give the services time to stabilize.
+			// Deploys submitted as part of a pipeline are followed by whatever stages the pipeline specifies instead.
 			case job.JobStage_Completed:
-				{
+				if _, found := jobState.Params[job.JobParam_Pipeline]; !found {
 					if _, err := m.NewJob(job.JobState{
-						Ts:   time.Now().Add(manager.DefaultWaitTime),
 						Type: job.JobType_TestSmoke,
 						Params: map[string]interface{}{
-							job.JobParam_Source: manager.ServiceName,
+							job.JobParam_Source:    manager.ServiceName,
+							job.JobParam_NotBefore: time.Now().Add(manager.DefaultWaitTime).Format(time.RFC3339Nano),
 						},
 					}); err != nil {
 						log.Printf("postProcessJob: failed to queue smoke tests after deploy: %v, %s", err, manager.PrintJob(jobState))
@@ -454,26 +905,26 @@ func (m *JobManager) postProcessJob(jobState job.JobState) {
 			case job.JobStage_Failed:
 				{
 					// Only rollback if this wasn't already a rollback attempt that failed
-					if rollback, _ := jobState.Params[job.DeployJobParam_Rollback].(bool); !rollback {
-						if component, found := jobState.Params[job.DeployJobParam_Component].(string); !found {
-							log.Printf("postProcessJob: missing component (ceramic, ipfs, cas, casv5, rust-ceramic): %s", manager.PrintJob(jobState))
-						} else if deployTags, err := m.db.GetDeployTags(); err != nil { // Get latest deployed tag from database
+					if params, err := job.DecodeDeployParams(jobState.Params); err != nil {
+						log.Printf("postProcessJob: invalid params: %v, %s", err, manager.PrintJob(jobState))
+					} else if !params.Rollback {
+						if deployTags, err := m.db.GetDeployTags(); err != nil { // Get latest deployed tag from database
 							log.Printf("postProcessJob: failed to retrieve deploy tags: %v, %s", err, manager.PrintJob(jobState))
-						} else if deployTag, found := deployTags[manager.DeployComponent(component)]; !found {
-							log.Printf("postProcessJob: missing component build tag: %s, %s", component, manager.PrintJob(jobState))
-						} else if _, err := m.NewJob(job.JobState{
-							Type: job.JobType_Deploy,
-							Params: map[string]interface{}{
-								job.DeployJobParam_Component: jobState.Params[job.DeployJobParam_Component],
-								job.DeployJobParam_Rollback:  true,
-								job.DeployJobParam_Sha:       job.DeployJobTarget_Rollback,
-								job.DeployJobParam_ShaTag:    strings.Split(deployTag, ",")[0], // Strip deploy target
+						} else if deployTag, found := deployTags[manager.DeployComponent(params.Component)]; !found {
+							log.Printf("postProcessJob: missing component build tag: %s, %s", params.Component, manager.PrintJob(jobState))
+						} else {
+							rollbackParams := job.DeployParams{
+								Component: params.Component,
+								Rollback:  true,
+								Sha:       job.DeployJobTarget_Rollback,
+								ShaTag:    strings.Split(deployTag, ",")[0], // Strip deploy target
 								// No point in waiting for other jobs to complete before redeploying a working image
-								job.DeployJobParam_Force: true,
-								job.JobParam_Source:      manager.ServiceName,
-							},
-						}); err != nil {
-							log.Printf("postProcessJob: failed to queue rollback after failed deploy: %v, %s", err, manager.PrintJob(jobState))
+								Force: true,
+							}.Encode()
+							rollbackParams[job.JobParam_Source] = manager.ServiceName
+							if _, err = m.NewJob(job.JobState{Type: job.JobType_Deploy, Params: rollbackParams}); err != nil {
+								log.Printf("postProcessJob: failed to queue rollback after failed deploy: %v, %s", err, manager.PrintJob(jobState))
+							}
 						}
 					}
 				}
@@ -487,15 +938,15 @@ func (m *JobManager) prepareJobSm(jobState job.JobState) (manager.JobSm, error)
 	var err error = nil
 	switch jobState.Type {
 	case job.JobType_Deploy:
-		jobSm, err = jobs.DeployJob(jobState, m.db, m.notifs, m.d, m.repo)
+		jobSm, err = jobs.DeployJob(jobState, m.db, m.notifs, m.retryPolicies[jobState.Type], m.d, m.repo)
 	case job.JobType_Anchor:
-		jobSm = jobs.AnchorJob(jobState, m.db, m.notifs, m.d)
+		jobSm = jobs.AnchorJob(jobState, m.db, m.notifs, m.retryPolicies[jobState.Type], m.d)
 	case job.JobType_TestE2E:
-		jobSm = jobs.E2eTestJob(jobState, m.db, m.notifs, m.d)
+		jobSm = jobs.E2eTestJob(jobState, m.db, m.notifs, m.retryPolicies[jobState.Type], m.d)
 	case job.JobType_TestSmoke:
-		jobSm = jobs.SmokeTestJob(jobState, m.db, m.notifs, m.d)
+		jobSm = jobs.SmokeTestJob(jobState, m.db, m.notifs, m.retryPolicies[jobState.Type], m.d)
 	case job.JobType_Workflow:
-		jobSm, err = jobs.GitHubWorkflowJob(jobState, m.db, m.notifs, m.repo)
+		jobSm, err = jobs.GitHubWorkflowJob(jobState, m.db, m.notifs, m.retryPolicies[jobState.Type], m.repo)
 	default:
 		err = fmt.Errorf("prepareJobSm: unknown job type: %s", manager.PrintJob(jobState))
 	}
@@ -509,27 +960,33 @@ func (m *JobManager) prepareJobSm(jobState job.JobState) (manager.JobSm, error)
 
 func (m *JobManager) updateJobStage(jobState job.JobState, jobStage job.JobStage, e error) error {
 	_, err := manager.AdvanceJob(jobState, jobStage, time.Now(), e, m.db, m.notifs)
+	if errors.Is(err, manager.Error_JobConflict) {
+		// The latest state of the job was loaded into the cache, so the job will be re-evaluated during the next
+		// iteration of the processing loop.
+		log.Printf("updateJobStage: job updated concurrently: %v, %s", err, manager.PrintJob(jobState))
+	}
 	return err
 }
 
-func (m *JobManager) getActiveDeploys() []job.JobState {
-	return m.cache.JobsByMatcher(func(js job.JobState) bool {
-		// We have active deployments if there are any deploy jobs in progress, or workflow jobs with a "deploy" label.
-		if job.IsActiveJob(js) {
-			if js.Type == job.JobType_Deploy {
-				return true
-			} else if js.Type == job.JobType_Workflow {
-				if workflow, err := job.CreateWorkflowJob(js); err == nil {
-					return workflow.IsType(job.WorkflowJobLabel_Deploy)
-				}
-			}
-		}
-		return false
-	})
+// deployComponent returns the component for a deploy job. Deploy jobs have their params validated when they're
+// submitted, but jobs queued by older versions of the CD manager, or edited directly in the database, might not be valid.
+func deployComponent(jobState job.JobState) (string, error) {
+	params, err := job.DecodeDeployParams(jobState.Params)
+	if err != nil {
+		return "", err
+	}
+	return params.Component, nil
 }
 
-func (m *JobManager) getActiveNonAnchorJobs() []job.JobState {
-	return m.cache.JobsByMatcher(func(js job.JobState) bool {
-		return job.IsActiveJob(js) && (js.Type != job.JobType_Anchor)
-	})
+// overlappingDeploys returns the deploy jobs whose layouts have services or tasks in common with those of a deploy job.
+// A deploy without a layout is treated as overlapping with every other deploy.
+func overlappingDeploys(deployJob job.JobState, otherDeploys []job.JobState) []job.JobState {
+	overlappingDeploys := make([]job.JobState, 0, 0)
+	layout, found := deployJob.Params[job.DeployJobParam_Layout].(manager.Layout)
+	for _, otherDeploy := range otherDeploys {
+		if otherLayout, otherFound := otherDeploy.Params[job.DeployJobParam_Layout].(manager.Layout); !found || !otherFound || layout.Overlaps(otherLayout) {
+			overlappingDeploys = append(overlappingDeploys, otherDeploy)
+		}
+	}
+	return overlappingDeploys
 }
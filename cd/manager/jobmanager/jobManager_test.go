@@ -0,0 +1,75 @@
+package jobmanager
+
+import (
+	"testing"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+func TestOverlappingDeploys(t *testing.T) {
+	// clusterLayout returns a layout with the given services and tasks in a single cluster
+	clusterLayout := func(cluster string, services []string, tasks []string) manager.Layout {
+		taskSet := func(names []string) *manager.TaskSet {
+			if len(names) == 0 {
+				return nil
+			}
+			taskSet := &manager.TaskSet{Tasks: map[string]*manager.Task{}}
+			for _, name := range names {
+				taskSet.Tasks[name] = &manager.Task{Id: name}
+			}
+			return taskSet
+		}
+		return manager.Layout{Clusters: map[string]*manager.Cluster{cluster: {ServiceTasks: taskSet(services), Tasks: taskSet(tasks)}}}
+	}
+	deployJob := func(layout *manager.Layout) job.JobState {
+		params := map[string]interface{}{job.DeployJobParam_Component: string(manager.DeployComponent_Ceramic)}
+		if layout != nil {
+			params[job.DeployJobParam_Layout] = *layout
+		}
+		return testJob(job.JobType_Deploy, job.JobStage_Dequeued, params)
+	}
+	layout := func(cluster string, services []string, tasks []string) *manager.Layout {
+		layout := clusterLayout(cluster, services, tasks)
+		return &layout
+	}
+	twoClusters := layout("ceramic-dev", []string{"ceramic-node"}, nil)
+	twoClusters.Clusters["ceramic-dev-cas"] = clusterLayout("ceramic-dev-cas", []string{"cas-api"}, nil).Clusters["ceramic-dev-cas"]
+
+	tests := []struct {
+		name        string
+		layout      *manager.Layout
+		otherLayout *manager.Layout
+		overlaps    bool
+	}{
+		{"disjoint services", layout("ceramic-dev", []string{"ceramic-node"}, nil), layout("ceramic-dev", []string{"ipfs-node"}, nil), false},
+		{"disjoint clusters", layout("ceramic-dev", []string{"ceramic-node"}, nil), layout("ceramic-dev-cas", []string{"ceramic-node"}, nil), false},
+		{"service and task with the same name", layout("ceramic-dev", []string{"ceramic-node"}, nil), layout("ceramic-dev", nil, []string{"ceramic-node"}), false},
+		{"shared service", layout("ceramic-dev", []string{"ceramic-node", "ipfs-node"}, nil), layout("ceramic-dev", []string{"ipfs-node"}, nil), true},
+		{"shared task", layout("ceramic-dev", nil, []string{"anchor-worker"}), layout("ceramic-dev", nil, []string{"anchor-worker"}), true},
+		{"shared service in one of several clusters", twoClusters, layout("ceramic-dev-cas", []string{"cas-api"}, nil), true},
+		{"empty layout", &manager.Layout{}, layout("ceramic-dev", []string{"ceramic-node"}, nil), false},
+		// Deploys without a layout might touch any service
+		{"missing layout", nil, layout("ceramic-dev", []string{"ceramic-node"}, nil), true},
+		{"other missing layout", layout("ceramic-dev", []string{"ceramic-node"}, nil), nil, true},
+		{"both missing layouts", nil, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			otherDeploy := deployJob(test.otherLayout)
+			overlapping := overlappingDeploys(deployJob(test.layout), []job.JobState{otherDeploy})
+			if overlaps := len(overlapping) > 0; overlaps != test.overlaps {
+				t.Fatalf("expected overlap: %t, found: %t", test.overlaps, overlaps)
+			} else if overlaps && (overlapping[0].JobId != otherDeploy.JobId) {
+				t.Fatalf("expected overlapping deploy: %s, found: %s", otherDeploy.JobId, overlapping[0].JobId)
+			}
+		})
+	}
+	// Only the overlapping deploys are returned
+	deploy := deployJob(layout("ceramic-dev", []string{"ceramic-node"}, nil))
+	overlapping := deployJob(layout("ceramic-dev", []string{"ceramic-node", "ipfs-node"}, nil))
+	disjoint := deployJob(layout("ceramic-dev", []string{"ipfs-node"}, nil))
+	if found := overlappingDeploys(deploy, []job.JobState{disjoint, overlapping}); (len(found) != 1) || (found[0].JobId != overlapping.JobId) {
+		t.Fatalf("expected only the overlapping deploy: %s", manager.PrintJob(found...))
+	}
+}
@@ -0,0 +1,183 @@
+package jobmanager
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+)
+
+const leaderLeaseName = "leader"
+
+// Leaders renew their lease 3 times per lease period, and standbys check for an expired lease just as often.
+const defaultLeaderLeaseTtl = 15 * time.Second
+
+// leaderElection keeps track of whether this CD manager replica is the leader, i.e. whether it holds the leader lease.
+// Only the leader processes jobs, while standbys serve read-only API traffic, forward other API requests to the leader,
+// and take over once the leader's lease expires or is released.
+type leaderElection struct {
+	db    manager.Database
+	owner string
+	// Address at which other replicas can reach this replica's API
+	address string
+	ttl     time.Duration
+	mu      sync.RWMutex
+	lease   manager.Lease
+	// Time until which this replica can act as the leader without renewing its lease
+	validUntil time.Time
+	// Address of the leader as of the latest heartbeat, if it's another replica
+	leader string
+}
+
+// newLeaderElection returns nil if leader election is disabled, in which case this replica is always the leader.
+func newLeaderElection(db manager.Database) (*leaderElection, error) {
+	if enabled, _ := strconv.ParseBool(os.Getenv("LEADER_ELECTION")); !enabled {
+		return nil, nil
+	}
+	ttl := defaultLeaderLeaseTtl
+	if configTtl, found := os.LookupEnv("LEADER_LEASE_TTL"); found {
+		if parsedTtl, err := time.ParseDuration(configTtl); err != nil {
+			return nil, fmt.Errorf("newLeaderElection: invalid lease ttl: %v", err)
+		} else if parsedTtl < 3*time.Second {
+			return nil, fmt.Errorf("newLeaderElection: lease ttl too short: %s", parsedTtl)
+		} else {
+			ttl = parsedTtl
+		}
+	}
+	address, err := advertisedAddress()
+	if err != nil {
+		return nil, fmt.Errorf("newLeaderElection: %v", err)
+	}
+	// Identify each replica uniquely, even across restarts of the same container.
+	hostname, _ := os.Hostname()
+	owner := hostname + "/" + uuid.New().String()
+	return &leaderElection{db: db, owner: owner, address: address, ttl: ttl}, nil
+}
+
+// advertisedAddress returns the address at which other replicas can reach this replica's API. Unless configured, this is
+// the replica's first non-loopback IPv4 address, e.g. the task's address in an ECS service, along with the API port.
+func advertisedAddress() (string, error) {
+	if address, found := os.LookupEnv("LEADER_ADVERTISE_ADDRESS"); found {
+		if parsedAddress, err := url.Parse(address); (err != nil) || (len(parsedAddress.Scheme) == 0) || (len(parsedAddress.Host) == 0) {
+			return "", fmt.Errorf("invalid advertise address: %s", address)
+		}
+		return address, nil
+	}
+	port := os.Getenv("SERVER_PORT")
+	if len(port) == 0 {
+		port = "8080"
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get interface addresses: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && (ipNet.IP.To4() != nil) && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			return "http://" + net.JoinHostPort(ipNet.IP.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("no address to advertise, set LEADER_ADVERTISE_ADDRESS")
+}
+
+func (l *leaderElection) isLeader() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return time.Now().Before(l.validUntil)
+}
+
+func (l *leaderElection) leaderAddress() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if time.Now().Before(l.validUntil) {
+		return ""
+	}
+	return l.leader
+}
+
+// heartbeat acquires or renews the leader lease, and returns whether leadership was gained.
+//
+// The leader only considers itself the leader for half the lease period after each renewal, which leaves a margin for
+// clock skew between replicas and slow database requests before anyone else can take over. Job updates are fenced by
+// the lease so that they're rejected once someone else has taken over, e.g. if they were started by job workers that
+// were still running when leadership was lost.
+func (l *leaderElection) heartbeat() bool {
+	start := time.Now()
+	wasLeader := l.isLeader()
+	lease, acquired, err := l.db.AcquireLease(leaderLeaseName, l.owner, l.address, l.ttl)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		log.Printf("heartbeat: failed to acquire lease: %v, %s", err, l.owner)
+	} else if !acquired {
+		l.validUntil = time.Time{}
+		l.leader = lease.Address
+		if wasLeader {
+			log.Printf("heartbeat: lease taken over: %s, %s, %d", l.owner, lease.Owner, lease.Token)
+		}
+	} else {
+		if lease.Token != l.lease.Token {
+			l.db.Fence(lease)
+		}
+		l.lease = lease
+		l.validUntil = start.Add(l.ttl / 2)
+		l.leader = ""
+		if !wasLeader {
+			log.Printf("heartbeat: acquired lease: %s, %d", l.owner, lease.Token)
+		}
+	}
+	return !wasLeader && time.Now().Before(l.validUntil)
+}
+
+// release gives up the leader lease so that a standby can take over right away
+func (l *leaderElection) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.validUntil.IsZero() {
+		if err := l.db.ReleaseLease(l.lease); err != nil {
+			log.Printf("release: failed to release lease: %v, %s", err, l.owner)
+		} else {
+			log.Printf("release: released lease: %s, %d", l.owner, l.lease.Token)
+		}
+		l.validUntil = time.Time{}
+	}
+}
+
+// IsLeader returns whether this replica is processing jobs. Only the leader accepts API requests that change state.
+func (m *JobManager) IsLeader() bool {
+	return (m.leader == nil) || m.leader.isLeader()
+}
+
+// LeaderAddress returns the address of the leader's API while this replica is a standby, or an empty string if this
+// replica is the leader or the leader isn't known.
+func (m *JobManager) LeaderAddress() string {
+	if m.leader == nil {
+		return ""
+	}
+	return m.leader.leaderAddress()
+}
+
+// runLeaderElection renews the leader lease while this replica is the leader, or tries to take it over while it's a
+// standby, until shutdown.
+func (m *JobManager) runLeaderElection(stopCh chan bool) {
+	tick := time.NewTicker(m.leader.ttl / 3)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-tick.C:
+			// Take over processing right away after acquiring the lease
+			if m.leader.heartbeat() {
+				m.Wake()
+			}
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+// References to the params of upstream stages' jobs, e.g. "${deploy.deployTag}"
+var stageReferenceRegex = regexp.MustCompile(`\$\{([^.{}]+)\.([^{}]+)}`)
+
+// NewPipeline validates and stores a new pipeline. Jobs for stages without dependencies are submitted during the next
+// iteration of the processing loop.
+func (m *JobManager) NewPipeline(pipeline manager.Pipeline) (manager.Pipeline, error) {
+	if err := validatePipeline(pipeline); err != nil {
+		return manager.Pipeline{}, fmt.Errorf("%w: %v", manager.Error_InvalidPipeline, err)
+	}
+	pipeline.Id = uuid.New().String()
+	pipeline.Status = manager.PipelineStatus_Running
+	pipeline.Ts = time.Now()
+	for idx := range pipeline.Stages {
+		pipeline.Stages[idx].Status = manager.PipelineStageStatus_Pending
+		pipeline.Stages[idx].JobId = ""
+		pipeline.Stages[idx].Reason = ""
+	}
+	if err := m.db.WritePipeline(pipeline); err != nil {
+		return manager.Pipeline{}, err
+	}
+	log.Printf("newPipeline: added pipeline: %s, %d stages", pipeline.Id, len(pipeline.Stages))
+	m.Wake()
+	return pipeline, nil
+}
+
+func (m *JobManager) GetPipeline(id string) (manager.Pipeline, error) {
+	if pipeline, found, err := m.db.GetPipeline(id); err != nil {
+		return manager.Pipeline{}, err
+	} else if !found {
+		return manager.Pipeline{}, manager.Error_PipelineNotFound
+	} else {
+		return pipeline, nil
+	}
+}
+
+func (m *JobManager) ActivePipelines() ([]manager.Pipeline, error) {
+	return m.db.ActivePipelines()
+}
+
+// validatePipeline checks that stage names are unique, that dependencies and references are to upstream stages, that
+// there are no cycles, and that each stage's job would be accepted.
+func validatePipeline(pipeline manager.Pipeline) error {
+	if len(pipeline.Stages) == 0 {
+		return fmt.Errorf("no stages")
+	}
+	stages := make(map[string]manager.PipelineStage, len(pipeline.Stages))
+	for _, stage := range pipeline.Stages {
+		if len(stage.Name) == 0 {
+			return fmt.Errorf("missing stage name")
+		} else if _, found := stages[stage.Name]; found {
+			return fmt.Errorf("duplicate stage: %s", stage.Name)
+		}
+		stages[stage.Name] = stage
+	}
+	for _, stage := range pipeline.Stages {
+		for _, dependency := range stageDependencies(stage) {
+			if _, found := stages[dependency]; !found {
+				return fmt.Errorf("unknown dependency for stage %s: %s", stage.Name, dependency)
+			} else if dependency == stage.Name {
+				return fmt.Errorf("stage depends on itself: %s", stage.Name)
+			}
+		}
+	}
+	upstreamStages := make(map[string]map[string]bool, len(stages))
+	for _, stage := range pipeline.Stages {
+		if upstream, err := findUpstreamStages(stage.Name, stages, upstreamStages, map[string]bool{}); err != nil {
+			return err
+		} else {
+			for _, reference := range stageReferences(stage.Params) {
+				if !upstream[reference] {
+					return fmt.Errorf("stage %s references stage that isn't upstream: %s", stage.Name, reference)
+				}
+			}
+		}
+		// Referenced params aren't known yet, but references are strings and so are still checked for the right type.
+		if _, err := job.NormalizeParams(job.JobState{Type: stage.Type, Params: stage.Params}); err != nil {
+			return fmt.Errorf("invalid job for stage %s: %v", stage.Name, err)
+		}
+	}
+	return nil
+}
+
+// findUpstreamStages returns the names of all the stages that a stage directly or indirectly depends on, and fails if
+// the stage depends on itself through a cycle.
+func findUpstreamStages(name string, stages map[string]manager.PipelineStage, found map[string]map[string]bool, visiting map[string]bool) (map[string]bool, error) {
+	if upstream, ok := found[name]; ok {
+		return upstream, nil
+	} else if visiting[name] {
+		return nil, fmt.Errorf("dependency cycle through stage: %s", name)
+	}
+	visiting[name] = true
+	upstream := make(map[string]bool)
+	for _, dependency := range stageDependencies(stages[name]) {
+		upstream[dependency] = true
+		if dependencyUpstream, err := findUpstreamStages(dependency, stages, found, visiting); err != nil {
+			return nil, err
+		} else {
+			for upstreamName := range dependencyUpstream {
+				upstream[upstreamName] = true
+			}
+		}
+	}
+	delete(visiting, name)
+	found[name] = upstream
+	return upstream, nil
+}
+
+func stageDependencies(stage manager.PipelineStage) []string {
+	dependencies := append([]string{}, stage.After...)
+	dependencies = append(dependencies, stage.OnSuccess...)
+	return append(dependencies, stage.OnFailure...)
+}
+
+// stageReferences returns the names of the stages referenced from string params, including nested params, e.g. workflow
+// inputs.
+func stageReferences(params interface{}) []string {
+	references := make([]string, 0, 0)
+	switch p := params.(type) {
+	case string:
+		for _, match := range stageReferenceRegex.FindAllStringSubmatch(p, -1) {
+			references = append(references, match[1])
+		}
+	case map[string]interface{}:
+		for _, v := range p {
+			references = append(references, stageReferences(v)...)
+		}
+	case []interface{}:
+		for _, v := range p {
+			references = append(references, stageReferences(v)...)
+		}
+	}
+	return references
+}
+
+// resolveReferences replaces references to upstream stages' job params with their values. A string consisting of a
+// single reference is replaced with the referenced value as-is, so that non-string params can also be referenced.
+func resolveReferences(params interface{}, stageJobs map[string]job.JobState) (interface{}, error) {
+	switch p := params.(type) {
+	case string:
+		if match := stageReferenceRegex.FindStringSubmatch(p); (match != nil) && (match[0] == p) {
+			return referencedParam(match[1], match[2], stageJobs)
+		}
+		var err error
+		resolved := stageReferenceRegex.ReplaceAllStringFunc(p, func(reference string) string {
+			match := stageReferenceRegex.FindStringSubmatch(reference)
+			value, referenceErr := referencedParam(match[1], match[2], stageJobs)
+			if referenceErr != nil {
+				err = referenceErr
+			}
+			return fmt.Sprintf("%v", value)
+		})
+		return resolved, err
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			if resolvedValue, err := resolveReferences(v, stageJobs); err != nil {
+				return nil, err
+			} else {
+				resolved[k] = resolvedValue
+			}
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(p))
+		for idx, v := range p {
+			if resolvedValue, err := resolveReferences(v, stageJobs); err != nil {
+				return nil, err
+			} else {
+				resolved[idx] = resolvedValue
+			}
+		}
+		return resolved, nil
+	default:
+		return params, nil
+	}
+}
+
+func referencedParam(stageName, param string, stageJobs map[string]job.JobState) (interface{}, error) {
+	if stageJob, found := stageJobs[stageName]; !found {
+		return nil, fmt.Errorf("stage %s did not run", stageName)
+	} else if value, found := stageJob.Params[param]; !found {
+		return nil, fmt.Errorf("stage %s job has no param: %s", stageName, param)
+	} else {
+		return value, nil
+	}
+}
+
+// checkDependencies returns whether all of a stage's dependencies have been met or, if they can no longer all be met,
+// why not.
+func checkDependencies(stage manager.PipelineStage, stages map[string]manager.PipelineStage) (bool, string) {
+	ready := true
+	for _, dependency := range stage.After {
+		if !isFinishedStage(stages[dependency]) {
+			ready = false
+		}
+	}
+	for _, dependency := range stage.OnSuccess {
+		if dependencyStage := stages[dependency]; dependencyStage.Status == manager.PipelineStageStatus_Completed {
+			continue
+		} else if isFinishedStage(dependencyStage) {
+			return false, fmt.Sprintf("stage %s did not succeed", dependency)
+		}
+		ready = false
+	}
+	for _, dependency := range stage.OnFailure {
+		if dependencyStage := stages[dependency]; dependencyStage.Status == manager.PipelineStageStatus_Failed {
+			continue
+		} else if isFinishedStage(dependencyStage) {
+			return false, fmt.Sprintf("stage %s did not fail", dependency)
+		}
+		ready = false
+	}
+	return ready, ""
+}
+
+func isFinishedStage(stage manager.PipelineStage) bool {
+	return (stage.Status != manager.PipelineStageStatus_Pending) && (stage.Status != manager.PipelineStageStatus_Queued)
+}
+
+// processPipelines updates running pipelines with the results of their stages' jobs, then submits jobs for stages whose
+// dependencies have been met.
+func (m *JobManager) processPipelines() {
+	if pipelines, err := m.db.ActivePipelines(); err != nil {
+		log.Printf("processPipelines: failed to lookup pipelines: %v", err)
+	} else {
+		for _, pipeline := range pipelines {
+			m.advancePipeline(pipeline)
+		}
+	}
+}
+
+func (m *JobManager) advancePipeline(pipeline manager.Pipeline) {
+	changed := false
+	stageJobs := make(map[string]job.JobState, len(pipeline.Stages))
+	for idx := range pipeline.Stages {
+		stage := &pipeline.Stages[idx]
+		if len(stage.JobId) == 0 {
+			continue
+		}
+		if jobState, err := m.getJob(stage.JobId); err != nil {
+			// The job index is eventually consistent, so a job that was just submitted might not be found yet.
+			log.Printf("advancePipeline: failed to lookup job: %v, %s, %s, %s", err, pipeline.Id, stage.Name, stage.JobId)
+		} else {
+			stageJobs[stage.Name] = jobState
+			if (stage.Status == manager.PipelineStageStatus_Queued) && job.IsFinishedJob(jobState) {
+				stage.Status = pipelineStageStatus(jobState)
+				stage.Reason, _ = jobState.Params[job.JobParam_Error].(string)
+				changed = true
+				log.Printf("advancePipeline: stage %s: %s, %s", stage.Status, pipeline.Id, stage.Name)
+			}
+		}
+	}
+	// Skipping a stage can resolve the dependencies of downstream stages, so keep going until nothing changes.
+	for progress := true; progress; {
+		progress = false
+		stages := make(map[string]manager.PipelineStage, len(pipeline.Stages))
+		for _, stage := range pipeline.Stages {
+			stages[stage.Name] = stage
+		}
+		for idx := range pipeline.Stages {
+			stage := &pipeline.Stages[idx]
+			if stage.Status != manager.PipelineStageStatus_Pending {
+				continue
+			}
+			if ready, reason := checkDependencies(*stage, stages); len(reason) > 0 {
+				stage.Status = manager.PipelineStageStatus_Skipped
+				stage.Reason = reason
+				progress = true
+			} else if ready {
+				m.startPipelineStage(pipeline.Id, stage, stageJobs)
+				progress = progress || (stage.Status != manager.PipelineStageStatus_Pending)
+			}
+		}
+		changed = changed || progress
+	}
+	if status := pipelineStatus(pipeline); status != pipeline.Status {
+		pipeline.Status = status
+		changed = true
+		log.Printf("advancePipeline: pipeline %s: %s", status, pipeline.Id)
+	}
+	if changed {
+		if err := m.db.WritePipeline(pipeline); err != nil {
+			log.Printf("advancePipeline: failed to update pipeline: %v, %s", err, pipeline.Id)
+		}
+	}
+}
+
+// startPipelineStage submits the job for a stage. The job is submitted with an idempotency key unique to the stage so
+// that only one job is queued for the stage, even if the pipeline fails to be updated and the stage is started again.
+func (m *JobManager) startPipelineStage(pipelineId string, stage *manager.PipelineStage, stageJobs map[string]job.JobState) {
+	if resolvedParams, err := resolveReferences(stage.Params, stageJobs); err != nil {
+		stage.Status = manager.PipelineStageStatus_Failed
+		stage.Reason = err.Error()
+	} else {
+		params, _ := resolvedParams.(map[string]interface{})
+		if params == nil {
+			params = make(map[string]interface{})
+		}
+		params[job.JobParam_Pipeline] = pipelineId
+		params[job.JobParam_PipelineStage] = stage.Name
+		params[job.JobParam_IdempotencyKey] = fmt.Sprintf("pipeline#%s#%s", pipelineId, stage.Name)
+		if jobState, err := m.NewJob(job.JobState{Type: stage.Type, Params: params}); err != nil {
+			log.Printf("startPipelineStage: failed to queue job: %v, %s, %s", err, pipelineId, stage.Name)
+			// Retry during the next iteration of the processing loop unless the job itself is invalid, in which case
+			// retrying won't help.
+			if errors.Is(err, manager.Error_InvalidJob) {
+				stage.Status = manager.PipelineStageStatus_Failed
+				stage.Reason = err.Error()
+			}
+		} else {
+			stage.Status = manager.PipelineStageStatus_Queued
+			stage.JobId = jobState.JobId
+			log.Printf("startPipelineStage: queued job for stage: %s, %s, %s", pipelineId, stage.Name, manager.PrintJob(jobState))
+		}
+	}
+}
+
+func pipelineStageStatus(jobState job.JobState) manager.PipelineStageStatus {
+	switch jobState.Stage {
+	case job.JobStage_Completed:
+		return manager.PipelineStageStatus_Completed
+	case job.JobStage_Failed:
+		return manager.PipelineStageStatus_Failed
+	case job.JobStage_Canceled:
+		return manager.PipelineStageStatus_Canceled
+	default:
+		return manager.PipelineStageStatus_Skipped
+	}
+}
+
+// pipelineStatus returns "running" while any stage hasn't finished. Finished pipelines have "failed" if any stage failed,
+// "canceled" if any stage's job was canceled, and "completed" otherwise, e.g. if only "onFailure" stages were skipped.
+func pipelineStatus(pipeline manager.Pipeline) manager.PipelineStatus {
+	status := manager.PipelineStatus_Completed
+	for _, stage := range pipeline.Stages {
+		if !isFinishedStage(stage) {
+			return manager.PipelineStatus_Running
+		} else if stage.Status == manager.PipelineStageStatus_Failed {
+			status = manager.PipelineStatus_Failed
+		} else if (stage.Status == manager.PipelineStageStatus_Canceled) && (status == manager.PipelineStatus_Completed) {
+			status = manager.PipelineStatus_Canceled
+		}
+	}
+	return status
+}
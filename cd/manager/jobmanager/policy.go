@@ -0,0 +1,135 @@
+package jobmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+const workflowLabelClassPrefix = "workflow:"
+
+// v5 anchor workers are scaled by the v5 Scheduler, so they don't count towards the anchor worker limit.
+const v5AnchorClass = "anchor:v5"
+
+// defaultSchedulerPolicy returns the policy the job manager has always used:
+//   - deploys with non-overlapping layouts (compatible with anchor jobs)
+//   - smoke and E2E tests, but not during deployments, including workflows with a "deploy" label
+//   - one workflow at a time (compatible with anchor jobs)
+//   - up to the configured number of anchor workers (compatible with any other type of job)
+func defaultSchedulerPolicy(maxAnchorJobs int) manager.SchedulerPolicy {
+	nonDeployClasses := []string{
+		string(job.JobType_TestE2E),
+		string(job.JobType_TestSmoke),
+		string(job.JobType_Workflow),
+	}
+	nonAnchorClasses := append([]string{string(job.JobType_Deploy)}, nonDeployClasses...)
+	deployClasses := []string{
+		string(job.JobType_Deploy),
+		workflowLabelClassPrefix + job.WorkflowJobLabel_Deploy,
+	}
+	return manager.SchedulerPolicy{
+		// Deploys are also serialized with any deploys in progress that touch the same services (see `processDeployJobs`)
+		string(job.JobType_Deploy):    {BlockedBy: nonDeployClasses, MaxConcurrency: -1},
+		string(job.JobType_TestE2E):   {BlockedBy: deployClasses, MaxConcurrency: -1},
+		string(job.JobType_TestSmoke): {BlockedBy: deployClasses, MaxConcurrency: -1},
+		string(job.JobType_Workflow):  {BlockedBy: nonAnchorClasses, MaxConcurrency: 1},
+		string(job.JobType_Anchor):    {MaxConcurrency: maxAnchorJobs},
+	}
+}
+
+// loadSchedulerPolicy returns the default policy, with policies for individual classes replaced by any configured in the
+// environment, e.g. SCHEDULER_POLICY='{"workflow":{"blockedBy":["deploy"],"maxConcurrency":2}}'.
+func loadSchedulerPolicy(maxAnchorJobs int) (manager.SchedulerPolicy, error) {
+	policy := defaultSchedulerPolicy(maxAnchorJobs)
+	if configPolicy, found := os.LookupEnv("SCHEDULER_POLICY"); found {
+		overrides := manager.SchedulerPolicy{}
+		if err := json.Unmarshal([]byte(configPolicy), &overrides); err != nil {
+			return nil, fmt.Errorf("loadSchedulerPolicy: invalid policy: %v", err)
+		}
+		for class, classPolicy := range overrides {
+			policy[class] = classPolicy
+		}
+	}
+	return policy, nil
+}
+
+// defaultRetryPolicies retries all types of jobs twice after transient failures. Deploys back off for longer since ECS
+// throttling can take a while to clear.
+func defaultRetryPolicies() manager.RetryPolicies {
+	return manager.RetryPolicies{
+		job.JobType_Deploy:    {MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: 10 * time.Minute},
+		job.JobType_Anchor:    {MaxAttempts: 3, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute},
+		job.JobType_TestE2E:   {MaxAttempts: 3, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute},
+		job.JobType_TestSmoke: {MaxAttempts: 3, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute},
+		job.JobType_Workflow:  {MaxAttempts: 3, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute},
+	}
+}
+
+// loadRetryPolicies returns the default retry policies, with policies for individual job types replaced by any
+// configured in the environment, e.g. RETRY_POLICY='{"deploy":{"maxAttempts":5,"backoff":"30s","maxBackoff":"5m"}}'.
+func loadRetryPolicies() (manager.RetryPolicies, error) {
+	policies := defaultRetryPolicies()
+	if configPolicies, found := os.LookupEnv("RETRY_POLICY"); found {
+		overrides := manager.RetryPolicies{}
+		if err := json.Unmarshal([]byte(configPolicies), &overrides); err != nil {
+			return nil, fmt.Errorf("loadRetryPolicies: invalid policy: %v", err)
+		}
+		for jobType, policy := range overrides {
+			policies[jobType] = policy
+		}
+	}
+	return policies, nil
+}
+
+// jobClasses returns the classes a job belongs to for the purposes of the scheduler policy
+func jobClasses(jobState job.JobState) []string {
+	if manager.IsV5WorkerJob(jobState) {
+		return []string{v5AnchorClass}
+	}
+	classes := []string{string(jobState.Type)}
+	if jobState.Type == job.JobType_Workflow {
+		if workflow, err := job.CreateWorkflowJob(jobState); err == nil {
+			for _, label := range workflow.Labels {
+				classes = append(classes, workflowLabelClassPrefix+label)
+			}
+		}
+	}
+	return classes
+}
+
+// checkPolicy returns whether a job can be started under the scheduler policy and, if not, why not and which jobs are
+// preventing it from starting. Jobs being started in the same iteration of the processing loop, or by workers from an
+// earlier iteration, are treated as active.
+//
+// Jobs are "blocked" while they can't run alongside any of the active jobs, and "waiting" while their class is at its
+// concurrency limit.
+func (m *JobManager) checkPolicy(jobState job.JobState, startingJobs ...job.JobState) (manager.SchedulerAction, string, []job.JobState) {
+	activeJobs := append(append(m.cache.JobsByMatcher(job.IsActiveJob), m.startingJobs()...), startingJobs...)
+	for _, class := range jobClasses(jobState) {
+		if classPolicy, found := m.policy[class]; found {
+			blockingJobs := make([]job.JobState, 0, 0)
+			classJobs := make([]job.JobState, 0, 0)
+			for _, activeJob := range activeJobs {
+				activeClasses := jobClasses(activeJob)
+				if slices.IndexFunc(activeClasses, func(c string) bool { return slices.Contains(classPolicy.BlockedBy, c) }) != -1 {
+					blockingJobs = append(blockingJobs, activeJob)
+				}
+				if slices.Contains(activeClasses, class) {
+					classJobs = append(classJobs, activeJob)
+				}
+			}
+			if len(blockingJobs) > 0 {
+				return manager.SchedulerAction_Blocked, fmt.Sprintf("%s jobs blocked by active jobs", class), blockingJobs
+			} else if (classPolicy.MaxConcurrency >= 0) && (len(classJobs) >= classPolicy.MaxConcurrency) {
+				return manager.SchedulerAction_Waiting, fmt.Sprintf("%s concurrency limit %d reached", class, classPolicy.MaxConcurrency), classJobs
+			}
+		}
+	}
+	return manager.SchedulerAction_Started, "", nil
+}
@@ -0,0 +1,90 @@
+package jobmanager
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+func testJob(jobType job.JobType, stage job.JobStage, params map[string]interface{}) job.JobState {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	return job.JobState{JobId: uuid.New().String(), Stage: stage, Type: jobType, Params: params}
+}
+
+func testWorkflowJob(stage job.JobStage, labels ...interface{}) job.JobState {
+	params := map[string]interface{}{
+		job.WorkflowJobParam_Org:      "3box",
+		job.WorkflowJobParam_Repo:     "ceramic-tests",
+		job.WorkflowJobParam_Ref:      "main",
+		job.WorkflowJobParam_Workflow: "run-durable.yml",
+	}
+	if len(labels) > 0 {
+		params[job.WorkflowJobParam_Labels] = labels
+	}
+	return testJob(job.JobType_Workflow, stage, params)
+}
+
+func TestCheckPolicy(t *testing.T) {
+	deploy := func(stage job.JobStage) job.JobState { return testJob(job.JobType_Deploy, stage, nil) }
+	anchor := func(stage job.JobStage) job.JobState { return testJob(job.JobType_Anchor, stage, nil) }
+	v5Anchor := func(stage job.JobStage) job.JobState {
+		return testJob(job.JobType_Anchor, stage, map[string]interface{}{job.AnchorJobParam_Version: "5"})
+	}
+	e2eTest := func(stage job.JobStage) job.JobState { return testJob(job.JobType_TestE2E, stage, nil) }
+	smokeTest := func(stage job.JobStage) job.JobState { return testJob(job.JobType_TestSmoke, stage, nil) }
+
+	tests := []struct {
+		name         string
+		jobState     job.JobState
+		activeJobs   []job.JobState
+		inFlightJobs []job.JobState
+		startingJobs []job.JobState
+		action       manager.SchedulerAction
+		numRelated   int
+	}{
+		{"deploy with nothing active", deploy(job.JobStage_Dequeued), nil, nil, nil, manager.SchedulerAction_Started, 0},
+		{"deploy alongside anchors", deploy(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started), anchor(job.JobStage_Waiting)}, nil, nil, manager.SchedulerAction_Started, 0},
+		// Deploys alongside other deploys are only limited by their layouts (see `overlappingDeploys`)
+		{"deploy alongside deploy", deploy(job.JobStage_Dequeued), []job.JobState{deploy(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Started, 0},
+		{"deploy during tests", deploy(job.JobStage_Dequeued), []job.JobState{e2eTest(job.JobStage_Started), smokeTest(job.JobStage_Waiting)}, nil, nil, manager.SchedulerAction_Blocked, 2},
+		{"deploy during workflow", deploy(job.JobStage_Dequeued), []job.JobState{testWorkflowJob(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Blocked, 1},
+		{"test during deploy", e2eTest(job.JobStage_Dequeued), []job.JobState{deploy(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Blocked, 1},
+		{"test during deploy workflow", smokeTest(job.JobStage_Dequeued), []job.JobState{testWorkflowJob(job.JobStage_Started, job.WorkflowJobLabel_Deploy)}, nil, nil, manager.SchedulerAction_Blocked, 1},
+		{"test during other workflow", smokeTest(job.JobStage_Dequeued), []job.JobState{testWorkflowJob(job.JobStage_Started, "other")}, nil, nil, manager.SchedulerAction_Started, 0},
+		{"test starting alongside deploy", e2eTest(job.JobStage_Dequeued), nil, nil, []job.JobState{deploy(job.JobStage_Dequeued)}, manager.SchedulerAction_Blocked, 1},
+		{"workflow alongside workflow", testWorkflowJob(job.JobStage_Dequeued), []job.JobState{testWorkflowJob(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Blocked, 1},
+		{"workflow alongside anchor", testWorkflowJob(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Started, 0},
+		{"anchor under limit", anchor(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Started, 0},
+		{"anchor at limit", anchor(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started), anchor(job.JobStage_Waiting)}, nil, nil, manager.SchedulerAction_Waiting, 2},
+		{"anchor at limit with starting anchor", anchor(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started)}, nil, []job.JobState{anchor(job.JobStage_Dequeued)}, manager.SchedulerAction_Waiting, 2},
+		// Jobs being started by workers from an earlier iteration count as active
+		{"anchor at limit with in-flight anchor", anchor(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started)}, []job.JobState{anchor(job.JobStage_Dequeued)}, nil, manager.SchedulerAction_Waiting, 2},
+		{"anchor alongside v5 anchors", anchor(job.JobStage_Dequeued), []job.JobState{v5Anchor(job.JobStage_Started), v5Anchor(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Started, 0},
+		{"v5 anchor alongside anchors", v5Anchor(job.JobStage_Dequeued), []job.JobState{anchor(job.JobStage_Started), anchor(job.JobStage_Started)}, nil, nil, manager.SchedulerAction_Started, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &JobManager{cache: common.NewJobCache(), policy: defaultSchedulerPolicy(2), inFlight: new(sync.Map)}
+			for _, activeJob := range append(test.activeJobs, test.inFlightJobs...) {
+				m.cache.WriteJob(activeJob)
+			}
+			for _, inFlightJob := range test.inFlightJobs {
+				m.inFlight.Store(inFlightJob.JobId, true)
+			}
+			if action, reason, relatedJobs := m.checkPolicy(test.jobState, test.startingJobs...); action != test.action {
+				t.Fatalf("expected action: %s, found: %s, %s", test.action, action, reason)
+			} else if len(relatedJobs) != test.numRelated {
+				t.Fatalf("expected %d related jobs, found: %s", test.numRelated, manager.PrintJob(relatedJobs...))
+			} else if (action != manager.SchedulerAction_Started) && (len(reason) == 0) {
+				t.Fatalf("expected a reason for not starting the job")
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+// queueUpdate represents a change to a waiting job that will be applied during the next iteration of the processing
+// loop, so that it doesn't race with the job being dequeued or started.
+type queueUpdate struct {
+	version  int64        // Version of the job when the update was requested
+	stage    job.JobStage // Stage of the job when the update was requested
+	jobState job.JobState // Updated state of the job, or a "skipped" state if the job is to be skipped
+}
+
+// Jobs can't be scheduled further ahead than this
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// Scheduled records can take a moment to show up in searches, so leave a margin for records that came due just before a
+// search for scheduled jobs.
+const scheduledCursorMargin = manager.DefaultTick
+
+// UpdateJob registers changes to a job that is waiting to be processed. The returned state is what the job will look
+// like once the changes are applied.
+func (m *JobManager) UpdateJob(jobId string, update manager.JobUpdate) (job.JobState, error) {
+	jobState, err := m.getJob(jobId)
+	if err != nil {
+		return job.JobState{}, err
+	} else if !job.IsWaitingJob(jobState) {
+		return jobState, manager.Error_JobNotQueued
+	}
+	version, stage := job.JobVersion(jobState), jobState.Stage
+	// Apply the changes on top of any pending changes for the same job
+	if value, found := m.updates.Load(jobId); found {
+		if pendingUpdate := value.(queueUpdate); (pendingUpdate.version == version) && (pendingUpdate.stage == stage) {
+			if pendingUpdate.jobState.Stage == job.JobStage_Skipped {
+				return jobState, manager.Error_JobNotQueued
+			}
+			jobState = pendingUpdate.jobState
+		}
+	}
+	if jobState, err = applyJobUpdate(jobState, update); err != nil {
+		return job.JobState{}, err
+	}
+	m.updates.Store(jobId, queueUpdate{version, stage, jobState})
+	log.Printf("updateJob: update requested: %s", manager.PrintJob(jobState))
+	m.Wake()
+	return jobState, nil
+}
+
+func applyJobUpdate(jobState job.JobState, update manager.JobUpdate) (job.JobState, error) {
+	// Copy the params so that we don't modify the cached state of the job
+	params := make(map[string]interface{}, len(jobState.Params))
+	for k, v := range jobState.Params {
+		params[k] = v
+	}
+	if len(update.NotBefore) > 0 {
+		// Dequeued jobs are ordered relative to jobs that have already been processed, and so can't be rescheduled.
+		if jobState.Stage == job.JobStage_Dequeued {
+			return job.JobState{}, fmt.Errorf("%w: only scheduled or queued jobs can be rescheduled", manager.Error_JobNotQueued)
+		}
+		params[job.JobParam_NotBefore] = update.NotBefore
+	}
+	if update.Priority != nil {
+		params[job.JobParam_Priority] = *update.Priority
+	}
+	if len(update.Params) > 0 {
+		// Dequeued jobs have already been prepared using their params, e.g. deployment targets have been resolved.
+		if jobState.Stage == job.JobStage_Dequeued {
+			return job.JobState{}, fmt.Errorf("%w: only scheduled or queued jobs can be edited", manager.Error_JobNotQueued)
+		}
+		for k, v := range update.Params {
+			if !slices.Contains(job.MutableParams[jobState.Type], k) {
+				return job.JobState{}, fmt.Errorf("%w: param cannot be edited: %s", manager.Error_InvalidJob, k)
+			} else if v == nil {
+				delete(params, k)
+			} else {
+				params[k] = v
+			}
+		}
+	}
+	jobState.Params = params
+	if params, err := job.NormalizeParams(jobState); err != nil {
+		return job.JobState{}, fmt.Errorf("%w: %v", manager.Error_InvalidJob, err)
+	} else {
+		jobState.Params = params
+	}
+	if jobState.Stage == job.JobStage_Dequeued {
+		return jobState, nil
+	}
+	return scheduleJob(jobState, time.Now())
+}
+
+// scheduleJob moves a job that hasn't been dequeued yet to the "scheduled" stage if it can't be processed until later,
+// or to the "queued" stage otherwise. Scheduled jobs are queued once they come due (see `processScheduledJobs`).
+func scheduleJob(jobState job.JobState, now time.Time) (job.JobState, error) {
+	if notBefore, found := job.NotBefore(jobState); found && notBefore.After(now) {
+		if notBefore.After(now.Add(maxScheduleAhead)) {
+			return job.JobState{}, fmt.Errorf("%w: cannot schedule jobs more than %s ahead", manager.Error_InvalidJob, maxScheduleAhead)
+		}
+		jobState.Stage = job.JobStage_Scheduled
+		jobState.Ts = notBefore
+	} else {
+		// Jobs that came due, or were rescheduled into the past, are run as soon as possible.
+		if (jobState.Stage == job.JobStage_Scheduled) || jobState.Ts.After(now) {
+			jobState.Ts = now
+		}
+		jobState.Stage = job.JobStage_Queued
+	}
+	return jobState, nil
+}
+
+// SkipJobs registers requests to skip all waiting jobs that match the filter, and returns the jobs that will be skipped.
+func (m *JobManager) SkipJobs(filter manager.JobFilter) ([]job.JobState, error) {
+	if waitingJobs, err := m.waitingJobs(); err != nil {
+		return nil, err
+	} else {
+		skippedJobs := make([]job.JobState, 0, 0)
+		for _, jobState := range waitingJobs {
+			if filter.Matches(jobState) {
+				skippedJob := jobState
+				skippedJob.Stage = job.JobStage_Skipped
+				m.updates.Store(jobState.JobId, queueUpdate{job.JobVersion(jobState), jobState.Stage, skippedJob})
+				log.Printf("skipJobs: skip requested: %s", manager.PrintJob(jobState))
+				skippedJobs = append(skippedJobs, jobState)
+			}
+		}
+		if len(skippedJobs) > 0 {
+			m.Wake()
+		}
+		return skippedJobs, nil
+	}
+}
+
+// ScheduledJobs returns the latest state of all jobs that are scheduled to be queued later, in order of when they come
+// due.
+func (m *JobManager) ScheduledJobs() ([]job.JobState, error) {
+	if waitingJobs, err := m.waitingJobs(); err != nil {
+		return nil, err
+	} else {
+		scheduledJobs := make([]job.JobState, 0, 0)
+		for _, jobState := range waitingJobs {
+			if jobState.Stage == job.JobStage_Scheduled {
+				scheduledJobs = append(scheduledJobs, jobState)
+			}
+		}
+		return scheduledJobs, nil
+	}
+}
+
+// waitingJobs returns the latest state of all jobs that are waiting to be processed, including jobs scheduled to be
+// queued later, in order of their timestamps.
+func (m *JobManager) waitingJobs() ([]job.JobState, error) {
+	now := time.Now()
+	queuedJobs := make(map[string]job.JobState)
+	for _, jobStage := range []job.JobStage{job.JobStage_Scheduled, job.JobStage_Queued} {
+		filter := manager.JobFilter{
+			Stage: jobStage,
+			Start: now.AddDate(0, 0, -manager.DefaultTtlDays),
+			End:   now.Add(maxScheduleAhead),
+			Asc:   true,
+			Limit: manager.MaxJobPageSize,
+		}
+		for {
+			if page, err := m.db.ListJobs(filter); err != nil {
+				return nil, err
+			} else {
+				for _, jobState := range page.Jobs {
+					// Jobs in the cache have already been dequeued. Jobs updated while waiting have a record for each
+					// version, e.g. scheduled jobs that came due have a record for each stage, so only keep the latest.
+					if _, found := m.cache.JobById(jobState.JobId); found {
+						continue
+					} else if queuedJob, found := queuedJobs[jobState.JobId]; !found || job.IsLaterJobState(jobState, queuedJob) {
+						queuedJobs[jobState.JobId] = jobState
+					}
+				}
+				if len(page.Cursor) == 0 {
+					break
+				}
+				filter.Cursor = page.Cursor
+			}
+		}
+	}
+	waitingJobs := append(maps.Values(queuedJobs), m.cache.JobsByMatcher(func(js job.JobState) bool {
+		return js.Stage == job.JobStage_Dequeued
+	})...)
+	sort.SliceStable(waitingJobs, func(i, j int) bool {
+		return waitingJobs[i].Ts.Before(waitingJobs[j].Ts)
+	})
+	return waitingJobs, nil
+}
+
+// effectivePriority returns the priority of a waiting job, raised by one level for every aging interval the job has been
+// waiting.
+func (m *JobManager) effectivePriority(jobState job.JobState, now time.Time) int64 {
+	priority := job.JobPriority(jobState)
+	if now.After(jobState.Ts) {
+		priority += int64(now.Sub(jobState.Ts) / m.priorityAging)
+	}
+	return priority
+}
+
+func (m *JobManager) processQueueUpdates() {
+	now := time.Now()
+	m.updates.Range(func(key, value interface{}) bool {
+		update := value.(queueUpdate)
+		// Wait for the job's worker to finish, e.g. if the job is being dequeued, so that the update doesn't race with it
+		if m.isInFlight(update.jobState.JobId) {
+			return true
+		}
+		m.updates.Delete(key)
+		if latestJob, err := m.getJob(update.jobState.JobId); err != nil {
+			log.Printf("processQueueUpdates: failed to lookup job: %v, %s", err, manager.PrintJob(update.jobState))
+		} else if !job.IsWaitingJob(latestJob) || (job.JobVersion(latestJob) != update.version) {
+			// Don't apply the changes if the job has started processing, or was changed in some other way, since the
+			// changes were requested.
+			log.Printf("processQueueUpdates: job changed since update was requested: %s", manager.PrintJob(latestJob))
+		} else if update.jobState.Stage == job.JobStage_Skipped {
+			if err = m.updateJobStage(latestJob, job.JobStage_Skipped, nil); err != nil {
+				log.Printf("processQueueUpdates: job update failed: %v, %s", err, manager.PrintJob(latestJob))
+			}
+		} else if latestJob.Stage != update.stage {
+			log.Printf("processQueueUpdates: job changed since update was requested: %s", manager.PrintJob(latestJob))
+		} else {
+			jobState := update.jobState
+			// Jobs scheduled for a time that has passed since the update was requested are queued instead
+			if (jobState.Stage == job.JobStage_Scheduled) && !jobState.Ts.After(now) {
+				jobState.Stage = job.JobStage_Queued
+			}
+			ts := jobState.Ts
+			// Jobs rescheduled into the past are run as soon as possible instead. Queued jobs are searched for starting
+			// from the earliest job not yet processed, so a record further in the past might never be found.
+			if (jobState.Stage == job.JobStage_Queued) && !ts.Equal(latestJob.Ts) && ts.Before(now) {
+				ts = now
+			}
+			if _, err = manager.AdvanceJob(jobState, jobState.Stage, ts, nil, m.db, m.notifs); err != nil {
+				log.Printf("processQueueUpdates: job update failed: %v, %s", err, manager.PrintJob(jobState))
+			}
+		}
+		return true
+	})
+}
+
+// processScheduledJobs queues scheduled jobs that have come due. Jobs are queued with the current time so that they are
+// found by the search for newly queued jobs, which only looks for jobs queued after the earliest job not yet processed.
+//
+// Only scheduled records that came due since the previous search are looked at. The search starts from the earliest job
+// that couldn't be queued, or from just before the previous search if all jobs were queued.
+func (m *JobManager) processScheduledJobs() {
+	now := time.Now()
+	cursor := m.scheduledCursor
+	if ttlCursor := now.AddDate(0, 0, -manager.DefaultTtlDays); cursor.Before(ttlCursor) {
+		cursor = ttlCursor
+	}
+	if dueJobs, err := m.dueScheduledJobs(cursor, now); err != nil {
+		log.Printf("processScheduledJobs: failed to lookup scheduled jobs: %v", err)
+	} else {
+		cursor = now.Add(-scheduledCursorMargin)
+		for _, scheduledJob := range dueJobs {
+			// The latest scheduled record found might not be the job's current state, e.g. if the job was skipped, so
+			// check the current state before queueing the job.
+			if latestJob, found, err := m.db.GetJob(scheduledJob.JobId); err != nil {
+				log.Printf("processScheduledJobs: failed to lookup job: %v, %s", err, manager.PrintJob(scheduledJob))
+				// Retry during the next iteration
+				if scheduledJob.Ts.Before(cursor) {
+					cursor = scheduledJob.Ts
+				}
+			} else if !found || (latestJob.Stage != job.JobStage_Scheduled) || (job.JobVersion(latestJob) != job.JobVersion(scheduledJob)) {
+				// Jobs changed in the meantime, e.g. rescheduled or skipped, no longer need to be queued
+				log.Printf("processScheduledJobs: job changed since it was scheduled: %s", manager.PrintJob(scheduledJob))
+			} else if _, err = manager.AdvanceJob(latestJob, job.JobStage_Queued, now, nil, m.db, m.notifs); err != nil {
+				log.Printf("processScheduledJobs: failed to queue job: %v, %s", err, manager.PrintJob(latestJob))
+				// Jobs changed since they were looked up no longer need to be queued either. Retry the others during the
+				// next iteration.
+				if !errors.Is(err, manager.Error_JobConflict) && scheduledJob.Ts.Before(cursor) {
+					cursor = scheduledJob.Ts
+				}
+			}
+		}
+		m.scheduledCursor = cursor
+	}
+	// Records are in order of when they come due, so the first one still in the future is the next one due. It might
+	// have been replaced by a later version, in which case we'll just look for scheduled jobs a little early.
+	if page, err := m.db.ListJobs(manager.JobFilter{
+		Stage: job.JobStage_Scheduled,
+		Start: now,
+		End:   now.Add(maxScheduleAhead),
+		Asc:   true,
+		Limit: 1,
+	}); err != nil {
+		log.Printf("processScheduledJobs: failed to lookup next scheduled job: %v", err)
+	} else if len(page.Jobs) > 0 {
+		m.dueAt(page.Jobs[0].Ts)
+	}
+}
+
+// dueScheduledJobs returns the latest state of jobs with scheduled records in the (inclusive) time range, in order of
+// when they came due. Jobs rescheduled while waiting have a record for each version, so only the latest is kept.
+func (m *JobManager) dueScheduledJobs(start, end time.Time) ([]job.JobState, error) {
+	filter := manager.JobFilter{
+		Stage: job.JobStage_Scheduled,
+		Start: start,
+		End:   end,
+		Asc:   true,
+		Limit: manager.MaxJobPageSize,
+	}
+	scheduledJobs := make(map[string]job.JobState)
+	for {
+		if page, err := m.db.ListJobs(filter); err != nil {
+			return nil, err
+		} else {
+			for _, jobState := range page.Jobs {
+				// Jobs in the cache have already been dequeued
+				if _, found := m.cache.JobById(jobState.JobId); found {
+					continue
+				} else if scheduledJob, found := scheduledJobs[jobState.JobId]; !found || job.IsLaterJobState(jobState, scheduledJob) {
+					scheduledJobs[jobState.JobId] = jobState
+				}
+			}
+			if len(page.Cursor) == 0 {
+				break
+			}
+			filter.Cursor = page.Cursor
+		}
+	}
+	dueJobs := maps.Values(scheduledJobs)
+	sort.SliceStable(dueJobs, func(i, j int) bool {
+		return dueJobs[i].Ts.Before(dueJobs[j].Ts)
+	})
+	return dueJobs, nil
+}
@@ -0,0 +1,127 @@
+package jobmanager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+// schedulerLog records why each waiting job was or wasn't started during an iteration of the processing loop. Decisions
+// are collected while the iteration is in progress, then published once it completes so that readers always see the
+// results of a full iteration.
+type schedulerLog struct {
+	mu        sync.RWMutex
+	ts        time.Time
+	paused    bool
+	decisions []manager.SchedulerDecision
+	// Decisions for the iteration in progress, only accessed from the processing loop
+	pendingTs        time.Time
+	pendingPaused    bool
+	pendingDecisions map[string]manager.SchedulerDecision
+	pendingOrder     []string
+}
+
+func newSchedulerLog() *schedulerLog {
+	return &schedulerLog{pendingDecisions: make(map[string]manager.SchedulerDecision)}
+}
+
+func (s *schedulerLog) start(ts time.Time, paused bool) {
+	s.pendingTs = ts
+	s.pendingPaused = paused
+	s.pendingDecisions = make(map[string]manager.SchedulerDecision)
+	s.pendingOrder = nil
+}
+
+// record stores the decision made for a job in the current iteration, replacing any earlier decision for the same job.
+func (s *schedulerLog) record(jobState job.JobState, action manager.SchedulerAction, reason string, relatedJobs ...job.JobState) {
+	decision := manager.SchedulerDecision{
+		JobId:  jobState.JobId,
+		Type:   jobState.Type,
+		Action: action,
+		Reason: reason,
+		Ts:     s.pendingTs,
+	}
+	for _, relatedJob := range relatedJobs {
+		decision.RelatedJobs = append(decision.RelatedJobs, relatedJob.JobId)
+	}
+	if _, found := s.pendingDecisions[jobState.JobId]; !found {
+		s.pendingOrder = append(s.pendingOrder, jobState.JobId)
+	}
+	s.pendingDecisions[jobState.JobId] = decision
+}
+
+// finish publishes the decisions for the current iteration. Dequeued jobs that no rule made a decision for were left
+// waiting behind other jobs.
+func (s *schedulerLog) finish(dequeuedJobs []job.JobState) {
+	for _, dequeuedJob := range dequeuedJobs {
+		if _, found := s.pendingDecisions[dequeuedJob.JobId]; !found {
+			s.record(dequeuedJob, manager.SchedulerAction_Waiting, "waiting behind other jobs")
+		}
+	}
+	decisions := make([]manager.SchedulerDecision, len(s.pendingOrder))
+	for idx, jobId := range s.pendingOrder {
+		decisions[idx] = s.pendingDecisions[jobId]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ts = s.pendingTs
+	s.paused = s.pendingPaused
+	s.decisions = decisions
+}
+
+func (s *schedulerLog) decision(jobId string) (manager.SchedulerDecision, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, decision := range s.decisions {
+		if decision.JobId == jobId {
+			return decision, true
+		}
+	}
+	return manager.SchedulerDecision{}, false
+}
+
+// ExplainJob returns the decision made for a job during the latest iteration of the processing loop or, if the job
+// wasn't considered, why not.
+func (m *JobManager) ExplainJob(jobId string) (manager.SchedulerDecision, error) {
+	jobState, err := m.getJob(jobId)
+	if err != nil {
+		return manager.SchedulerDecision{}, err
+	}
+	if decision, found := m.scheduler.decision(jobId); found {
+		return decision, nil
+	}
+	decision := manager.SchedulerDecision{JobId: jobState.JobId, Type: jobState.Type, Ts: time.Now()}
+	if job.IsActiveJob(jobState) {
+		decision.Action = manager.SchedulerAction_Started
+		decision.Reason = fmt.Sprintf("job is %s", jobState.Stage)
+	} else if !job.IsWaitingJob(jobState) {
+		decision.Action = manager.SchedulerAction_Finished
+		decision.Reason = fmt.Sprintf("job is %s", jobState.Stage)
+	} else if jobState.Stage == job.JobStage_Scheduled {
+		decision.Action = manager.SchedulerAction_Waiting
+		decision.Reason = fmt.Sprintf("job is scheduled for %s", jobState.Ts.Format(time.RFC3339))
+	} else if m.paused.Load() {
+		decision.Action = manager.SchedulerAction_Waiting
+		decision.Reason = "job manager is paused"
+	} else {
+		decision.Action = manager.SchedulerAction_Waiting
+		decision.Reason = "job has not been evaluated yet"
+	}
+	return decision, nil
+}
+
+// SchedulerSummary returns the active jobs, and the decisions made for waiting jobs during the latest iteration of the
+// processing loop.
+func (m *JobManager) SchedulerSummary() manager.SchedulerSummary {
+	m.scheduler.mu.RLock()
+	defer m.scheduler.mu.RUnlock()
+	return manager.SchedulerSummary{
+		Ts:         m.scheduler.ts,
+		Paused:     m.scheduler.paused,
+		ActiveJobs: m.cache.JobsByMatcher(job.IsActiveJob),
+		Decisions:  append([]manager.SchedulerDecision{}, m.scheduler.decisions...),
+	}
+}
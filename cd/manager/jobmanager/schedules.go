@@ -0,0 +1,137 @@
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+// Number of times to retry enabling/disabling a schedule if it's fired at the same time
+const scheduleUpdateRetries = 3
+
+func (m *JobManager) ListSchedules() ([]manager.JobSchedule, error) {
+	return m.db.GetSchedules()
+}
+
+// NewSchedule validates and stores a new schedule. New schedules are enabled, and first run at the next time matching
+// their cron spec.
+func (m *JobManager) NewSchedule(schedule manager.JobSchedule) (manager.JobSchedule, error) {
+	if len(schedule.Id) == 0 {
+		schedule.Id = uuid.New().String()
+	}
+	spec, err := parseCronSpec(schedule.Spec)
+	if err != nil {
+		return manager.JobSchedule{}, fmt.Errorf("%w: %v", manager.Error_InvalidSchedule, err)
+	}
+	// Check that the job would be accepted, but store the params as-is so that they're normalized when each job is
+	// submitted, e.g. so that relative start times are relative to when each job is queued.
+	if _, err = job.NormalizeParams(job.JobState{Type: schedule.Type, Params: schedule.Params}); err != nil {
+		return manager.JobSchedule{}, fmt.Errorf("%w: %v", manager.Error_InvalidJob, err)
+	}
+	schedule.Enabled = true
+	schedule.NextRun = spec.next(time.Now())
+	schedule.LastRun = time.Time{}
+	schedule.LastJobId = ""
+	if err = m.db.AddSchedule(schedule); err != nil {
+		return manager.JobSchedule{}, err
+	}
+	log.Printf("newSchedule: added schedule: %s, %s, %s", schedule.Id, schedule.Spec, schedule.Type)
+	m.Wake()
+	return schedule, nil
+}
+
+// EnableSchedule enables or disables a schedule. Runs missed while a schedule was disabled are not made up for, i.e. a
+// re-enabled schedule next runs at the next time matching its cron spec.
+func (m *JobManager) EnableSchedule(id string, enabled bool) (manager.JobSchedule, error) {
+	for i := 0; i < scheduleUpdateRetries; i++ {
+		if schedule, found, err := m.db.GetSchedule(id); err != nil {
+			return manager.JobSchedule{}, err
+		} else if !found {
+			return manager.JobSchedule{}, manager.Error_ScheduleNotFound
+		} else if schedule.Enabled == enabled {
+			return schedule, nil
+		} else if spec, err := parseCronSpec(schedule.Spec); err != nil {
+			return manager.JobSchedule{}, fmt.Errorf("%w: %v", manager.Error_InvalidSchedule, err)
+		} else {
+			nextRun := schedule.NextRun
+			schedule.Enabled = enabled
+			if enabled {
+				schedule.NextRun = spec.next(time.Now())
+			}
+			// The schedule might have fired since we looked it up, in which case look it up again and retry.
+			if updated, err := m.db.UpdateSchedule(schedule, nextRun); err != nil {
+				return manager.JobSchedule{}, err
+			} else if updated {
+				log.Printf("enableSchedule: schedule updated: %s, %t", schedule.Id, enabled)
+				m.Wake()
+				return schedule, nil
+			}
+		}
+	}
+	return manager.JobSchedule{}, fmt.Errorf("enableSchedule: schedule changed while updating: %s", id)
+}
+
+// processSchedules queues jobs for enabled schedules that are due. Jobs are queued even if the job manager is paused,
+// just like jobs submitted through the API.
+func (m *JobManager) processSchedules() {
+	now := time.Now()
+	if schedules, err := m.db.GetSchedules(); err != nil {
+		log.Printf("processSchedules: failed to lookup schedules: %v", err)
+	} else {
+		for _, schedule := range schedules {
+			if !schedule.Enabled {
+				continue
+			} else if schedule.NextRun.After(now) {
+				m.dueAt(schedule.NextRun)
+			} else {
+				m.fireSchedule(schedule, now)
+			}
+		}
+	}
+}
+
+// fireSchedule queues a job for a schedule that is due, then advances the schedule to its next run.
+//
+// Schedules are safe against double-firing, e.g. by multiple CD manager instances, or if the job manager restarts after
+// queueing a job but before advancing the schedule. Each run is queued with an idempotency key unique to the run so that
+// at most one job is queued per run, and the schedule is only advanced if no one else already advanced it.
+func (m *JobManager) fireSchedule(schedule manager.JobSchedule, now time.Time) {
+	runTs := schedule.NextRun
+	spec, err := parseCronSpec(schedule.Spec)
+	if err != nil {
+		log.Printf("fireSchedule: invalid schedule: %v, %s", err, schedule.Id)
+		return
+	}
+	params := make(map[string]interface{}, len(schedule.Params)+2)
+	for k, v := range schedule.Params {
+		params[k] = v
+	}
+	params[job.JobParam_Schedule] = schedule.Id
+	params[job.JobParam_IdempotencyKey] = fmt.Sprintf("schedule#%s#%d", schedule.Id, runTs.Unix())
+	if jobState, err := m.NewJob(job.JobState{Type: schedule.Type, Params: params}); err != nil {
+		log.Printf("fireSchedule: failed to queue job: %v, %s", err, schedule.Id)
+		// Retry during the next iteration of the processing loop unless the job itself is invalid, in which case
+		// retrying won't help.
+		if !errors.Is(err, manager.Error_InvalidJob) {
+			return
+		}
+	} else {
+		log.Printf("fireSchedule: queued job for schedule: %s, %s", schedule.Id, manager.PrintJob(jobState))
+		schedule.LastRun = runTs
+		schedule.LastJobId = jobState.JobId
+	}
+	// Runs missed while the job manager was down are not made up for, i.e. the schedule skips to the next run after now.
+	schedule.NextRun = spec.next(now)
+	m.dueAt(schedule.NextRun)
+	if updated, err := m.db.UpdateSchedule(schedule, runTs); err != nil {
+		log.Printf("fireSchedule: failed to advance schedule: %v, %s", err, schedule.Id)
+	} else if !updated {
+		log.Printf("fireSchedule: schedule already advanced: %s", schedule.Id)
+	}
+}
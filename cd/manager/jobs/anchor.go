@@ -20,8 +20,8 @@ type anchorJob struct {
 	d   manager.Deployment
 }
 
-func AnchorJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, d manager.Deployment) manager.JobSm {
-	return &anchorJob{baseJob{jobState, db, notifs}, os.Getenv(manager.EnvVar_Env), d}
+func AnchorJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, retryPolicy manager.RetryPolicy, d manager.Deployment) manager.JobSm {
+	return &anchorJob{baseJob{jobState, db, notifs, retryPolicy}, os.Getenv(manager.EnvVar_Env), d}
 }
 
 func (a anchorJob) Advance() (job.JobState, error) {
@@ -38,7 +38,7 @@ func (a anchorJob) Advance() (job.JobState, error) {
 	case job.JobStage_Dequeued:
 		{
 			if taskId, err := a.launchWorker(); err != nil {
-				return a.advance(job.JobStage_Failed, now, err)
+				return a.fail(now, err)
 			} else {
 				// Record the worker task identifier and its start time
 				a.state.Params[job.JobParam_Id] = taskId
@@ -49,7 +49,7 @@ func (a anchorJob) Advance() (job.JobState, error) {
 	case job.JobStage_Started:
 		{
 			if started, err := a.checkWorker(true); err != nil {
-				return a.advance(job.JobStage_Failed, now, err)
+				return a.fail(now, err)
 			} else if started {
 				return a.advance(job.JobStage_Waiting, now, nil)
 			} else {
@@ -60,7 +60,7 @@ func (a anchorJob) Advance() (job.JobState, error) {
 	case job.JobStage_Waiting:
 		{
 			if stopped, err := a.checkWorker(false); err != nil {
-				return a.advance(job.JobStage_Failed, now, err)
+				return a.fail(now, err)
 			} else if stopped {
 				return a.advance(job.JobStage_Completed, now, nil)
 			} else if delayed, _ := a.state.Params[job.AnchorJobParam_Delayed].(bool); !delayed && job.IsTimedOut(a.state, AnchorStalledTime/2) {
@@ -83,15 +83,23 @@ func (a anchorJob) Advance() (job.JobState, error) {
 	}
 }
 
+func (a anchorJob) Cancel() (job.JobState, error) {
+	// Stop the worker task if it has already been launched
+	var err error = nil
+	if taskId, found := a.state.Params[job.JobParam_Id].(string); found {
+		err = a.d.StopTask("ceramic-"+a.env+"-cas", taskId)
+	}
+	return a.advance(job.JobStage_Canceled, time.Now(), err)
+}
+
 func (a anchorJob) launchWorker() (string, error) {
 	var overrides map[string]string = nil
 	// Check if this is a CASv5 anchor job
 	if manager.IsV5WorkerJob(a.state) {
-		if parsedOverrides, found := a.state.Params[job.AnchorJobParam_Overrides].(map[string]interface{}); found {
-			overrides = make(map[string]string, len(parsedOverrides))
-			for k, v := range parsedOverrides {
-				overrides[k] = v.(string)
-			}
+		if params, err := job.DecodeAnchorParams(a.state.Params); err != nil {
+			return "", err
+		} else {
+			overrides = params.Overrides
 		}
 	}
 	if taskId, err := a.d.LaunchTask(
@@ -107,7 +115,9 @@ func (a anchorJob) launchWorker() (string, error) {
 }
 
 func (a anchorJob) checkWorker(expectedToBeRunning bool) (bool, error) {
-	if status, exitCode, err := a.d.CheckTask("ceramic-"+a.env+"-cas", "", expectedToBeRunning, false, a.state.Params[job.JobParam_Id].(string)); err != nil {
+	// The task ID should have been filled in by this point
+	taskId, _ := a.state.Params[job.JobParam_Id].(string)
+	if status, exitCode, err := a.d.CheckTask("ceramic-"+a.env+"-cas", "", expectedToBeRunning, false, taskId); err != nil {
 		return false, err
 	} else if status {
 		// If a non-zero exit code was present, the worker failed to complete successfully.
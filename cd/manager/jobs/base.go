@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"log"
 	"time"
 
 	"github.com/3box/pipeline-tools/cd/manager"
@@ -8,11 +9,39 @@ import (
 )
 
 type baseJob struct {
-	state  job.JobState
-	db     manager.Database
-	notifs manager.Notifs
+	state       job.JobState
+	db          manager.Database
+	notifs      manager.Notifs
+	retryPolicy manager.RetryPolicy
 }
 
 func (b baseJob) advance(jobStage job.JobStage, ts time.Time, err error) (job.JobState, error) {
+	// A job that moves on after a retry no longer needs to wait
+	if (jobStage != b.state.Stage) && (job.JobAttempts(b.state) > 0) {
+		delete(b.state.Params, job.JobParam_RetryAt)
+	}
 	return manager.AdvanceJob(b.state, jobStage, ts, err, b.db, b.notifs)
 }
+
+// fail moves the job to the "failed" stage, unless the error is transient and the job's retry policy allows another
+// attempt. Each failed attempt is recorded in the job's history.
+//
+// Jobs that failed while being prepared for processing are requeued, and jobs that failed at a later stage retry the
+// same stage once the backoff has elapsed.
+func (b baseJob) fail(ts time.Time, err error) (job.JobState, error) {
+	attempts := job.JobAttempts(b.state) + 1
+	if !isTransientError(err) || (attempts >= int64(b.retryPolicy.MaxAttempts)) {
+		return b.advance(job.JobStage_Failed, ts, err)
+	}
+	retryAt := ts.Add(b.retryPolicy.Delay(attempts))
+	b.state.Params[job.JobParam_Attempts] = attempts
+	log.Printf("fail: retrying after transient failure: %v, %s, %s", err, retryAt.Format(time.RFC3339), manager.PrintJob(b.state))
+	if b.state.Stage == job.JobStage_Queued {
+		// Queued jobs are only present in the database, so schedule the job to be queued again once the backoff has
+		// elapsed.
+		b.state.Params[job.JobParam_NotBefore] = retryAt.Format(time.RFC3339Nano)
+		return b.advance(job.JobStage_Scheduled, retryAt, err)
+	}
+	b.state.Params[job.JobParam_RetryAt] = retryAt.Format(time.RFC3339Nano)
+	return b.advance(b.state.Stage, ts, err)
+}
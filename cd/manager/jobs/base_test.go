@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+	"github.com/3box/pipeline-tools/cd/manager/common/mem"
+)
+
+type testNotifs struct{}
+
+func (testNotifs) NotifyJob(...job.JobState) {}
+
+func TestFail(t *testing.T) {
+	retryPolicy := manager.RetryPolicy{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: 10 * time.Minute}
+	transientErr := &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+	permanentErr := errors.New("invalid deploy tag")
+	tests := []struct {
+		name     string
+		stage    job.JobStage
+		attempts int64
+		err      error
+		// Expected stage and delay after the failure, with no delay for jobs that aren't retried
+		expectedStage job.JobStage
+		expectedDelay time.Duration
+	}{
+		{"requeue after failing while queued", job.JobStage_Queued, 0, transientErr, job.JobStage_Scheduled, time.Minute},
+		{"requeue with backoff", job.JobStage_Queued, 1, transientErr, job.JobStage_Scheduled, 2 * time.Minute},
+		{"retry in stage", job.JobStage_Started, 0, transientErr, job.JobStage_Started, time.Minute},
+		{"retry in stage with backoff", job.JobStage_Waiting, 1, transientErr, job.JobStage_Waiting, 2 * time.Minute},
+		{"no attempts left", job.JobStage_Started, 2, transientErr, job.JobStage_Failed, 0},
+		{"permanent error while queued", job.JobStage_Queued, 0, permanentErr, job.JobStage_Failed, 0},
+		{"permanent error in stage", job.JobStage_Started, 0, permanentErr, job.JobStage_Failed, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Now()
+			jobState := job.JobState{JobId: "job", Stage: test.stage, Type: job.JobType_Deploy, Ts: now.Add(-time.Minute), Params: map[string]interface{}{}}
+			if test.attempts > 0 {
+				jobState.Params[job.JobParam_Attempts] = float64(test.attempts)
+			}
+			b := baseJob{jobState, mem.NewMemoryDb(common.NewJobCache()), testNotifs{}, retryPolicy}
+			failedJob, err := b.fail(now, test.err)
+			if err != nil {
+				t.Fatalf("failed to update job: %v", err)
+			} else if failedJob.Stage != test.expectedStage {
+				t.Fatalf("expected stage: %s, found: %s", test.expectedStage, failedJob.Stage)
+			} else if jobError, _ := failedJob.Params[job.JobParam_Error].(string); jobError != test.err.Error() {
+				t.Fatalf("expected error to be recorded: %q, found: %q", test.err, jobError)
+			}
+			retryAt, retrying := job.RetryAt(failedJob)
+			notBefore, requeued := job.NotBefore(failedJob)
+			switch {
+			case test.expectedStage == job.JobStage_Failed:
+				if retrying || requeued {
+					t.Fatalf("expected failed job not to be retried: %s", manager.PrintJob(failedJob))
+				}
+			case test.expectedStage == job.JobStage_Scheduled:
+				// Queued jobs are scheduled to be queued again once the backoff has elapsed
+				if !requeued || !notBefore.Equal(now.Add(test.expectedDelay)) || !failedJob.Ts.Equal(notBefore) {
+					t.Fatalf("expected job to be requeued after %s: %s", test.expectedDelay, manager.PrintJob(failedJob))
+				} else if retrying {
+					t.Fatalf("expected requeued job not to wait in stage: %s", manager.PrintJob(failedJob))
+				}
+			default:
+				// Jobs at a later stage wait to retry the same stage
+				if !retrying || !retryAt.Equal(now.Add(test.expectedDelay)) || !failedJob.Ts.Equal(now) {
+					t.Fatalf("expected job to be retried after %s: %s", test.expectedDelay, manager.PrintJob(failedJob))
+				}
+			}
+			if test.expectedStage != job.JobStage_Failed {
+				if attempts := job.JobAttempts(failedJob); attempts != test.attempts+1 {
+					t.Fatalf("expected attempts: %d, found: %d", test.attempts+1, attempts)
+				}
+			}
+		})
+	}
+}
@@ -56,19 +56,11 @@ const (
 
 const defaultFailureTime = 30 * time.Minute
 
-func DeployJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, d manager.Deployment, repo manager.Repository) (manager.JobSm, error) {
-	if component, found := jobState.Params[job.DeployJobParam_Component].(string); !found {
-		return nil, fmt.Errorf("deployJob: missing component (ceramic, ipfs, cas, casv5, rust-ceramic)")
-	} else if sha, found := jobState.Params[job.DeployJobParam_Sha].(string); !found {
-		return nil, fmt.Errorf("deployJob: missing target")
-	} else if shaTag, found := jobState.Params[job.DeployJobParam_ShaTag].(string); !found {
-		return nil, fmt.Errorf("deployJob: missing tag")
+func DeployJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, retryPolicy manager.RetryPolicy, d manager.Deployment, repo manager.Repository) (manager.JobSm, error) {
+	if params, err := job.DecodeDeployParams(jobState.Params); err != nil {
+		return nil, fmt.Errorf("deployJob: invalid params: %v", err)
 	} else {
-		deployTag, _ := jobState.Params[job.DeployJobParam_DeployTag].(string)
-		manual, _ := jobState.Params[job.DeployJobParam_Manual].(bool)
-		rollback, _ := jobState.Params[job.DeployJobParam_Rollback].(bool)
-		force, _ := jobState.Params[job.DeployJobParam_Force].(bool)
-		return &deployJob{baseJob{jobState, db, notifs}, manager.DeployComponent(component), sha, shaTag, deployTag, manual, rollback, force, os.Getenv(manager.EnvVar_Env), d, repo}, nil
+		return &deployJob{baseJob{jobState, db, notifs, retryPolicy}, manager.DeployComponent(params.Component), params.Sha, params.ShaTag, params.DeployTag, params.Manual, params.Rollback, params.Force, os.Getenv(manager.EnvVar_Env), d, repo}, nil
 	}
 }
 
@@ -78,9 +70,9 @@ func (d deployJob) Advance() (job.JobState, error) {
 	case job.JobStage_Queued:
 		{
 			if deployTags, err := d.db.GetDeployTags(); err != nil {
-				return d.advance(job.JobStage_Failed, now, err)
+				return d.fail(now, err)
 			} else if err = d.prepareJob(); err != nil {
-				return d.advance(job.JobStage_Failed, now, err)
+				return d.fail(now, err)
 			} else if deployTag, found := d.state.Params[job.DeployJobParam_DeployTag].(string); found &&
 				!d.manual && !d.force &&
 				(deployTag == strings.Split(deployTags[d.component], ",")[0]) {
@@ -92,7 +84,7 @@ func (d deployJob) Advance() (job.JobState, error) {
 				// already checking for force deploys.
 				return d.advance(job.JobStage_Skipped, now, nil)
 			} else if envLayout, err := d.generateEnvLayout(d.component); err != nil {
-				return d.advance(job.JobStage_Failed, now, err)
+				return d.fail(now, err)
 			} else {
 				d.state.Params[job.DeployJobParam_Layout] = *envLayout
 				// Advance the timestamp by a tiny amount so that the "dequeued" event remains at the same position on
@@ -103,7 +95,7 @@ func (d deployJob) Advance() (job.JobState, error) {
 	case job.JobStage_Dequeued:
 		{
 			if err := d.updateEnv(); err != nil {
-				return d.advance(job.JobStage_Failed, now, err)
+				return d.fail(now, err)
 			} else {
 				d.state.Params[job.JobParam_Start] = float64(time.Now().UnixNano())
 				// For started deployments update the build tag in the DB
@@ -117,7 +109,7 @@ func (d deployJob) Advance() (job.JobState, error) {
 	case job.JobStage_Started:
 		{
 			if deployed, err := d.checkEnv(); err != nil {
-				return d.advance(job.JobStage_Failed, now, err)
+				return d.fail(now, err)
 			} else if deployed {
 				// For completed deployments update the deployed tag in the DB, and append the deployment target.
 				if err = d.db.UpdateDeployTag(d.component, d.deployTag+","+d.sha); err != nil {
@@ -143,6 +135,12 @@ func (d deployJob) Advance() (job.JobState, error) {
 	}
 }
 
+func (d deployJob) Cancel() (job.JobState, error) {
+	// ECS service deployments already in progress can't be stopped cleanly, so leave them be. Canceling the job only
+	// means that we stop tracking the deployment.
+	return d.advance(job.JobStage_Canceled, time.Now(), nil)
+}
+
 func (d deployJob) prepareJob() error {
 	deployTag := ""
 	// - If the specified deployment target is "latest", fetch the latest branch commit hash from GitHub.
@@ -17,15 +17,15 @@ type e2eTestJob struct {
 }
 
 const (
-	e2eTest_PrivatePublic     string = "private-public"
-	e2eTest_LocalClientPublic string = "local_client-public"
+	e2eTest_PrivatePublic     string = job.E2eTestJobParam_PrivatePublic
+	e2eTest_LocalClientPublic string = job.E2eTestJobParam_LocalClientPublic
 )
 
 // Allow up to 4 hours for E2E tests to run
 const e2eFailureTime = 4 * time.Hour
 
-func E2eTestJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, d manager.Deployment) manager.JobSm {
-	return &e2eTestJob{baseJob{jobState, db, notifs}, d}
+func E2eTestJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, retryPolicy manager.RetryPolicy, d manager.Deployment) manager.JobSm {
+	return &e2eTestJob{baseJob{jobState, db, notifs, retryPolicy}, d}
 }
 
 func (e e2eTestJob) Advance() (job.JobState, error) {
@@ -42,7 +42,7 @@ func (e e2eTestJob) Advance() (job.JobState, error) {
 	case job.JobStage_Dequeued:
 		{
 			if err := e.startAllTests(); err != nil {
-				return e.advance(job.JobStage_Failed, now, err)
+				return e.fail(now, err)
 			} else {
 				e.state.Params[job.JobParam_Start] = float64(time.Now().UnixNano())
 				return e.advance(job.JobStage_Started, now, nil)
@@ -51,7 +51,7 @@ func (e e2eTestJob) Advance() (job.JobState, error) {
 	case job.JobStage_Started:
 		{
 			if running, err := e.checkAllTests(true); err != nil {
-				return e.advance(job.JobStage_Failed, now, err)
+				return e.fail(now, err)
 			} else if running {
 				return e.advance(job.JobStage_Waiting, now, nil)
 			} else if job.IsTimedOut(e.state, manager.DefaultWaitTime) { // Tests did not start in time
@@ -64,7 +64,7 @@ func (e e2eTestJob) Advance() (job.JobState, error) {
 	case job.JobStage_Waiting:
 		{
 			if stopped, err := e.checkAllTests(false); err != nil {
-				return e.advance(job.JobStage_Failed, now, err)
+				return e.fail(now, err)
 			} else if stopped {
 				return e.advance(job.JobStage_Completed, now, nil)
 			} else if job.IsTimedOut(e.state, e2eFailureTime) { // Tests did not finish in time
@@ -81,6 +81,20 @@ func (e e2eTestJob) Advance() (job.JobState, error) {
 	}
 }
 
+func (e e2eTestJob) Cancel() (job.JobState, error) {
+	// Stop any test tasks that have already been launched. Attempt to stop all of them even if one fails, but report the
+	// first error encountered.
+	var err error = nil
+	for _, config := range []string{e2eTest_PrivatePublic, e2eTest_LocalClientPublic} {
+		if taskId, found := e.state.Params[config].(string); found {
+			if stopErr := e.d.StopTask("ceramic-qa-tests", taskId); (stopErr != nil) && (err == nil) {
+				err = stopErr
+			}
+		}
+	}
+	return e.advance(job.JobStage_Canceled, time.Now(), err)
+}
+
 func (e e2eTestJob) startAllTests() error {
 	if err := e.startTests(e2eTest_PrivatePublic); err != nil {
 		return err
@@ -113,9 +127,12 @@ func (e e2eTestJob) startTests(config string) error {
 }
 
 func (e e2eTestJob) checkAllTests(expectedToBeRunning bool) (bool, error) {
-	if privatePublicStatus, err := e.checkTests(e.state.Params[e2eTest_PrivatePublic].(string), expectedToBeRunning); err != nil {
+	// The task IDs should have been filled in by this point
+	privatePublicTaskId, _ := e.state.Params[e2eTest_PrivatePublic].(string)
+	localClientPublicTaskId, _ := e.state.Params[e2eTest_LocalClientPublic].(string)
+	if privatePublicStatus, err := e.checkTests(privatePublicTaskId, expectedToBeRunning); err != nil {
 		return false, err
-	} else if localClientPublicStatus, err := e.checkTests(e.state.Params[e2eTest_LocalClientPublic].(string), expectedToBeRunning); err != nil {
+	} else if localClientPublicStatus, err := e.checkTests(localClientPublicTaskId, expectedToBeRunning); err != nil {
 		return false, err
 	} else if privatePublicStatus && localClientPublicStatus {
 		return true, nil
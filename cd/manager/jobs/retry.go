@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+
+	"github.com/google/go-github/v56/github"
+)
+
+// isTransientError returns true for errors that are likely to go away if the failed operation is retried, e.g. AWS
+// throttling, GitHub rate limiting, 5xx responses, and connection errors. All other errors are considered permanent.
+func isTransientError(err error) bool {
+	var rateLimitErr *github.RateLimitError
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	var githubErr *github.ErrorResponse
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseRateLimitErr) {
+		return true
+	} else if errors.As(err, &githubErr) && (githubErr.Response != nil) {
+		return (githubErr.Response.StatusCode >= http.StatusInternalServerError) || (githubErr.Response.StatusCode == http.StatusTooManyRequests)
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// The AWS SDK already retries these errors a few times with a short backoff, but they might still go away with a
+	// longer one.
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
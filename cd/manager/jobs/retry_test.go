@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v56/github"
+)
+
+// awsError looks like the API errors returned by the AWS SDK, which are classified by their error code or HTTP status
+type awsError struct {
+	code   string
+	status int
+}
+
+func (e awsError) Error() string {
+	return fmt.Sprintf("api error %s: %d", e.code, e.status)
+}
+
+func (e awsError) ErrorCode() string {
+	return e.code
+}
+
+func (e awsError) HTTPStatusCode() int {
+	return e.status
+}
+
+func TestIsTransientError(t *testing.T) {
+	githubError := func(status int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: status}}
+	}
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"github 500", githubError(http.StatusInternalServerError), true},
+		{"github 502", githubError(http.StatusBadGateway), true},
+		{"github 503", githubError(http.StatusServiceUnavailable), true},
+		{"github 429", githubError(http.StatusTooManyRequests), true},
+		{"github rate limit", &github.RateLimitError{Response: &http.Response{StatusCode: http.StatusForbidden}}, true},
+		{"github abuse rate limit", &github.AbuseRateLimitError{Response: &http.Response{StatusCode: http.StatusForbidden}}, true},
+		{"wrapped github 503", fmt.Errorf("failed to check workflow: %w", githubError(http.StatusServiceUnavailable)), true},
+		{"github 404", githubError(http.StatusNotFound), false},
+		{"github 422", githubError(http.StatusUnprocessableEntity), false},
+		{"github without response", &github.ErrorResponse{}, false},
+		{"aws throttling", awsError{code: "ThrottlingException", status: http.StatusBadRequest}, true},
+		{"aws throughput exceeded", awsError{code: "ProvisionedThroughputExceededException", status: http.StatusBadRequest}, true},
+		{"aws request timeout", awsError{code: "RequestTimeout", status: http.StatusBadRequest}, true},
+		{"aws 503", awsError{code: "ServiceUnavailable", status: http.StatusServiceUnavailable}, true},
+		{"aws access denied", awsError{code: "AccessDeniedException", status: http.StatusBadRequest}, false},
+		{"aws validation", awsError{code: "ValidationException", status: http.StatusBadRequest}, false},
+		{"deadline exceeded", fmt.Errorf("request failed: %w", context.DeadlineExceeded), true},
+		{"canceled", context.Canceled, false},
+		{"other error", errors.New("invalid deploy tag"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if transient := isTransientError(test.err); transient != test.transient {
+				t.Fatalf("expected transient: %t, found: %t", test.transient, transient)
+			}
+		})
+	}
+}
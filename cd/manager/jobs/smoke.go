@@ -25,8 +25,8 @@ type smokeTestJob struct {
 	d   manager.Deployment
 }
 
-func SmokeTestJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, d manager.Deployment) manager.JobSm {
-	return &smokeTestJob{baseJob{jobState, db, notifs}, os.Getenv(manager.EnvVar_Env), d}
+func SmokeTestJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, retryPolicy manager.RetryPolicy, d manager.Deployment) manager.JobSm {
+	return &smokeTestJob{baseJob{jobState, db, notifs, retryPolicy}, os.Getenv(manager.EnvVar_Env), d}
 }
 
 func (s smokeTestJob) Advance() (job.JobState, error) {
@@ -43,7 +43,7 @@ func (s smokeTestJob) Advance() (job.JobState, error) {
 	case job.JobStage_Dequeued:
 		{
 			if id, err := s.d.LaunchTask(ClusterName, FamilyPrefix+s.env, ContainerName, NetworkConfigurationParameter, nil); err != nil {
-				return s.advance(job.JobStage_Failed, now, err)
+				return s.fail(now, err)
 			} else {
 				// Update the job stage and spawned task identifier
 				s.state.Params[job.JobParam_Id] = id
@@ -54,7 +54,7 @@ func (s smokeTestJob) Advance() (job.JobState, error) {
 	case job.JobStage_Started:
 		{
 			if started, err := s.checkTests(true); err != nil {
-				return s.advance(job.JobStage_Failed, now, err)
+				return s.fail(now, err)
 			} else if started {
 				return s.advance(job.JobStage_Waiting, now, nil)
 			} else {
@@ -65,7 +65,7 @@ func (s smokeTestJob) Advance() (job.JobState, error) {
 	case job.JobStage_Waiting:
 		{
 			if stopped, err := s.checkTests(false); err != nil {
-				return s.advance(job.JobStage_Failed, now, err)
+				return s.fail(now, err)
 			} else if stopped {
 				return s.advance(job.JobStage_Completed, now, nil)
 			} else {
@@ -80,8 +80,19 @@ func (s smokeTestJob) Advance() (job.JobState, error) {
 	}
 }
 
+func (s smokeTestJob) Cancel() (job.JobState, error) {
+	// Stop the test task if it has already been launched
+	var err error = nil
+	if taskId, found := s.state.Params[job.JobParam_Id].(string); found {
+		err = s.d.StopTask(ClusterName, taskId)
+	}
+	return s.advance(job.JobStage_Canceled, time.Now(), err)
+}
+
 func (s smokeTestJob) checkTests(expectedToBeRunning bool) (bool, error) {
-	if status, exitCode, err := s.d.CheckTask(ClusterName, "", expectedToBeRunning, false, s.state.Params[job.JobParam_Id].(string)); err != nil {
+	// The task ID should have been filled in by this point
+	taskId, _ := s.state.Params[job.JobParam_Id].(string)
+	if status, exitCode, err := s.d.CheckTask(ClusterName, "", expectedToBeRunning, false, taskId); err != nil {
 		return false, err
 	} else if status {
 		// If a non-zero exit code was present, the test failed to complete successfully.
@@ -29,7 +29,7 @@ type githubWorkflowJob struct {
 	r        manager.Repository
 }
 
-func GitHubWorkflowJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, r manager.Repository) (manager.JobSm, error) {
+func GitHubWorkflowJob(jobState job.JobState, db manager.Database, notifs manager.Notifs, retryPolicy manager.RetryPolicy, r manager.Repository) (manager.JobSm, error) {
 	if workflow, err := job.CreateWorkflowJob(jobState); err != nil {
 		return nil, err
 	} else {
@@ -52,7 +52,7 @@ func GitHubWorkflowJob(jobState job.JobState, db manager.Database, notifs manage
 			httpClient = oauth2.NewClient(context.Background(), ts)
 		}
 
-		return &githubWorkflowJob{baseJob{jobState, db, notifs}, workflow, env, github.NewClient(httpClient), r}, nil
+		return &githubWorkflowJob{baseJob{jobState, db, notifs, retryPolicy}, workflow, env, github.NewClient(httpClient), r}, nil
 	}
 }
 
@@ -70,7 +70,7 @@ func (w githubWorkflowJob) Advance() (job.JobState, error) {
 	case job.JobStage_Dequeued:
 		{
 			if err := w.r.StartWorkflow(w.workflow); err != nil {
-				return w.advance(job.JobStage_Failed, now, err)
+				return w.fail(now, err)
 			} else {
 				w.state.Params[job.JobParam_Start] = float64(time.Now().UnixNano())
 				return w.advance(job.JobStage_Started, now, nil)
@@ -80,9 +80,10 @@ func (w githubWorkflowJob) Advance() (job.JobState, error) {
 		{
 			// The start time should have been filled in by this point. Limit the search to runs after the start of the
 			// job (minus 30 seconds, so we avoid any races).
-			searchTime := time.Unix(0, int64(w.state.Params[job.JobParam_Start].(float64))).Add(-30 * time.Second)
+			start, _ := w.state.Params[job.JobParam_Start].(float64)
+			searchTime := time.Unix(0, int64(start)).Add(-30 * time.Second)
 			if workflowRunId, workflowRunUrl, err := w.r.FindMatchingWorkflowRun(w.workflow, w.state.JobId, searchTime); err != nil {
-				return w.advance(job.JobStage_Failed, now, err)
+				return w.fail(now, err)
 			} else if workflowRunId != -1 {
 				// Record workflow details and advance the job
 				w.state.Params[job.JobParam_Id] = float64(workflowRunId)
@@ -100,7 +101,7 @@ func (w githubWorkflowJob) Advance() (job.JobState, error) {
 			// The workflow run ID should have been filled in by this point
 			workflowRunId, _ := w.state.Params[job.JobParam_Id].(float64)
 			if status, err := w.r.CheckWorkflowStatus(w.workflow, int64(workflowRunId)); err != nil {
-				return w.advance(job.JobStage_Failed, now, err)
+				return w.fail(now, err)
 			} else if status == manager.WorkflowStatus_Success {
 				return w.advance(job.JobStage_Completed, now, nil)
 			} else if status == manager.WorkflowStatus_Failure {
@@ -120,3 +121,25 @@ func (w githubWorkflowJob) Advance() (job.JobState, error) {
 		}
 	}
 }
+
+func (w githubWorkflowJob) Cancel() (job.JobState, error) {
+	now := time.Now()
+	// The workflow run ID is only recorded once the job reaches the "waiting" stage. If the workflow was started but
+	// the corresponding run hasn't been found yet, try to find it now so that it can be canceled.
+	workflowRunId := w.workflow.Id
+	if (workflowRunId == 0) && (w.state.Stage == job.JobStage_Started) {
+		if start, found := w.state.Params[job.JobParam_Start].(float64); found {
+			searchTime := time.Unix(0, int64(start)).Add(-30 * time.Second)
+			if foundRunId, _, err := w.r.FindMatchingWorkflowRun(w.workflow, w.state.JobId, searchTime); err != nil {
+				return w.advance(job.JobStage_Canceled, now, err)
+			} else if foundRunId != -1 {
+				workflowRunId = foundRunId
+			}
+		}
+	}
+	var err error = nil
+	if workflowRunId > 0 {
+		err = w.r.CancelWorkflow(w.workflow, workflowRunId)
+	}
+	return w.advance(job.JobStage_Canceled, now, err)
+}
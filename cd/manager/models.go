@@ -12,6 +12,9 @@ const DefaultTtlDays = 1
 const DefaultHttpWaitTime = 30 * time.Second
 const DefaultHttpRetries = 3
 const DefaultWaitTime = 5 * time.Minute
+const DefaultJobPageSize = 100
+const MaxJobPageSize = 1000
+const DefaultIdempotencyWindow = 24 * time.Hour
 
 type EnvType string
 
@@ -53,6 +56,19 @@ const (
 var (
 	Error_StartupTimeout    = fmt.Errorf("startup timeout")
 	Error_CompletionTimeout = fmt.Errorf("completion timeout")
+	Error_JobNotFound       = fmt.Errorf("job not found")
+	Error_JobNotCancelable  = fmt.Errorf("job not cancelable")
+	Error_InvalidCursor     = fmt.Errorf("invalid cursor")
+	Error_InvalidJob        = fmt.Errorf("invalid job")
+	Error_JobNotQueued      = fmt.Errorf("job not queued")
+	Error_JobNotFinished    = fmt.Errorf("job not finished")
+	Error_ScheduleNotFound  = fmt.Errorf("schedule not found")
+	Error_ScheduleExists    = fmt.Errorf("schedule already exists")
+	Error_InvalidSchedule   = fmt.Errorf("invalid schedule")
+	Error_PipelineNotFound  = fmt.Errorf("pipeline not found")
+	Error_InvalidPipeline   = fmt.Errorf("invalid pipeline")
+	Error_NotLeader         = fmt.Errorf("not the leader")
+	Error_JobConflict       = fmt.Errorf("job updated concurrently")
 )
 
 const (
@@ -100,9 +116,170 @@ type Task struct {
 	Name string `dynamodbav:"name,omitempty"` // Container name
 }
 
+// JobFilter represents criteria for searching the job records in the Database. Empty fields match all jobs.
+type JobFilter struct {
+	Type      job.JobType
+	Stage     job.JobStage
+	Component DeployComponent
+	Sha       string // Matches the deployment target, or the resolved commit hash (or its prefix) being deployed
+	Source    string
+	Start     time.Time
+	End       time.Time
+	Asc       bool   // Return jobs in ascending order of timestamp, i.e. oldest first
+	Limit     int    // Maximum number of jobs to return
+	Cursor    string // Opaque cursor returned with a previous page of results
+}
+
+// JobPage represents a single page of job records returned from a Database search
+type JobPage struct {
+	Jobs   []job.JobState `json:"jobs"`
+	Cursor string         `json:"cursor,omitempty"` // Empty if there are no more results
+}
+
+// JobHistoryEntry represents a single stage record from the history of a job
+type JobHistoryEntry struct {
+	Stage    job.JobStage           `json:"stage"`
+	Ts       time.Time              `json:"ts"`
+	Error    string                 `json:"error,omitempty"`
+	WaitTime string                 `json:"waitTime,omitempty"` // Time spent in the previous stage
+	Params   map[string]interface{} `json:"params,omitempty"`   // Params added or changed since the previous stage
+	Removed  []string               `json:"removed,omitempty"`  // Params removed since the previous stage
+}
+
+// JobUpdate represents changes to a job that is waiting to be processed. Nil or empty fields are left unchanged.
+type JobUpdate struct {
+	NotBefore string                 `json:"notBefore,omitempty"` // Only scheduled or queued jobs can be rescheduled, see `job.ParseNotBefore`
+	Priority  *int64                 `json:"priority,omitempty"`  // Jobs with higher priorities are processed first
+	Params    map[string]interface{} `json:"params,omitempty"`    // Only scheduled or queued jobs can be edited, a nil value removes the param
+}
+
+// JobPolicy describes when jobs of a particular class can be started. A job belongs to the class named after its type
+// and, for workflows, to a "workflow:<label>" class for each of its labels. A job can only be started if it satisfies
+// the policies for all of its classes.
+type JobPolicy struct {
+	BlockedBy      []string `json:"blockedBy,omitempty"` // Classes of active jobs that prevent this class from starting
+	MaxConcurrency int      `json:"maxConcurrency"`      // Maximum number of active jobs of this class, -1 (default) for no limit
+}
+
+// SchedulerPolicy maps job classes to their policies. Classes without a policy can always be started.
+type SchedulerPolicy map[string]JobPolicy
+
+// RetryPolicy describes how jobs of a particular type are retried after transient failures, e.g. throttling or 5xx
+// errors from AWS or GitHub. The delay before each retry doubles from the initial backoff up to the maximum backoff.
+type RetryPolicy struct {
+	MaxAttempts int           `json:"maxAttempts"` // Including the first attempt, i.e. 1 disables retries
+	Backoff     time.Duration `json:"backoff"`
+	MaxBackoff  time.Duration `json:"maxBackoff"`
+}
+
+// RetryPolicies maps job types to their retry policies. Jobs without a policy aren't retried.
+type RetryPolicies map[job.JobType]RetryPolicy
+
+type SchedulerAction string
+
+const (
+	SchedulerAction_Started   SchedulerAction = "started"
+	SchedulerAction_Blocked   SchedulerAction = "blocked"
+	SchedulerAction_Collapsed SchedulerAction = "collapsed"
+	SchedulerAction_Skipped   SchedulerAction = "skipped"
+	SchedulerAction_Waiting   SchedulerAction = "waiting"
+	SchedulerAction_Finished  SchedulerAction = "finished"
+)
+
+// SchedulerDecision explains what the job manager did with a waiting job during an iteration of its processing loop
+type SchedulerDecision struct {
+	JobId       string          `json:"jobId"`
+	Type        job.JobType     `json:"type"`
+	Action      SchedulerAction `json:"action"`
+	Reason      string          `json:"reason"`
+	RelatedJobs []string        `json:"relatedJobs,omitempty"` // E.g. jobs blocking this job, or the job it was collapsed into
+	Ts          time.Time       `json:"ts"`                    // Time of the iteration that made the decision
+}
+
+// SchedulerSummary describes the latest iteration of the job manager's processing loop
+type SchedulerSummary struct {
+	Ts         time.Time           `json:"ts"`
+	Paused     bool                `json:"paused"`
+	ActiveJobs []job.JobState      `json:"activeJobs"`
+	Decisions  []SchedulerDecision `json:"decisions"`
+}
+
+// JobSchedule describes a job that is queued on a recurring basis, e.g. nightly E2E tests. Schedules are stored in the
+// Database so that they survive restarts.
+type JobSchedule struct {
+	Id        string                 `dynamodbav:"id" json:"id"`
+	Spec      string                 `dynamodbav:"spec" json:"spec"` // Cron expression evaluated in UTC, e.g. "0 2 * * *", or "@hourly", "@daily", etc.
+	Type      job.JobType            `dynamodbav:"type" json:"type"`
+	Params    map[string]interface{} `dynamodbav:"params,omitempty" json:"params,omitempty"`
+	Enabled   bool                   `dynamodbav:"enabled" json:"enabled"`
+	NextRun   time.Time              `dynamodbav:"nextRun,unixtime" json:"nextRun"`
+	LastRun   time.Time              `dynamodbav:"lastRun,unixtime" json:"lastRun"`
+	LastJobId string                 `dynamodbav:"lastJobId,omitempty" json:"lastJobId,omitempty"` // Job queued by the last run
+}
+
+type PipelineStatus string
+
+const (
+	PipelineStatus_Running   PipelineStatus = "running"
+	PipelineStatus_Completed PipelineStatus = "completed"
+	PipelineStatus_Failed    PipelineStatus = "failed"
+	PipelineStatus_Canceled  PipelineStatus = "canceled"
+)
+
+type PipelineStageStatus string
+
+const (
+	PipelineStageStatus_Pending   PipelineStageStatus = "pending"   // Waiting for upstream stages
+	PipelineStageStatus_Queued    PipelineStageStatus = "queued"    // Job submitted, see the job for its progress
+	PipelineStageStatus_Completed PipelineStageStatus = "completed" // Job completed
+	PipelineStageStatus_Failed    PipelineStageStatus = "failed"    // Job failed, or could not be submitted
+	PipelineStageStatus_Canceled  PipelineStageStatus = "canceled"  // Job canceled
+	PipelineStageStatus_Skipped   PipelineStageStatus = "skipped"   // Job skipped, or upstream stages can no longer satisfy the stage's dependencies
+)
+
+// Pipeline is a DAG of jobs, e.g. deploy ceramic -> smoke tests -> ceramic-tests workflow. Each stage's job is submitted
+// once the stages it depends on have finished in the required way, and stages whose dependencies can no longer be met,
+// e.g. because an upstream stage failed, are skipped.
+type Pipeline struct {
+	Id     string          `dynamodbav:"id" json:"id"`
+	Status PipelineStatus  `dynamodbav:"status" json:"status"`
+	Stages []PipelineStage `dynamodbav:"stages" json:"stages"`
+	Ts     time.Time       `dynamodbav:"ts,unixtime" json:"ts"` // Time the pipeline was submitted
+	Ttl    time.Time       `dynamodbav:"ttl,unixtime" json:"-"` // Record expiration
+}
+
+// PipelineStage is a job in a pipeline, along with the stages it depends on. A stage can only start once all of its
+// dependencies are met.
+//
+// String params can reference the params of upstream stages' jobs as they were when those jobs finished, e.g.
+// "${deploy.deployTag}" for the tag resolved by a deploy stage named "deploy".
+type PipelineStage struct {
+	Name      string                 `dynamodbav:"name" json:"name"`
+	Type      job.JobType            `dynamodbav:"type" json:"type"`
+	Params    map[string]interface{} `dynamodbav:"params,omitempty" json:"params,omitempty"`
+	After     []string               `dynamodbav:"after,omitempty" json:"after,omitempty"`         // Stages that must finish, however they finish
+	OnSuccess []string               `dynamodbav:"onSuccess,omitempty" json:"onSuccess,omitempty"` // Stages that must complete successfully
+	OnFailure []string               `dynamodbav:"onFailure,omitempty" json:"onFailure,omitempty"` // Stages that must fail
+	Status    PipelineStageStatus    `dynamodbav:"status" json:"status"`
+	JobId     string                 `dynamodbav:"jobId,omitempty" json:"jobId,omitempty"`
+	Reason    string                 `dynamodbav:"reason,omitempty" json:"reason,omitempty"` // Why the stage failed or was skipped
+}
+
+// Lease is a time-limited claim on a named resource, e.g. leadership of the job processing loop among CD manager
+// replicas. The token is incremented every time the lease changes owners so that updates made by previous owners can be
+// fenced off. The owner's address lets others reach the owner, e.g. to forward API requests to the leader.
+type Lease struct {
+	Name    string    `dynamodbav:"name" json:"name"`
+	Owner   string    `dynamodbav:"owner" json:"owner"`
+	Address string    `dynamodbav:"address" json:"address"`
+	Token   int64     `dynamodbav:"token" json:"token"`
+	Expires time.Time `dynamodbav:"expires,unixtime" json:"expires"`
+}
+
 // JobSm represents job state machine objects processed by the job manager
 type JobSm interface {
 	Advance() (job.JobState, error)
+	Cancel() (job.JobState, error)
 }
 
 // ApiGw represents an API Gateway service containing APIs we wish to invoke directly, i.e. not through an API call
@@ -111,20 +288,35 @@ type ApiGw interface {
 	Invoke(method, resourceId, restApiId, pathWithQueryString string) (string, error)
 }
 
-// Database represents a database service that can be used as a job queue (e.g. AWS DynamoDB). Most popular document
-// databases provide the primitives for them to be used in this fashion.
+// Database represents a database service that can be used as a job queue (e.g. AWS DynamoDB, PostgreSQL, SQLite). Most
+// popular document and SQL databases provide the primitives for them to be used in this fashion.
 type Database interface {
 	InitializeJobs() error
-	QueueJob(job.JobState) error
+	QueueJob(job.JobState) (job.JobState, error)
 	QueuedJobs() []job.JobState
 	OrderedJobs(job.JobStage) []job.JobState
 	AdvanceJob(job.JobState) error
 	WriteJob(job.JobState) error
 	IterateByType(job.JobType, bool, func(job.JobState) bool) error
+	GetJob(jobId string) (job.JobState, bool, error)
+	GetJobHistory(jobId string) ([]job.JobState, error)
+	ListJobs(JobFilter) (JobPage, error)
 	UpdateBuildTag(DeployComponent, string) error
 	UpdateDeployTag(DeployComponent, string) error
 	GetBuildTags() (map[DeployComponent]string, error)
 	GetDeployTags() (map[DeployComponent]string, error)
+	AddSchedule(JobSchedule) error
+	GetSchedule(id string) (JobSchedule, bool, error)
+	GetSchedules() ([]JobSchedule, error)
+	UpdateSchedule(schedule JobSchedule, nextRun time.Time) (bool, error)
+	WritePipeline(Pipeline) error
+	GetPipeline(id string) (Pipeline, bool, error)
+	ActivePipelines() ([]Pipeline, error)
+	AcquireLease(name, owner, address string, ttl time.Duration) (Lease, bool, error)
+	ReleaseLease(Lease) error
+	Fence(Lease)
+	IsPaused() (bool, error)
+	SetPaused(bool) error
 }
 
 // Cache represents an in-memory cache for job states
@@ -140,6 +332,7 @@ type Deployment interface {
 	LaunchServiceTask(cluster, service, family, container string, overrides map[string]string) (string, error)
 	LaunchTask(cluster, family, container, vpcConfigParam string, overrides map[string]string) (string, error)
 	CheckTask(cluster, taskDefId string, running, stable bool, taskIds ...string) (bool, *int32, error)
+	StopTask(cluster, taskId string) error
 	GetLayout(clusters []string) (*Layout, error)
 	UpdateLayout(*Layout, string) error
 	CheckLayout(*Layout) (bool, error)
@@ -150,12 +343,36 @@ type Notifs interface {
 	NotifyJob(...job.JobState)
 }
 
+// EventStream represents a notification service that publishes job updates to live subscribers
+type EventStream interface {
+	Notifs
+	Subscribe(matcher func(job.JobState) bool) (<-chan job.JobState, func())
+}
+
 // Manager represents the job manager, which is the central job orchestrator of this service.
 type Manager interface {
 	NewJob(job.JobState) (job.JobState, error)
 	CheckJob(jobId string) job.JobState
+	CancelJob(jobId string) (job.JobState, error)
+	ListJobs(JobFilter) (JobPage, error)
+	JobHistory(jobId string) ([]JobHistoryEntry, error)
+	UpdateJob(jobId string, update JobUpdate) (job.JobState, error)
+	SkipJobs(JobFilter) ([]job.JobState, error)
+	ScheduledJobs() ([]job.JobState, error)
+	CloneJob(jobId string) (job.JobState, error)
+	ExplainJob(jobId string) (SchedulerDecision, error)
+	SchedulerSummary() SchedulerSummary
+	ListSchedules() ([]JobSchedule, error)
+	NewSchedule(JobSchedule) (JobSchedule, error)
+	EnableSchedule(id string, enabled bool) (JobSchedule, error)
+	NewPipeline(Pipeline) (Pipeline, error)
+	GetPipeline(id string) (Pipeline, error)
+	ActivePipelines() ([]Pipeline, error)
 	ProcessJobs(shutdownCh chan bool)
-	Pause()
+	IsLeader() bool
+	LeaderAddress() string
+	Wake()
+	Pause() (bool, error)
 }
 
 // Repository represents a git service hosting our repositories (e.g. GitHub)
@@ -164,4 +381,5 @@ type Repository interface {
 	StartWorkflow(job.Workflow) error
 	FindMatchingWorkflowRun(workflow job.Workflow, jobId string, searchTime time.Time) (int64, string, error)
 	CheckWorkflowStatus(workflow job.Workflow, workflowRunId int64) (WorkflowStatus, error)
+	CancelWorkflow(workflow job.Workflow, workflowRunId int64) error
 }
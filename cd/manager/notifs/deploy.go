@@ -58,7 +58,7 @@ func (d deployNotif) getChannels() []webhook.Client {
 }
 
 func (d deployNotif) getTitle() string {
-	component := d.state.Params[job.DeployJobParam_Component].(string)
+	component, _ := d.state.Params[job.DeployJobParam_Component].(string)
 	qualifier := ""
 	// A rollback is always a force job, while a non-rollback force job is always manual, so we can optimize.
 	if rollback, _ := d.state.Params[job.DeployJobParam_Rollback].(bool); rollback {
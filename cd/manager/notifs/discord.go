@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,6 +33,7 @@ const (
 	notifField_JobId      string = "Job ID"
 	notifField_RunTime    string = "Time Running"
 	notifField_WaitTime   string = "Time Waiting"
+	notifField_Priority   string = "Priority"
 	notifField_Deploy     string = "Deployment(s)"
 	notifField_Anchor     string = "Anchor Worker(s)"
 	notifField_TestE2E    string = "E2E Tests"
@@ -122,6 +124,10 @@ func webhooksForLabels(labels []string) ([]webhook.Client, []webhook.Client, err
 
 func (n JobNotifs) NotifyJob(jobs ...job.JobState) {
 	for _, jobState := range jobs {
+		// Jobs that haven't been dequeued yet, e.g. jobs being rescheduled, aren't worth a notification
+		if (jobState.Stage == job.JobStage_Scheduled) || (jobState.Stage == job.JobStage_Queued) {
+			continue
+		}
 		if jn, err := n.getJobNotif(jobState); err != nil {
 			log.Printf("notifyJob: error creating job notification: %v, %s", err, manager.PrintJob(jobState))
 		} else {
@@ -182,6 +188,13 @@ func (n JobNotifs) getNotifFields(jobState job.JobState) []discord.EmbedField {
 			Value: jobState.JobId,
 		},
 	}
+	// Only display the priority if it isn't the default
+	if priority := job.JobPriority(jobState); priority != 0 {
+		fields = append(fields, discord.EmbedField{
+			Name:  notifField_Priority,
+			Value: strconv.FormatInt(priority, 10),
+		})
+	}
 	// Return deploy tags for all jobs if we were able to retrieve them successfully.
 	if deployTags := n.getDeployTags(jobState); len(deployTags) > 0 {
 		fields = append(fields, discord.EmbedField{
@@ -233,9 +246,9 @@ func (n JobNotifs) getDeployTags(jobState job.JobState) string {
 	} else {
 		if jobState.Type == job.JobType_Deploy {
 			if deployTag, found := jobState.Params[job.DeployJobParam_DeployTag].(string); found {
-				// This should always be present
-				sha := jobState.Params[job.DeployJobParam_Sha].(string)
-				deployTags[manager.DeployComponent(jobState.Params[job.DeployJobParam_Component].(string))] = deployTag + "," + sha
+				if params, err := job.DecodeDeployParams(jobState.Params); err == nil {
+					deployTags[manager.DeployComponent(params.Component)] = deployTag + "," + params.Sha
+				}
 			}
 		}
 		// Prepare component messages with GitHub commit hashes and hyperlinks
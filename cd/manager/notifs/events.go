@@ -0,0 +1,93 @@
+package notifs
+
+import (
+	"log"
+	"sync"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+// Number of job updates that can be buffered for a subscriber before it is considered too slow and dropped
+const eventBufferSize = 64
+
+var _ manager.EventStream = &JobEvents{}
+
+// JobEvents fans out job updates to any number of live subscribers (e.g. clients of the event stream API)
+type JobEvents struct {
+	mu          *sync.Mutex
+	subscribers map[uint64]*subscriber
+	nextId      uint64
+}
+
+type subscriber struct {
+	matcher func(job.JobState) bool
+	ch      chan job.JobState
+}
+
+func NewJobEvents() manager.EventStream {
+	return &JobEvents{new(sync.Mutex), make(map[uint64]*subscriber), 0}
+}
+
+func (e *JobEvents) NotifyJob(jobs ...job.JobState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, jobState := range jobs {
+		for id, s := range e.subscribers {
+			if (s.matcher == nil) || s.matcher(jobState) {
+				// Never block job processing on a subscriber. Drop subscribers that aren't keeping up so that they know
+				// (by their channel being closed) that they've missed updates and can reconnect.
+				select {
+				case s.ch <- jobState:
+				default:
+					log.Printf("notifyJob: dropping slow event subscriber: %d", id)
+					e.unsubscribe(id)
+				}
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel on which job updates matching the matcher (or all updates, if the matcher is nil) will be
+// sent, and a function to call when the subscriber is no longer interested in updates.
+func (e *JobEvents) Subscribe(matcher func(job.JobState) bool) (<-chan job.JobState, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id := e.nextId
+	e.nextId++
+	s := &subscriber{matcher, make(chan job.JobState, eventBufferSize)}
+	e.subscribers[id] = s
+	return s.ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		e.unsubscribe(id)
+	}
+}
+
+// unsubscribe must be called with the lock held
+func (e *JobEvents) unsubscribe(id uint64) {
+	if s, found := e.subscribers[id]; found {
+		close(s.ch)
+		delete(e.subscribers, id)
+	}
+}
+
+var _ manager.Notifs = &multiNotifs{}
+
+type multiNotifs struct {
+	notifs []manager.Notifs
+}
+
+// NewMultiNotifs returns a notification service that sends job updates to each of the specified services, in order.
+func NewMultiNotifs(notifs ...manager.Notifs) manager.Notifs {
+	return &multiNotifs{notifs}
+}
+
+func (m multiNotifs) NotifyJob(jobs ...job.JobState) {
+	for _, n := range m.notifs {
+		n.NotifyJob(jobs...)
+	}
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -212,6 +213,20 @@ func (g Github) CheckWorkflowStatus(workflow job.Workflow, workflowRunId int64)
 	}
 }
 
+func (g Github) CancelWorkflow(workflow job.Workflow, workflowRunId int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	resp, err := g.client.Actions.CancelWorkflowRunByID(ctx, workflow.Org, workflow.Repo, workflowRunId)
+	// GitHub cancels workflow runs asynchronously, and responds with "202 Accepted" once a cancellation is scheduled.
+	var acceptedErr *github.AcceptedError
+	if (err != nil) && !errors.As(err, &acceptedErr) {
+		return err
+	}
+	log.Printf("cancelWorkflow: run=%d, rate limit=%d, remaining=%d, resetAt=%s", workflowRunId, resp.Rate.Limit, resp.Rate.Remaining, resp.Rate.Reset)
+	return nil
+}
+
 func (g Github) getWorkflowRun(org, repo string, workflowRunId int64) (*github.WorkflowRun, error) {
 	return manager.RetryWithResultAndError[*github.WorkflowRun](
 		context.Background(),
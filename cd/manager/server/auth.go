@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+type role uint8
+
+// Roles are ordered by the permissions they grant, i.e. each role has all the permissions of the roles below it.
+const (
+	role_None role = iota
+	role_ReadOnly
+	role_SubmitTests
+	role_Deploy
+	role_Admin
+)
+
+var roleNames = map[string]role{
+	"read-only":    role_ReadOnly,
+	"submit-tests": role_SubmitTests,
+	"deploy":       role_Deploy,
+	"admin":        role_Admin,
+}
+
+type principal struct {
+	name string
+	role role
+	// Principals without a role, e.g. GitHub Actions workflows, can only queue the jobs allowed by their policy
+	jobPolicy func(job.JobState) bool
+}
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// auth authenticates API requests using bearer tokens configured per principal, or GitHub Actions OIDC tokens
+type auth struct {
+	tokens map[string]principal // Principals keyed by the SHA-256 hash of their token
+	oidc   *oidcVerifier
+}
+
+// newAuth parses API tokens from the environment. Tokens are configured as a comma-separated list of
+// "principal:role:token" entries, e.g. "ci:submit-tests:abc123,alice:admin:def456". If no tokens are configured,
+// and no OIDC policy is configured, authentication is disabled and all requests are allowed.
+func newAuth() (*auth, error) {
+	apiTokens := os.Getenv("API_TOKENS")
+	oidc, err := newOidcVerifier()
+	if err != nil {
+		return nil, err
+	} else if (len(apiTokens) == 0) && (oidc == nil) {
+		return nil, nil
+	}
+	tokens := make(map[string]principal)
+	for _, entry := range strings.Split(apiTokens, ",") {
+		if len(entry) == 0 {
+			continue
+		}
+		if entryParts := strings.SplitN(strings.TrimSpace(entry), ":", 3); len(entryParts) != 3 {
+			return nil, fmt.Errorf("newAuth: invalid token entry for principal: %s", entryParts[0])
+		} else if r, found := roleNames[entryParts[1]]; !found {
+			return nil, fmt.Errorf("newAuth: invalid role for principal: %s, %s", entryParts[0], entryParts[1])
+		} else if (len(entryParts[0]) == 0) || (len(entryParts[2]) == 0) {
+			return nil, fmt.Errorf("newAuth: missing principal or token: %s", entryParts[0])
+		} else {
+			tokens[hashToken(entryParts[2])] = principal{name: entryParts[0], role: r}
+		}
+	}
+	return &auth{tokens, oidc}, nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (a *auth) authenticate(r *http.Request) (principal, bool) {
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		if p, found := a.tokens[hashToken(token)]; found {
+			return p, true
+		} else if a.oidc == nil {
+			return principal{}, false
+		} else if p, err := a.oidc.authenticate(token); err != nil {
+			log.Printf("authenticate: oidc: %v", err)
+		} else {
+			return p, true
+		}
+	}
+	return principal{}, false
+}
+
+// authentication attaches the authenticated principal to each request, and rejects requests that could not be
+// authenticated. Health checks are always allowed through so that load balancers don't need credentials.
+func authentication(a *auth) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Grant all permissions to anonymous callers if authentication is disabled
+			p := principal{role: role_Admin}
+			if (a != nil) && (r.URL.Path != "/healthcheck") {
+				var found bool
+				if p, found = a.authenticate(r); !found {
+					writeJsonResponse(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
+		})
+	}
+}
+
+func requestPrincipal(r *http.Request) principal {
+	p, _ := r.Context().Value(principalContextKey).(principal)
+	return p
+}
+
+// isAllowed returns true if the principal making the request has at least the required role
+func isAllowed(r *http.Request, required role) bool {
+	return requestPrincipal(r).role >= required
+}
+
+// requireRole rejects requests from principals that don't have at least the required role
+func requireRole(required role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAllowed(r, required) {
+			p := requestPrincipal(r)
+			log.Printf("requireRole: forbidden: %s, %s %s", p.name, r.Method, r.URL.Path)
+			writeJsonResponse(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canSubmitJob returns true if the principal making the request has the role needed for the job, or the principal's
+// job policy allows it.
+func canSubmitJob(r *http.Request, jobState job.JobState) bool {
+	p := requestPrincipal(r)
+	return (p.role >= jobRole(jobState)) || ((p.jobPolicy != nil) && p.jobPolicy(jobState))
+}
+
+// jobRole returns the role needed to submit a job
+func jobRole(jobState job.JobState) role {
+	// Force deploys and rollbacks preempt other jobs, so only allow principals that can deploy to set these params
+	if force, _ := jobState.Params[job.DeployJobParam_Force].(bool); force {
+		return role_Deploy
+	} else if rollback, _ := jobState.Params[job.DeployJobParam_Rollback].(bool); rollback {
+		return role_Deploy
+	}
+	switch jobState.Type {
+	case job.JobType_TestE2E, job.JobType_TestSmoke:
+		return role_SubmitTests
+	case job.JobType_Workflow:
+		// Workflows with a "deploy" label are treated as deployments
+		if workflow, err := job.CreateWorkflowJob(jobState); (err == nil) && !workflow.IsType(job.WorkflowJobLabel_Deploy) {
+			return role_SubmitTests
+		}
+		return role_Deploy
+	default:
+		return role_Deploy
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+const (
+	gitHubOidc_DefaultIssuer  = "https://token.actions.githubusercontent.com"
+	gitHubOidc_DefaultJwksUrl = gitHubOidc_DefaultIssuer + "/.well-known/jwks"
+)
+
+// Allowed clock skew when validating token timestamps
+const oidcLeeway = time.Minute
+
+// Minimum interval between JWKS refreshes triggered by tokens signed with unknown keys
+const jwksRefreshInterval = time.Minute
+
+// oidcRule describes the jobs that GitHub Actions workflows from a particular repository are allowed to queue. Empty
+// branch, environment, and component lists match any value.
+type oidcRule struct {
+	Repository   string        `json:"repository"`
+	Branches     []string      `json:"branches,omitempty"`
+	Environments []string      `json:"environments,omitempty"`
+	JobTypes     []job.JobType `json:"jobTypes"`
+	Components   []string      `json:"components,omitempty"`
+}
+
+type oidcClaims struct {
+	Issuer      string          `json:"iss"`
+	Audience    json.RawMessage `json:"aud"`
+	Subject     string          `json:"sub"`
+	Expiry      int64           `json:"exp"`
+	NotBefore   int64           `json:"nbf"`
+	Repository  string          `json:"repository"`
+	Ref         string          `json:"ref"`
+	RefType     string          `json:"ref_type"`
+	Environment string          `json:"environment"`
+	Actor       string          `json:"actor"`
+	RunId       string          `json:"run_id"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// oidcVerifier verifies GitHub Actions OIDC tokens against a JWKS, and maps the verified claims to a principal that
+// can only queue the jobs allowed by the configured policy.
+type oidcVerifier struct {
+	issuer      string
+	audience    string
+	jwksUrl     string
+	rules       []oidcRule
+	client      *http.Client
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+	mu          sync.Mutex
+}
+
+// newOidcVerifier reads the OIDC configuration from the environment. The policy is a JSON list of rules, e.g.
+// `[{"repository":"ceramicnetwork/js-ceramic","branches":["main"],"jobTypes":["deploy"],"components":["ceramic"]}]`.
+// If no policy is configured, OIDC authentication is disabled.
+func newOidcVerifier() (*oidcVerifier, error) {
+	policy := os.Getenv("GITHUB_OIDC_POLICY")
+	if len(policy) == 0 {
+		return nil, nil
+	}
+	rules := make([]oidcRule, 0)
+	if err := json.Unmarshal([]byte(policy), &rules); err != nil {
+		return nil, fmt.Errorf("newOidcVerifier: invalid policy: %v", err)
+	}
+	for _, rule := range rules {
+		if (len(rule.Repository) == 0) || (len(rule.JobTypes) == 0) {
+			return nil, fmt.Errorf("newOidcVerifier: policy rules need a repository and job types: %v", rule)
+		}
+	}
+	audience := os.Getenv("GITHUB_OIDC_AUDIENCE")
+	if len(audience) == 0 {
+		return nil, fmt.Errorf("newOidcVerifier: missing audience")
+	}
+	issuer := os.Getenv("GITHUB_OIDC_ISSUER")
+	if len(issuer) == 0 {
+		issuer = gitHubOidc_DefaultIssuer
+	}
+	jwksUrl := os.Getenv("GITHUB_OIDC_JWKS_URL")
+	if len(jwksUrl) == 0 {
+		jwksUrl = gitHubOidc_DefaultJwksUrl
+	}
+	return &oidcVerifier{
+		issuer:   issuer,
+		audience: audience,
+		jwksUrl:  jwksUrl,
+		rules:    rules,
+		client:   &http.Client{Timeout: manager.DefaultHttpWaitTime},
+		keys:     make(map[string]*rsa.PublicKey),
+	}, nil
+}
+
+// authenticate verifies an RS256-signed JWT and returns a principal for it
+func (o *oidcVerifier) authenticate(token string) (principal, error) {
+	tokenParts := strings.Split(token, ".")
+	if len(tokenParts) != 3 {
+		return principal{}, fmt.Errorf("authenticate: malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	claims := oidcClaims{}
+	if err := decodeJwtSegment(tokenParts[0], &header); err != nil {
+		return principal{}, fmt.Errorf("authenticate: invalid header: %v", err)
+	} else if header.Alg != "RS256" {
+		return principal{}, fmt.Errorf("authenticate: unsupported algorithm: %s", header.Alg)
+	} else if key, err := o.getKey(header.Kid); err != nil {
+		return principal{}, err
+	} else if signature, err := base64.RawURLEncoding.DecodeString(tokenParts[2]); err != nil {
+		return principal{}, fmt.Errorf("authenticate: invalid signature encoding: %v", err)
+	} else if err = verifyRs256(key, tokenParts[0]+"."+tokenParts[1], signature); err != nil {
+		return principal{}, fmt.Errorf("authenticate: invalid signature: %v", err)
+	} else if err = decodeJwtSegment(tokenParts[1], &claims); err != nil {
+		return principal{}, fmt.Errorf("authenticate: invalid claims: %v", err)
+	} else if err = o.validateClaims(claims); err != nil {
+		return principal{}, err
+	}
+	return principal{
+		name: "github:" + claims.Repository,
+		role: role_None,
+		jobPolicy: func(jobState job.JobState) bool {
+			return o.isAllowed(claims, jobState)
+		},
+	}, nil
+}
+
+func decodeJwtSegment(segment string, v interface{}) error {
+	if decoded, err := base64.RawURLEncoding.DecodeString(segment); err != nil {
+		return err
+	} else {
+		return json.Unmarshal(decoded, v)
+	}
+}
+
+func verifyRs256(key *rsa.PublicKey, signingInput string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signingInput))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+}
+
+func (o *oidcVerifier) validateClaims(claims oidcClaims) error {
+	now := time.Now()
+	if claims.Issuer != o.issuer {
+		return fmt.Errorf("validateClaims: invalid issuer: %s", claims.Issuer)
+	} else if !hasAudience(claims.Audience, o.audience) {
+		return fmt.Errorf("validateClaims: invalid audience: %s", claims.Audience)
+	} else if now.After(time.Unix(claims.Expiry, 0).Add(oidcLeeway)) {
+		return fmt.Errorf("validateClaims: token expired: %s", claims.Subject)
+	} else if (claims.NotBefore > 0) && now.Add(oidcLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("validateClaims: token not yet valid: %s", claims.Subject)
+	} else if len(claims.Repository) == 0 {
+		return fmt.Errorf("validateClaims: missing repository: %s", claims.Subject)
+	}
+	return nil
+}
+
+// hasAudience checks the "aud" claim, which can either be a single string or a list of strings
+func hasAudience(rawAudience json.RawMessage, audience string) bool {
+	var single string
+	if err := json.Unmarshal(rawAudience, &single); err == nil {
+		return single == audience
+	}
+	var multiple []string
+	if err := json.Unmarshal(rawAudience, &multiple); err == nil {
+		return slices.Contains(multiple, audience)
+	}
+	return false
+}
+
+// isAllowed returns true if any policy rule matching the token's claims allows the job to be queued
+func (o *oidcVerifier) isAllowed(claims oidcClaims, jobState job.JobState) bool {
+	// Force deploys and rollbacks are reserved for principals with the "deploy" role
+	if force, _ := jobState.Params[job.DeployJobParam_Force].(bool); force {
+		return false
+	} else if rollback, _ := jobState.Params[job.DeployJobParam_Rollback].(bool); rollback {
+		return false
+	}
+	component, _ := jobState.Params[job.DeployJobParam_Component].(string)
+	for _, rule := range o.rules {
+		if rule.Repository != claims.Repository {
+			continue
+		} else if (len(rule.Branches) > 0) &&
+			((claims.RefType != "branch") || !slices.Contains(rule.Branches, strings.TrimPrefix(claims.Ref, "refs/heads/"))) {
+			continue
+		} else if (len(rule.Environments) > 0) && !slices.Contains(rule.Environments, claims.Environment) {
+			continue
+		} else if !slices.Contains(rule.JobTypes, jobState.Type) {
+			continue
+		} else if (jobState.Type == job.JobType_Deploy) && (len(rule.Components) > 0) && !slices.Contains(rule.Components, component) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// getKey returns the public key with the specified ID, refreshing the JWKS if the key is unknown. GitHub rotates its
+// signing keys periodically, so an unknown key ID isn't necessarily an error.
+//
+// At most one refresh is attempted per interval, whether or not it succeeds, so that tokens with made up key IDs can't
+// be used to flood the JWKS endpoint. The keys are fetched without holding the lock so that tokens signed with known
+// keys aren't held up by a slow endpoint.
+func (o *oidcVerifier) getKey(kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	key, found := o.keys[kid]
+	refresh := !found && (time.Since(o.lastRefresh) >= jwksRefreshInterval)
+	if refresh {
+		o.lastRefresh = time.Now()
+	}
+	o.mu.Unlock()
+
+	if found {
+		return key, nil
+	} else if !refresh {
+		return nil, fmt.Errorf("getKey: unknown key: %s", kid)
+	} else if keys, err := o.fetchKeys(); err != nil {
+		return nil, err
+	} else {
+		o.mu.Lock()
+		o.keys = keys
+		o.mu.Unlock()
+		if key, found = keys[kid]; found {
+			return key, nil
+		}
+		return nil, fmt.Errorf("getKey: unknown key: %s", kid)
+	}
+}
+
+func (o *oidcVerifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DefaultHttpWaitTime)
+	defer cancel()
+
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.jwksUrl, nil); err != nil {
+		return nil, err
+	} else if resp, err := o.client.Do(req); err != nil {
+		return nil, fmt.Errorf("fetchKeys: request failed: %v", err)
+	} else {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetchKeys: unexpected status: %d", resp.StatusCode)
+		} else if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+			return nil, fmt.Errorf("fetchKeys: invalid jwks: %v", err)
+		}
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		} else if n, err := base64.RawURLEncoding.DecodeString(key.N); err != nil {
+			return nil, fmt.Errorf("fetchKeys: invalid modulus for key %s: %v", key.Kid, err)
+		} else if e, err := base64.RawURLEncoding.DecodeString(key.E); err != nil {
+			return nil, fmt.Errorf("fetchKeys: invalid exponent for key %s: %v", key.Kid, err)
+		} else {
+			keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+const (
+	testOidc_Issuer     = "https://token.example.com"
+	testOidc_Audience   = "cd-manager"
+	testOidc_Repository = "3box/pipeline-tools"
+	testOidc_Policy     = `[{"repository":"3box/pipeline-tools","branches":["main"],"jobTypes":["deploy"],"components":["ceramic"]}]`
+)
+
+// jwksServer serves the public halves of its current signing keys, and counts the requests it receives
+type jwksServer struct {
+	*httptest.Server
+	keys     map[string]*rsa.PrivateKey
+	status   int
+	requests int
+	mu       sync.Mutex
+}
+
+func newJwksServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: make(map[string]*rsa.PrivateKey), status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests++
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		jwks := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		for kid, key := range s.keys {
+			jwks.Keys = append(jwks.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rotate replaces the server's signing keys with a new key, which is returned
+func (s *jwksServer) rotate(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = map[string]*rsa.PrivateKey{kid: key}
+	return key
+}
+
+func (s *jwksServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+}
+
+func (s *jwksServer) numRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+func newTestVerifier(t *testing.T, jwksUrl string) *oidcVerifier {
+	t.Setenv("GITHUB_OIDC_POLICY", testOidc_Policy)
+	t.Setenv("GITHUB_OIDC_AUDIENCE", testOidc_Audience)
+	t.Setenv("GITHUB_OIDC_ISSUER", testOidc_Issuer)
+	t.Setenv("GITHUB_OIDC_JWKS_URL", jwksUrl)
+	verifier, err := newOidcVerifier()
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	return verifier
+}
+
+// testClaims returns claims for a token from a workflow on the main branch, valid for the next few minutes
+func testClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":        testOidc_Issuer,
+		"aud":        testOidc_Audience,
+		"sub":        "repo:" + testOidc_Repository + ":ref:refs/heads/main",
+		"exp":        now.Add(5 * time.Minute).Unix(),
+		"nbf":        now.Add(-time.Minute).Unix(),
+		"repository": testOidc_Repository,
+		"ref":        "refs/heads/main",
+		"ref_type":   "branch",
+	}
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(encoded)
+	}
+	signingInput := encode(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOidcAuthenticate(t *testing.T) {
+	server := newJwksServer(t)
+	key := server.rotate(t, "key1")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	verifier := newTestVerifier(t, server.URL)
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		update func(claims map[string]interface{})
+		valid  bool
+	}{
+		{"valid token", key, func(map[string]interface{}) {}, true},
+		{"audience list", key, func(claims map[string]interface{}) { claims["aud"] = []string{"other", testOidc_Audience} }, true},
+		{"expiry within leeway", key, func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-oidcLeeway / 2).Unix() }, true},
+		{"wrong issuer", key, func(claims map[string]interface{}) { claims["iss"] = "https://other.example.com" }, false},
+		{"wrong audience", key, func(claims map[string]interface{}) { claims["aud"] = "other" }, false},
+		{"wrong audience list", key, func(claims map[string]interface{}) { claims["aud"] = []string{"other"} }, false},
+		{"expired token", key, func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-2 * oidcLeeway).Unix() }, false},
+		{"token not yet valid", key, func(claims map[string]interface{}) { claims["nbf"] = time.Now().Add(2 * oidcLeeway).Unix() }, false},
+		{"missing repository", key, func(claims map[string]interface{}) { delete(claims, "repository") }, false},
+		{"wrong signing key", otherKey, func(map[string]interface{}) {}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := testClaims()
+			test.update(claims)
+			p, err := verifier.authenticate(signToken(t, test.key, "key1", claims))
+			if test.valid && (err != nil) {
+				t.Fatalf("expected valid token: %v", err)
+			} else if !test.valid && (err == nil) {
+				t.Fatalf("expected invalid token")
+			} else if test.valid && (p.name != "github:"+testOidc_Repository) {
+				t.Fatalf("unexpected principal: %s", p.name)
+			}
+		})
+	}
+}
+
+func TestOidcKeyRotation(t *testing.T) {
+	server := newJwksServer(t)
+	oldKey := server.rotate(t, "key1")
+	verifier := newTestVerifier(t, server.URL)
+
+	if _, err := verifier.authenticate(signToken(t, oldKey, "key1", testClaims())); err != nil {
+		t.Fatalf("expected valid token: %v", err)
+	}
+	// Tokens signed with a new key are rejected until the refresh interval has elapsed
+	newKey := server.rotate(t, "key2")
+	if _, err := verifier.authenticate(signToken(t, newKey, "key2", testClaims())); err == nil {
+		t.Fatalf("expected unknown key to be rejected before the refresh interval")
+	} else if numRequests := server.numRequests(); numRequests != 1 {
+		t.Fatalf("expected 1 jwks request, found: %d", numRequests)
+	}
+	verifier.lastRefresh = verifier.lastRefresh.Add(-jwksRefreshInterval)
+	if _, err := verifier.authenticate(signToken(t, newKey, "key2", testClaims())); err != nil {
+		t.Fatalf("expected valid token after key rotation: %v", err)
+	}
+	// Keys that are no longer published are forgotten
+	if _, err := verifier.authenticate(signToken(t, oldKey, "key1", testClaims())); err == nil {
+		t.Fatalf("expected rotated key to be rejected")
+	}
+}
+
+func TestOidcFailedRefresh(t *testing.T) {
+	server := newJwksServer(t)
+	key := server.rotate(t, "key1")
+	server.setStatus(http.StatusInternalServerError)
+	verifier := newTestVerifier(t, server.URL)
+
+	if _, err := verifier.authenticate(signToken(t, key, "key1", testClaims())); err == nil {
+		t.Fatalf("expected token to be rejected when keys can't be fetched")
+	}
+	// Failed refreshes count towards the refresh interval too
+	server.setStatus(http.StatusOK)
+	if _, err := verifier.authenticate(signToken(t, key, "key1", testClaims())); err == nil {
+		t.Fatalf("expected token to be rejected before the refresh interval")
+	} else if numRequests := server.numRequests(); numRequests != 1 {
+		t.Fatalf("expected 1 jwks request, found: %d", numRequests)
+	}
+	verifier.lastRefresh = verifier.lastRefresh.Add(-jwksRefreshInterval)
+	if _, err := verifier.authenticate(signToken(t, key, "key1", testClaims())); err != nil {
+		t.Fatalf("expected valid token after the refresh interval: %v", err)
+	}
+}
+
+func TestOidcPolicy(t *testing.T) {
+	server := newJwksServer(t)
+	key := server.rotate(t, "key1")
+	verifier := newTestVerifier(t, server.URL)
+
+	deployJob := func(params map[string]interface{}) job.JobState {
+		jobParams := map[string]interface{}{job.DeployJobParam_Component: "ceramic"}
+		for k, v := range params {
+			jobParams[k] = v
+		}
+		return job.JobState{Type: job.JobType_Deploy, Params: jobParams}
+	}
+	tests := []struct {
+		name     string
+		update   func(claims map[string]interface{})
+		jobState job.JobState
+		allowed  bool
+	}{
+		{"allowed deploy", func(map[string]interface{}) {}, deployJob(nil), true},
+		{"other component", func(map[string]interface{}) {}, deployJob(map[string]interface{}{job.DeployJobParam_Component: "ipfs"}), false},
+		{"other job type", func(map[string]interface{}) {}, job.JobState{Type: job.JobType_Anchor, Params: map[string]interface{}{}}, false},
+		{"force deploy", func(map[string]interface{}) {}, deployJob(map[string]interface{}{job.DeployJobParam_Force: true}), false},
+		{"rollback", func(map[string]interface{}) {}, deployJob(map[string]interface{}{job.DeployJobParam_Rollback: true}), false},
+		{"other branch", func(claims map[string]interface{}) { claims["ref"] = "refs/heads/develop" }, deployJob(nil), false},
+		{"tag", func(claims map[string]interface{}) { claims["ref"], claims["ref_type"] = "refs/tags/main", "tag" }, deployJob(nil), false},
+		{"other repository", func(claims map[string]interface{}) { claims["repository"] = "3box/other" }, deployJob(nil), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := testClaims()
+			test.update(claims)
+			if p, err := verifier.authenticate(signToken(t, key, "key1", claims)); err != nil {
+				t.Fatalf("expected valid token: %v", err)
+			} else if p.role != role_None {
+				t.Fatalf("unexpected role: %v", p.role)
+			} else if allowed := p.jobPolicy(test.jobState); allowed != test.allowed {
+				t.Fatalf("expected allowed: %t, found: %t", test.allowed, allowed)
+			}
+		})
+	}
+}
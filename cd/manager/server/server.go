@@ -3,25 +3,59 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/exp/slices"
+
 	"github.com/3box/pipeline-tools/cd/manager"
 	"github.com/3box/pipeline-tools/cd/manager/common/job"
 )
 
-func Setup(addr string, m manager.Manager) http.Server {
+// Interval at which comments are sent on idle event streams so that clients and proxies don't close the connection
+const eventStreamKeepAlive = 30 * time.Second
+
+// Header set on requests forwarded by a standby, so that they aren't forwarded again if leadership has changed meanwhile
+const forwardedHeader = "X-Cd-Manager-Forwarded"
+
+func Setup(addr string, m manager.Manager, events manager.EventStream) http.Server {
 	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
+	a, err := newAuth()
+	if err != nil {
+		log.Fatalf("setup: failed to configure authentication: %v", err)
+	} else if a == nil {
+		log.Println("setup: no API tokens or OIDC policy configured, authentication disabled")
+	}
 	mux := http.NewServeMux()
 	mux.Handle("/healthcheck", healthcheckHandler())
-	mux.Handle("/time", timeHandler(time.RFC1123))
+	mux.Handle("/time", requireRole(role_ReadOnly, timeHandler(time.RFC1123)))
+	// Roles for job requests depend on the method and the job, so they are checked in the handler.
 	mux.Handle("/job", jobHandler(m))
-	mux.Handle("/pause", pauseHandler(m))
+	mux.Handle("/job/", requireRole(role_ReadOnly, jobActionHandler(m)))
+	mux.Handle("/jobs", requireRole(role_ReadOnly, jobsHandler(m)))
+	mux.Handle("/jobs/skip", requireRole(role_Deploy, skipJobsHandler(m)))
+	mux.Handle("/jobs/scheduled", requireRole(role_ReadOnly, scheduledJobsHandler(m)))
+	// Roles for adding schedules depend on the job being scheduled, so they are checked in the handler.
+	mux.Handle("/schedules", requireRole(role_ReadOnly, schedulesHandler(m)))
+	mux.Handle("/schedule/", requireRole(role_Deploy, scheduleActionHandler(m)))
+	// Roles for submitting pipelines depend on the jobs in the pipeline, so they are checked in the handler.
+	mux.Handle("/pipelines", requireRole(role_ReadOnly, pipelinesHandler(m)))
+	mux.Handle("/pipeline/", requireRole(role_ReadOnly, pipelineHandler(m)))
+	mux.Handle("/scheduler", requireRole(role_ReadOnly, schedulerHandler(m)))
+	mux.Handle("/pause", requireRole(role_Admin, requireLeader(m, pauseHandler(m))))
+	mux.Handle("/wake", requireRole(role_Deploy, wakeHandler(m)))
+	// Job updates are only published by the leader, so standbys forward event streams to it.
+	mux.Handle("/events", requireRole(role_ReadOnly, requireLeader(m, eventsHandler(events))))
 	return http.Server{
 		Addr:     addr,
-		Handler:  logging(logger)(mux),
+		Handler:  logging(logger)(authentication(a)(leaderOnly(m)(mux))),
 		ErrorLog: logger,
 	}
 }
@@ -34,7 +68,26 @@ func healthcheckHandler() http.HandlerFunc {
 
 func pauseHandler(m manager.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		m.Pause()
+		if paused, err := m.Pause(); err != nil {
+			writeJsonResponse(w, "failed to pause: "+err.Error(), http.StatusInternalServerError)
+		} else if paused {
+			writeJsonResponse(w, "job manager paused", http.StatusOK)
+		} else {
+			writeJsonResponse(w, "job manager unpaused", http.StatusOK)
+		}
+	}
+}
+
+// wakeHandler runs the processing loop right away, e.g. when an ECS task or GitHub workflow that a job is waiting on has
+// finished, instead of waiting for the next poll.
+func wakeHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJsonResponse(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+		} else {
+			m.Wake()
+			writeJsonResponse(w, "processing loop woken up", http.StatusAccepted)
+		}
 	}
 }
 
@@ -66,14 +119,32 @@ func jobHandler(m manager.Manager) http.HandlerFunc {
 				body = "bad request: " + err.Error()
 			}
 		} else if r.Method == http.MethodPost {
-			if jobState, err = m.NewJob(jobState); err != nil {
-				status = http.StatusInternalServerError
-				body = "could not queue job: " + err.Error()
+			status, body = submitJob(r, m, jobState)
+		} else if r.Method == http.MethodGet {
+			if !isAllowed(r, role_ReadOnly) {
+				status = http.StatusForbidden
+				body = "forbidden"
+			} else {
+				body = m.CheckJob(jobState.JobId)
+			}
+		} else if r.Method == http.MethodDelete {
+			if !isAllowed(r, role_Deploy) {
+				status = http.StatusForbidden
+				body = "forbidden: cannot cancel jobs"
+			} else if jobState, err = m.CancelJob(jobState.JobId); err != nil {
+				if errors.Is(err, manager.Error_JobNotFound) {
+					status = http.StatusNotFound
+				} else if errors.Is(err, manager.Error_JobNotCancelable) {
+					status = http.StatusConflict
+				} else {
+					status = http.StatusInternalServerError
+				}
+				body = "could not cancel job: " + err.Error()
 			} else {
+				// Cancellation happens asynchronously, so return the state of the job at the time of the request.
+				status = http.StatusAccepted
 				body = jobState
 			}
-		} else if r.Method == http.MethodGet {
-			body = m.CheckJob(jobState.JobId)
 		} else {
 			body = "unsupported method: " + r.Method
 			status = http.StatusMethodNotAllowed
@@ -82,6 +153,490 @@ func jobHandler(m manager.Manager) http.HandlerFunc {
 	}
 }
 
+// submitJob queues a new job on behalf of the principal making the request
+func submitJob(r *http.Request, m manager.Manager, jobState job.JobState) (int, any) {
+	if !canSubmitJob(r, jobState) {
+		return http.StatusForbidden, fmt.Sprintf("forbidden: cannot submit %s job", jobState.Type)
+	}
+	if jobState.Params == nil {
+		jobState.Params = make(map[string]interface{})
+	}
+	// Record the authenticated principal as the source of the job so that it can't be spoofed
+	if p := requestPrincipal(r); len(p.name) > 0 {
+		jobState.Params[job.JobParam_Source] = p.name
+	}
+	// Repeated submissions with the same idempotency key return the original job instead of queueing a new one. The key
+	// can be supplied as a header or a job param, with the header taking precedence.
+	if idempotencyKey := r.Header.Get("Idempotency-Key"); len(idempotencyKey) > 0 {
+		jobState.Params[job.JobParam_IdempotencyKey] = idempotencyKey
+	}
+	// The start time can also be supplied as a query param, e.g. "?notBefore=30m" to rerun a job in half an hour.
+	if notBefore := r.URL.Query().Get("notBefore"); len(notBefore) > 0 {
+		jobState.Params[job.JobParam_NotBefore] = notBefore
+	}
+	// The priority can also be supplied as a query param, e.g. to rerun a job with a different priority than the
+	// original, with the query param taking precedence.
+	if priority := r.URL.Query().Get("priority"); len(priority) > 0 {
+		if parsedPriority, err := strconv.ParseInt(priority, 10, 64); err != nil {
+			return http.StatusBadRequest, "invalid priority: " + priority
+		} else {
+			jobState.Params[job.JobParam_Priority] = parsedPriority
+		}
+	}
+	if jobState, err := m.NewJob(jobState); err != nil {
+		if errors.Is(err, manager.Error_InvalidJob) {
+			return http.StatusBadRequest, "could not queue job: " + err.Error()
+		}
+		return http.StatusInternalServerError, "could not queue job: " + err.Error()
+	} else {
+		return http.StatusOK, jobState
+	}
+}
+
+// jobActionHandler handles requests for individual jobs, i.e. paths of the form "/job/{id}" and "/job/{id}/{action}".
+func jobActionHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/job/"), "/"), "/")
+		if (len(pathParts) > 2) || (len(pathParts[0]) == 0) {
+			status = http.StatusNotFound
+			body = "not found: " + r.URL.Path
+		} else if len(pathParts) == 1 {
+			// Changes to waiting jobs, e.g. rescheduling, reprioritizing, or editing params
+			jobUpdate := manager.JobUpdate{}
+			if r.Method != http.MethodPatch {
+				status = http.StatusMethodNotAllowed
+				body = "unsupported method: " + r.Method
+			} else if !isAllowed(r, role_Deploy) {
+				status = http.StatusForbidden
+				body = "forbidden: cannot update jobs"
+			} else if r.Header.Get("Content-Type") != "application/json" {
+				status = http.StatusUnsupportedMediaType
+				body = "content-type is not application/json"
+			} else if err := decodeJson(r, &jobUpdate); err != nil {
+				status = http.StatusBadRequest
+				body = err.Error()
+			} else if jobState, err := m.UpdateJob(pathParts[0], jobUpdate); err != nil {
+				if errors.Is(err, manager.Error_JobNotFound) {
+					status = http.StatusNotFound
+				} else if errors.Is(err, manager.Error_JobNotQueued) {
+					status = http.StatusConflict
+				} else if errors.Is(err, manager.Error_InvalidJob) {
+					status = http.StatusBadRequest
+				} else {
+					status = http.StatusInternalServerError
+				}
+				body = "could not update job: " + err.Error()
+			} else {
+				// Updates happen asynchronously, so return the state the job will be in once the update is applied.
+				status = http.StatusAccepted
+				body = jobState
+			}
+		} else {
+			jobId, action := pathParts[0], pathParts[1]
+			switch action {
+			case "history":
+				if r.Method != http.MethodGet {
+					status = http.StatusMethodNotAllowed
+					body = "unsupported method: " + r.Method
+				} else if history, err := m.JobHistory(jobId); err != nil {
+					if errors.Is(err, manager.Error_JobNotFound) {
+						status = http.StatusNotFound
+					} else {
+						status = http.StatusInternalServerError
+					}
+					body = "could not get job history: " + err.Error()
+				} else {
+					body = history
+				}
+			case "explain":
+				// Why the job was or wasn't started during the latest iteration of the processing loop
+				if r.Method != http.MethodGet {
+					status = http.StatusMethodNotAllowed
+					body = "unsupported method: " + r.Method
+				} else if decision, err := m.ExplainJob(jobId); err != nil {
+					if errors.Is(err, manager.Error_JobNotFound) {
+						status = http.StatusNotFound
+					} else {
+						status = http.StatusInternalServerError
+					}
+					body = "could not explain job: " + err.Error()
+				} else {
+					body = decision
+				}
+			case "rerun":
+				// Queue a copy of a finished job, e.g. to rerun failed tests without having to reconstruct the job
+				if r.Method != http.MethodPost {
+					status = http.StatusMethodNotAllowed
+					body = "unsupported method: " + r.Method
+				} else if clonedJob, err := m.CloneJob(jobId); err != nil {
+					if errors.Is(err, manager.Error_JobNotFound) {
+						status = http.StatusNotFound
+					} else if errors.Is(err, manager.Error_JobNotFinished) {
+						status = http.StatusConflict
+					} else {
+						status = http.StatusInternalServerError
+					}
+					body = "could not rerun job: " + err.Error()
+				} else {
+					status, body = submitJob(r, m, clonedJob)
+				}
+			default:
+				status = http.StatusNotFound
+				body = "unknown job action: " + action
+			}
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+func jobsHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		if r.Method != http.MethodGet {
+			body = "unsupported method: " + r.Method
+			status = http.StatusMethodNotAllowed
+		} else if filter, err := parseJobFilter(r.URL.Query()); err != nil {
+			status = http.StatusBadRequest
+			body = "bad request: " + err.Error()
+		} else if page, err := m.ListJobs(filter); err != nil {
+			if errors.Is(err, manager.Error_InvalidCursor) {
+				status = http.StatusBadRequest
+			} else {
+				status = http.StatusInternalServerError
+			}
+			body = "could not list jobs: " + err.Error()
+		} else {
+			body = page
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// skipJobsHandler skips all waiting jobs matching the filter, e.g. "/jobs/skip?type=anchor". At least one filter
+// criterion is required so that the whole queue isn't skipped by accident.
+func skipJobsHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		if r.Method != http.MethodPost {
+			body = "unsupported method: " + r.Method
+			status = http.StatusMethodNotAllowed
+		} else if filter, err := parseJobFilter(r.URL.Query()); err != nil {
+			status = http.StatusBadRequest
+			body = "bad request: " + err.Error()
+		} else if (manager.JobFilter{Asc: filter.Asc, Limit: filter.Limit, Cursor: filter.Cursor}) == filter {
+			status = http.StatusBadRequest
+			body = "bad request: missing filter"
+		} else if skippedJobs, err := m.SkipJobs(filter); err != nil {
+			status = http.StatusInternalServerError
+			body = "could not skip jobs: " + err.Error()
+		} else {
+			// Jobs are skipped asynchronously
+			status = http.StatusAccepted
+			body = skippedJobs
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// scheduledJobsHandler returns the jobs that are scheduled to be queued later, in order of when they come due. Scheduled
+// jobs can be rescheduled or canceled like any other waiting job.
+func scheduledJobsHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		if r.Method != http.MethodGet {
+			body = "unsupported method: " + r.Method
+			status = http.StatusMethodNotAllowed
+		} else if scheduledJobs, err := m.ScheduledJobs(); err != nil {
+			status = http.StatusInternalServerError
+			body = "could not list scheduled jobs: " + err.Error()
+		} else {
+			body = scheduledJobs
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// schedulerHandler returns the active jobs, and the decisions made for waiting jobs during the latest iteration of the
+// processing loop.
+func schedulerHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		if r.Method != http.MethodGet {
+			body = "unsupported method: " + r.Method
+			status = http.StatusMethodNotAllowed
+		} else {
+			body = m.SchedulerSummary()
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// schedulesHandler lists all recurring job schedules, or adds a new one, e.g. `{"id": "nightly-e2e", "spec": "0 2 * * *",
+// "type": "test_e2e"}`.
+func schedulesHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		if r.Method == http.MethodGet {
+			if schedules, err := m.ListSchedules(); err != nil {
+				status = http.StatusInternalServerError
+				body = "could not list schedules: " + err.Error()
+			} else {
+				body = schedules
+			}
+		} else if r.Method == http.MethodPost {
+			schedule := manager.JobSchedule{}
+			if r.Header.Get("Content-Type") != "application/json" {
+				status = http.StatusUnsupportedMediaType
+				body = "content-type is not application/json"
+			} else if err := decodeJson(r, &schedule); err != nil {
+				status = http.StatusBadRequest
+				body = err.Error()
+			} else if !canSubmitJob(r, job.JobState{Type: schedule.Type, Params: schedule.Params}) {
+				status = http.StatusForbidden
+				body = fmt.Sprintf("forbidden: cannot schedule %s job", schedule.Type)
+			} else {
+				if schedule.Params == nil {
+					schedule.Params = make(map[string]interface{})
+				}
+				// Record the authenticated principal as the source of the scheduled jobs so that it can't be spoofed
+				if p := requestPrincipal(r); len(p.name) > 0 {
+					schedule.Params[job.JobParam_Source] = p.name
+				}
+				if schedule, err = m.NewSchedule(schedule); err != nil {
+					if errors.Is(err, manager.Error_InvalidSchedule) || errors.Is(err, manager.Error_InvalidJob) {
+						status = http.StatusBadRequest
+					} else if errors.Is(err, manager.Error_ScheduleExists) {
+						status = http.StatusConflict
+					} else {
+						status = http.StatusInternalServerError
+					}
+					body = "could not add schedule: " + err.Error()
+				} else {
+					body = schedule
+				}
+			}
+		} else {
+			body = "unsupported method: " + r.Method
+			status = http.StatusMethodNotAllowed
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// scheduleActionHandler handles requests for individual schedules, i.e. paths of the form "/schedule/{id}/{action}".
+func scheduleActionHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedule/"), "/"), "/")
+		if (len(pathParts) != 2) || (len(pathParts[0]) == 0) {
+			status = http.StatusNotFound
+			body = "not found: " + r.URL.Path
+		} else if r.Method != http.MethodPost {
+			status = http.StatusMethodNotAllowed
+			body = "unsupported method: " + r.Method
+		} else if (pathParts[1] != "enable") && (pathParts[1] != "disable") {
+			status = http.StatusNotFound
+			body = "unknown schedule action: " + pathParts[1]
+		} else if schedule, err := m.EnableSchedule(pathParts[0], pathParts[1] == "enable"); err != nil {
+			if errors.Is(err, manager.Error_ScheduleNotFound) {
+				status = http.StatusNotFound
+			} else {
+				status = http.StatusInternalServerError
+			}
+			body = "could not " + pathParts[1] + " schedule: " + err.Error()
+		} else {
+			body = schedule
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// pipelinesHandler lists running pipelines, or submits a new one, e.g. `{"stages": [{"name": "deploy", "type": "deploy",
+// "params": {"component": "ceramic", ...}}, {"name": "smoke", "type": "test_smoke", "onSuccess": ["deploy"]}]}`.
+func pipelinesHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		if r.Method == http.MethodGet {
+			if pipelines, err := m.ActivePipelines(); err != nil {
+				status = http.StatusInternalServerError
+				body = "could not list pipelines: " + err.Error()
+			} else {
+				body = pipelines
+			}
+		} else if r.Method == http.MethodPost {
+			pipeline := manager.Pipeline{}
+			if r.Header.Get("Content-Type") != "application/json" {
+				status = http.StatusUnsupportedMediaType
+				body = "content-type is not application/json"
+			} else if err := decodeJson(r, &pipeline); err != nil {
+				status = http.StatusBadRequest
+				body = err.Error()
+			} else if stageIdx := slices.IndexFunc(pipeline.Stages, func(stage manager.PipelineStage) bool {
+				return !canSubmitJob(r, job.JobState{Type: stage.Type, Params: stage.Params})
+			}); stageIdx != -1 {
+				status = http.StatusForbidden
+				body = fmt.Sprintf("forbidden: cannot submit %s job", pipeline.Stages[stageIdx].Type)
+			} else {
+				// Record the authenticated principal as the source of the pipeline's jobs so that it can't be spoofed
+				if p := requestPrincipal(r); len(p.name) > 0 {
+					for idx := range pipeline.Stages {
+						if pipeline.Stages[idx].Params == nil {
+							pipeline.Stages[idx].Params = make(map[string]interface{})
+						}
+						pipeline.Stages[idx].Params[job.JobParam_Source] = p.name
+					}
+				}
+				if pipeline, err = m.NewPipeline(pipeline); err != nil {
+					if errors.Is(err, manager.Error_InvalidPipeline) {
+						status = http.StatusBadRequest
+					} else {
+						status = http.StatusInternalServerError
+					}
+					body = "could not submit pipeline: " + err.Error()
+				} else {
+					body = pipeline
+				}
+			}
+		} else {
+			body = "unsupported method: " + r.Method
+			status = http.StatusMethodNotAllowed
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+// pipelineHandler returns the state of a pipeline, i.e. paths of the form "/pipeline/{id}"
+func pipelineHandler(m manager.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var body any
+		pipelineId := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pipeline/"), "/")
+		if (len(pipelineId) == 0) || strings.Contains(pipelineId, "/") {
+			status = http.StatusNotFound
+			body = "not found: " + r.URL.Path
+		} else if r.Method != http.MethodGet {
+			status = http.StatusMethodNotAllowed
+			body = "unsupported method: " + r.Method
+		} else if pipeline, err := m.GetPipeline(pipelineId); err != nil {
+			if errors.Is(err, manager.Error_PipelineNotFound) {
+				status = http.StatusNotFound
+			} else {
+				status = http.StatusInternalServerError
+			}
+			body = "could not get pipeline: " + err.Error()
+		} else {
+			body = pipeline
+		}
+		writeJsonResponse(w, body, status)
+	}
+}
+
+func parseJobFilter(query url.Values) (manager.JobFilter, error) {
+	filter := manager.JobFilter{
+		Type:      job.JobType(query.Get("type")),
+		Stage:     job.JobStage(query.Get("stage")),
+		Component: manager.DeployComponent(query.Get("component")),
+		Sha:       query.Get("sha"),
+		Source:    query.Get("source"),
+		Cursor:    query.Get("cursor"),
+	}
+	var err error
+	if start := query.Get("start"); len(start) > 0 {
+		if filter.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return manager.JobFilter{}, fmt.Errorf("invalid start time: %s", start)
+		}
+	}
+	if end := query.Get("end"); len(end) > 0 {
+		if filter.End, err = time.Parse(time.RFC3339, end); err != nil {
+			return manager.JobFilter{}, fmt.Errorf("invalid end time: %s", end)
+		}
+	}
+	if limit := query.Get("limit"); len(limit) > 0 {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return manager.JobFilter{}, fmt.Errorf("invalid limit: %s", limit)
+		}
+	}
+	// Return the newest jobs first unless asked otherwise
+	switch order := query.Get("order"); order {
+	case "", "desc":
+		filter.Asc = false
+	case "asc":
+		filter.Asc = true
+	default:
+		return manager.JobFilter{}, fmt.Errorf("invalid order: %s", order)
+	}
+	return filter, nil
+}
+
+// eventsHandler streams job updates as Server-Sent Events. Updates can be filtered by one or more job types and/or job
+// IDs, e.g. "/events?type=deploy&type=workflow" or "/events?job=<id>".
+func eventsHandler(events manager.EventStream) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if r.Method != http.MethodGet {
+			writeJsonResponse(w, "unsupported method: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		} else if !ok {
+			writeJsonResponse(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		jobTypes := r.URL.Query()["type"]
+		jobIds := r.URL.Query()["job"]
+		updates, unsubscribe := events.Subscribe(func(jobState job.JobState) bool {
+			return ((len(jobTypes) == 0) || slices.Contains(jobTypes, string(jobState.Type))) &&
+				((len(jobIds) == 0) || slices.Contains(jobIds, jobState.JobId))
+		})
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(eventStreamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case jobState, ok := <-updates:
+				if !ok {
+					// The subscription was dropped, so end the stream and let the client reconnect
+					return
+				}
+				jsonState, _ := json.Marshal(jobState)
+				fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", jobState.Stage, jobState.JobId, jsonState)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func decodeJson(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var unmarshalErr *json.UnmarshalTypeError
+		if errors.As(err, &unmarshalErr) {
+			return fmt.Errorf("wrong type for field: %s", unmarshalErr.Field)
+		}
+		return fmt.Errorf("bad request: %v", err)
+	}
+	return nil
+}
+
 func writeJsonResponse(w http.ResponseWriter, body any, httpStatusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(httpStatusCode)
@@ -89,6 +644,52 @@ func writeJsonResponse(w http.ResponseWriter, body any, httpStatusCode int) {
 	w.Write(jsonResp)
 }
 
+// leaderOnly sends requests that would change state on standby replicas to the leader, since standbys only serve read-only
+// API traffic.
+func leaderOnly(m manager.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method == http.MethodGet) || (r.Method == http.MethodHead) || (r.Method == http.MethodOptions) {
+				next.ServeHTTP(w, r)
+			} else {
+				requireLeader(m, next).ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// requireLeader forwards requests to the leader when received by a standby. Requests are rejected if the leader isn't
+// known, e.g. while a standby is taking over, so that clients can retry them.
+func requireLeader(m manager.Manager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.IsLeader() {
+			next.ServeHTTP(w, r)
+		} else if leaderAddress := m.LeaderAddress(); (len(leaderAddress) == 0) || (len(r.Header.Get(forwardedHeader)) > 0) {
+			writeJsonResponse(w, "unavailable: "+manager.Error_NotLeader.Error(), http.StatusServiceUnavailable)
+		} else if leaderUrl, err := url.Parse(leaderAddress); err != nil {
+			writeJsonResponse(w, "unavailable: invalid leader address: "+leaderAddress, http.StatusServiceUnavailable)
+		} else {
+			forwardToLeader(leaderUrl).ServeHTTP(w, r)
+		}
+	})
+}
+
+func forwardToLeader(leaderUrl *url.URL) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(leaderUrl)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Set(forwardedHeader, "true")
+	}
+	// Send event stream updates on as soon as they're received
+	proxy.FlushInterval = -1
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("forwardToLeader: failed to forward request: %v, %s, %s", err, r.URL.Path, leaderUrl)
+		writeJsonResponse(w, "unavailable: failed to reach leader", http.StatusServiceUnavailable)
+	}
+	return proxy
+}
+
 func logging(logger *log.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
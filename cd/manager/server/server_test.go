@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/3box/pipeline-tools/cd/manager"
+)
+
+// testManager only implements leadership, which is all that routing between replicas depends on
+type testManager struct {
+	manager.Manager
+	isLeader      bool
+	leaderAddress string
+}
+
+func (m testManager) IsLeader() bool {
+	return m.isLeader
+}
+
+func (m testManager) LeaderAddress() string {
+	return m.leaderAddress
+}
+
+func TestLeaderOnly(t *testing.T) {
+	// Both replicas respond with where the request was handled, and whether it was forwarded
+	replicaHandler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handled-By", name)
+			w.Header().Set("X-Forwarded", r.Header.Get(forwardedHeader))
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	leader := httptest.NewServer(replicaHandler("leader"))
+	defer leader.Close()
+
+	tests := []struct {
+		name      string
+		m         testManager
+		method    string
+		forwarded bool
+		status    int
+		handledBy string
+	}{
+		{"leader handles writes", testManager{isLeader: true}, http.MethodPost, false, http.StatusOK, "standby"},
+		{"standby handles reads", testManager{leaderAddress: leader.URL}, http.MethodGet, false, http.StatusOK, "standby"},
+		{"standby forwards writes", testManager{leaderAddress: leader.URL}, http.MethodPost, false, http.StatusOK, "leader"},
+		{"standby forwards deletes", testManager{leaderAddress: leader.URL}, http.MethodDelete, false, http.StatusOK, "leader"},
+		{"unknown leader", testManager{}, http.MethodPost, false, http.StatusServiceUnavailable, ""},
+		{"unreachable leader", testManager{leaderAddress: "http://127.0.0.1:1"}, http.MethodPost, false, http.StatusServiceUnavailable, ""},
+		// Requests aren't forwarded more than once, e.g. if leadership changed while the request was being forwarded
+		{"already forwarded", testManager{leaderAddress: leader.URL}, http.MethodPost, true, http.StatusServiceUnavailable, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Handlers of the replica receiving the request are named "standby", even if the replica is the leader
+			standby := httptest.NewServer(leaderOnly(test.m)(replicaHandler("standby")))
+			defer standby.Close()
+
+			req, err := http.NewRequest(test.method, standby.URL+"/job", nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			if test.forwarded {
+				req.Header.Set(forwardedHeader, "true")
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Fatalf("expected status: %d, found: %d", test.status, resp.StatusCode)
+			} else if handledBy := resp.Header.Get("X-Handled-By"); handledBy != test.handledBy {
+				t.Fatalf("expected request handled by: %q, found: %q", test.handledBy, handledBy)
+			} else if (handledBy == "leader") && (resp.Header.Get("X-Forwarded") != "true") {
+				t.Fatalf("expected forwarded request to be marked")
+			}
+		})
+	}
+}
@@ -2,17 +2,52 @@ package manager
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
+
 	"github.com/3box/pipeline-tools/cd/manager/common/job"
 )
 
 const commitHashRegex = "[0-9a-f]{40}"
 const casV5Version = "5"
 
+// IdempotencyWindow returns how long idempotency keys are remembered for, as configured by `IDEMPOTENCY_WINDOW`
+func IdempotencyWindow() (time.Duration, error) {
+	if configIdempotencyWindow, found := os.LookupEnv("IDEMPOTENCY_WINDOW"); !found {
+		return DefaultIdempotencyWindow, nil
+	} else if parsedIdempotencyWindow, err := time.ParseDuration(configIdempotencyWindow); err != nil {
+		return 0, fmt.Errorf("idempotencyWindow: invalid window: %v", err)
+	} else if parsedIdempotencyWindow <= 0 {
+		return 0, fmt.Errorf("idempotencyWindow: window must be positive: %s", parsedIdempotencyWindow)
+	} else {
+		return parsedIdempotencyWindow, nil
+	}
+}
+
+// DecodeJobLayout converts a deploy job's layout param, as decoded from the database, back into a `Layout` structure. The
+// params are updated in place.
+func DecodeJobLayout(jobState job.JobState) error {
+	if jobState.Type == job.JobType_Deploy {
+		if layout, found := jobState.Params[job.DeployJobParam_Layout].(map[string]interface{}); found {
+			var marshaledLayout Layout
+			if err := mapstructure.Decode(layout, &marshaledLayout); err != nil {
+				return err
+			}
+			jobState.Params[job.DeployJobParam_Layout] = marshaledLayout
+		}
+	}
+	return nil
+}
+
 func PrintJob(jobStates ...job.JobState) string {
 	prettyString := ""
 	for _, jobState := range jobStates {
@@ -56,6 +91,158 @@ func IsV5WorkerJob(jobState job.JobState) bool {
 	return false
 }
 
+// Overlaps returns true if two layouts have any services or tasks in common
+func (l Layout) Overlaps(other Layout) bool {
+	for cluster, clusterLayout := range l.Clusters {
+		if otherClusterLayout, found := other.Clusters[cluster]; found && (clusterLayout != nil) && (otherClusterLayout != nil) {
+			if clusterLayout.ServiceTasks.overlaps(otherClusterLayout.ServiceTasks) || clusterLayout.Tasks.overlaps(otherClusterLayout.Tasks) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *TaskSet) overlaps(other *TaskSet) bool {
+	if (t != nil) && (other != nil) {
+		for name := range t.Tasks {
+			if _, found := other.Tasks[name]; found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches returns true if a job record satisfies all the criteria in the filter. The cursor and page size are not
+// considered here since they depend on the order in which records are iterated.
+func (f JobFilter) Matches(jobState job.JobState) bool {
+	if (len(f.Type) > 0) && (jobState.Type != f.Type) {
+		return false
+	} else if (len(f.Stage) > 0) && (jobState.Stage != f.Stage) {
+		return false
+	} else if !f.Start.IsZero() && jobState.Ts.Before(f.Start) {
+		return false
+	} else if !f.End.IsZero() && jobState.Ts.After(f.End) {
+		return false
+	} else if component, _ := jobState.Params[job.DeployJobParam_Component].(string); (len(f.Component) > 0) && (component != string(f.Component)) {
+		return false
+	} else if source, _ := jobState.Params[job.JobParam_Source].(string); (len(f.Source) > 0) && (source != f.Source) {
+		return false
+	} else if len(f.Sha) > 0 {
+		sha, _ := jobState.Params[job.DeployJobParam_Sha].(string)
+		shaTag, _ := jobState.Params[job.DeployJobParam_ShaTag].(string)
+		deployTag, _ := jobState.Params[job.DeployJobParam_DeployTag].(string)
+		return (sha == f.Sha) || (shaTag == f.Sha) || ((len(deployTag) > 0) && strings.HasPrefix(deployTag, f.Sha))
+	}
+	return true
+}
+
+// IsAfterCursor returns true if a job record comes after the position denoted by a cursor in the specified order. Job
+// records are ordered by timestamp, with ties broken by the unique identifier of each record.
+func IsAfterCursor(jobState job.JobState, cursorTs time.Time, cursorId string, asc bool) bool {
+	if jobState.Ts.Equal(cursorTs) {
+		return (asc && (jobState.Id > cursorId)) || (!asc && (jobState.Id < cursorId))
+	}
+	return asc == jobState.Ts.After(cursorTs)
+}
+
+// LatestQueuedJobs returns the latest version of each job from a search of queued records, in order of the records'
+// timestamps. Jobs updated while waiting have a record for each version, and jobs rescheduled for later also have a
+// "scheduled" record, so the scheduled records found from the start of the same search are needed too.
+//
+// Jobs that haven't been updated since they were first queued have no other records, so their queued record is their
+// latest state. For all other jobs, the queued record is checked against the job's current state, which has the job's
+// latest version.
+func LatestQueuedJobs(queuedJobs, scheduledJobs []job.JobState, getJob func(string) (job.JobState, bool, error)) []job.JobState {
+	latestJobs := make(map[string]job.JobState, len(queuedJobs))
+	for _, jobState := range append(append([]job.JobState{}, queuedJobs...), scheduledJobs...) {
+		if latestJob, found := latestJobs[jobState.JobId]; !found || job.IsLaterJobState(jobState, latestJob) {
+			latestJobs[jobState.JobId] = jobState
+		}
+	}
+	jobs := make([]job.JobState, 0, len(queuedJobs))
+	for _, jobState := range queuedJobs {
+		// Skip records replaced by a later version, including ones that were rescheduled for later
+		if jobState.Id != latestJobs[jobState.JobId].Id {
+			continue
+		} else if version := job.JobVersion(jobState); version > 0 {
+			if latestJob, found, err := getJob(jobState.JobId); err != nil {
+				// Process the record anyway. If the job has been updated since, updating it will fail with a conflict.
+				log.Printf("queuedJobs: failed to lookup latest job state: %v, %s", err, PrintJob(jobState))
+			} else if found && (job.JobVersion(latestJob) != version) {
+				continue
+			}
+		}
+		jobs = append(jobs, jobState)
+	}
+	return jobs
+}
+
+// UnmarshalJSON defaults to no concurrency limit if one isn't specified
+func (p *JobPolicy) UnmarshalJSON(data []byte) error {
+	type jobPolicy JobPolicy
+	policy := jobPolicy{MaxConcurrency: -1}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+	*p = JobPolicy(policy)
+	return nil
+}
+
+// UnmarshalJSON parses backoffs as duration strings, e.g. "30s"
+func (p *RetryPolicy) UnmarshalJSON(data []byte) error {
+	var policy struct {
+		MaxAttempts int    `json:"maxAttempts"`
+		Backoff     string `json:"backoff"`
+		MaxBackoff  string `json:"maxBackoff"`
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+	p.MaxAttempts = policy.MaxAttempts
+	var err error
+	if len(policy.Backoff) > 0 {
+		if p.Backoff, err = time.ParseDuration(policy.Backoff); err != nil {
+			return err
+		}
+	}
+	if len(policy.MaxBackoff) > 0 {
+		if p.MaxBackoff, err = time.ParseDuration(policy.MaxBackoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delay returns how long to wait before a retry, starting from 1 for the first retry
+func (p RetryPolicy) Delay(attempt int64) time.Duration {
+	delay := p.Backoff
+	for i := int64(1); (i < attempt) && ((p.MaxBackoff <= 0) || (delay < p.MaxBackoff)); i++ {
+		delay *= 2
+	}
+	if (p.MaxBackoff > 0) && (delay > p.MaxBackoff) {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+func EncodeJobCursor(jobState job.JobState) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(jobState.Ts.UnixNano(), 10) + "," + jobState.Id))
+}
+
+func DecodeJobCursor(cursor string) (time.Time, string, error) {
+	if decodedCursor, err := base64.RawURLEncoding.DecodeString(cursor); err != nil {
+		return time.Time{}, "", Error_InvalidCursor
+	} else if cursorParts := strings.SplitN(string(decodedCursor), ",", 2); len(cursorParts) != 2 {
+		return time.Time{}, "", Error_InvalidCursor
+	} else if nsec, err := strconv.ParseInt(cursorParts[0], 10, 64); err != nil {
+		return time.Time{}, "", Error_InvalidCursor
+	} else {
+		return time.Unix(0, nsec), cursorParts[1], nil
+	}
+}
+
 // AdvanceJob will move a JobState to a new JobStage in the Database and send an appropriate notification
 func AdvanceJob(jobState job.JobState, jobStage job.JobStage, ts time.Time, err error, db Database, notifs Notifs) (job.JobState, error) {
 	jobState.Stage = jobStage
@@ -64,14 +251,19 @@ func AdvanceJob(jobState job.JobState, jobStage job.JobStage, ts time.Time, err
 	}
 	// Store how much time the job spent during its previous stage. We only care about active jobs, i.e. those that have
 	// progressed beyond the "dequeued" stage.
-	if jobStage != job.JobStage_Dequeued {
+	if !job.IsWaitingJob(jobState) {
 		jobState.Params[job.JobParam_WaitTime] = time.Since(jobState.Ts).String()
 	}
 	jobState.Ts = ts
+	// Errors are only recorded with the update they caused, so that later updates don't repeat them
 	if err != nil {
 		jobState.Params[job.JobParam_Error] = err.Error()
+	} else {
+		delete(jobState.Params, job.JobParam_Error)
 	}
 	if err = db.AdvanceJob(jobState); err == nil {
+		// The database stored the new state with the job's next version
+		jobState.Params[job.JobParam_Version] = job.JobVersion(jobState) + 1
 		// Only send a notification if the DB update was successful
 		notifs.NotifyJob(jobState)
 	}
@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/3box/pipeline-tools/cd/manager/common/job"
+)
+
+func TestLatestQueuedJobs(t *testing.T) {
+	now := time.Now()
+	record := func(jobId string, stage job.JobStage, ts time.Time, version int64) job.JobState {
+		params := map[string]interface{}{}
+		if version > 0 {
+			params[job.JobParam_Version] = float64(version)
+		}
+		return job.JobState{Id: jobId + "/" + string(stage) + "/" + ts.Format(time.RFC3339Nano), JobId: jobId, Stage: stage, Ts: ts, Params: params}
+	}
+	tests := []struct {
+		name          string
+		queuedJobs    []job.JobState
+		scheduledJobs []job.JobState
+		currentJobs   []job.JobState
+		getJobErr     error
+		expected      []job.JobState
+	}{
+		{
+			name:       "not updated since queued",
+			queuedJobs: []job.JobState{record("a", job.JobStage_Queued, now.Add(-2*time.Minute), 0), record("b", job.JobStage_Queued, now.Add(-time.Minute), 0)},
+			expected:   []job.JobState{record("a", job.JobStage_Queued, now.Add(-2*time.Minute), 0), record("b", job.JobStage_Queued, now.Add(-time.Minute), 0)},
+		},
+		{
+			name:        "updated while queued",
+			queuedJobs:  []job.JobState{record("a", job.JobStage_Queued, now.Add(-2*time.Minute), 0), record("a", job.JobStage_Queued, now.Add(-3*time.Minute), 1)},
+			currentJobs: []job.JobState{record("a", job.JobStage_Queued, now.Add(-3*time.Minute), 1)},
+			expected:    []job.JobState{record("a", job.JobStage_Queued, now.Add(-3*time.Minute), 1)},
+		},
+		{
+			name:          "rescheduled for later",
+			queuedJobs:    []job.JobState{record("a", job.JobStage_Queued, now.Add(-time.Minute), 0)},
+			scheduledJobs: []job.JobState{record("a", job.JobStage_Scheduled, now.Add(time.Hour), 1)},
+			currentJobs:   []job.JobState{record("a", job.JobStage_Scheduled, now.Add(time.Hour), 1)},
+			expected:      []job.JobState{},
+		},
+		{
+			name:          "came due",
+			queuedJobs:    []job.JobState{record("a", job.JobStage_Queued, now, 1)},
+			scheduledJobs: []job.JobState{record("a", job.JobStage_Scheduled, now.Add(-time.Minute), 0)},
+			currentJobs:   []job.JobState{record("a", job.JobStage_Queued, now, 1)},
+			expected:      []job.JobState{record("a", job.JobStage_Queued, now, 1)},
+		},
+		{
+			name:          "rescheduled, then came due",
+			queuedJobs:    []job.JobState{record("a", job.JobStage_Queued, now.Add(-2*time.Minute), 0), record("a", job.JobStage_Queued, now, 2)},
+			scheduledJobs: []job.JobState{record("a", job.JobStage_Scheduled, now.Add(-time.Minute), 1)},
+			currentJobs:   []job.JobState{record("a", job.JobStage_Queued, now, 2)},
+			expected:      []job.JobState{record("a", job.JobStage_Queued, now, 2)},
+		},
+		{
+			// The job was updated in a way that didn't show up in the search, e.g. it was skipped
+			name:        "updated since the search",
+			queuedJobs:  []job.JobState{record("a", job.JobStage_Queued, now, 1)},
+			currentJobs: []job.JobState{record("a", job.JobStage_Skipped, now, 2)},
+			expected:    []job.JobState{},
+		},
+		{
+			name:       "failed lookup",
+			queuedJobs: []job.JobState{record("a", job.JobStage_Queued, now, 1)},
+			getJobErr:  errors.New("lookup failed"),
+			expected:   []job.JobState{record("a", job.JobStage_Queued, now, 1)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookups := 0
+			jobs := LatestQueuedJobs(test.queuedJobs, test.scheduledJobs, func(jobId string) (job.JobState, bool, error) {
+				lookups++
+				if test.getJobErr != nil {
+					return job.JobState{}, false, test.getJobErr
+				}
+				for _, currentJob := range test.currentJobs {
+					if currentJob.JobId == jobId {
+						return currentJob, true, nil
+					}
+				}
+				return job.JobState{}, false, nil
+			})
+			if len(jobs) != len(test.expected) {
+				t.Fatalf("expected jobs: %s, found: %s", PrintJob(test.expected...), PrintJob(jobs...))
+			}
+			for idx, jobState := range jobs {
+				if jobState.Id != test.expected[idx].Id {
+					t.Fatalf("expected jobs: %s, found: %s", PrintJob(test.expected...), PrintJob(jobs...))
+				}
+			}
+			// Jobs that haven't been updated since they were queued are never looked up
+			for _, jobState := range jobs {
+				if (job.JobVersion(jobState) == 0) && (lookups > 0) {
+					t.Fatalf("unexpected lookup of job not updated since it was queued: %s", PrintJob(jobState))
+				}
+			}
+		})
+	}
+}
+
+// testDatabase only records job updates, which is all that `AdvanceJob` needs
+type testDatabase struct {
+	Database
+	jobs []job.JobState
+}
+
+func (db *testDatabase) AdvanceJob(jobState job.JobState) error {
+	db.jobs = append(db.jobs, jobState)
+	return nil
+}
+
+type testNotifs struct{}
+
+func (testNotifs) NotifyJob(...job.JobState) {}
+
+func TestAdvanceJobErrors(t *testing.T) {
+	db := &testDatabase{}
+	jobState := job.JobState{JobId: "a", Stage: job.JobStage_Started, Ts: time.Now(), Params: map[string]interface{}{}}
+	jobState, err := AdvanceJob(jobState, jobState.Stage, time.Now(), errors.New("transient failure"), db, testNotifs{})
+	if err != nil {
+		t.Fatalf("failed to advance job: %v", err)
+	} else if jobError, _ := db.jobs[0].Params[job.JobParam_Error].(string); jobError != "transient failure" {
+		t.Fatalf("expected error to be recorded, found: %q", jobError)
+	}
+	// Later updates don't repeat the error
+	if _, err = AdvanceJob(jobState, job.JobStage_Completed, time.Now(), nil, db, testNotifs{}); err != nil {
+		t.Fatalf("failed to advance job: %v", err)
+	} else if jobError, found := db.jobs[1].Params[job.JobParam_Error]; found {
+		t.Fatalf("expected error to be cleared, found: %v", jobError)
+	}
+}